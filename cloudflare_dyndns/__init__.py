@@ -0,0 +1,21 @@
+"""Dynamic DNS client for Cloudflare.
+
+The updater can be used as a library too, see Updater.
+"""
+from .cloudflare import CloudFlareError, CloudFlareWrapper
+from .ip_services import IPServiceError, get_ipv4, get_ipv6
+from .notifiers import Notifier
+from .report import Report, UpdateResult
+from .updater import Updater
+
+__all__ = [
+    "CloudFlareError",
+    "CloudFlareWrapper",
+    "IPServiceError",
+    "Notifier",
+    "Report",
+    "UpdateResult",
+    "Updater",
+    "get_ipv4",
+    "get_ipv6",
+]
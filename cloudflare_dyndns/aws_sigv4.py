@@ -0,0 +1,82 @@
+import datetime
+import hashlib
+import hmac
+import urllib.parse
+from typing import Dict, Optional
+
+
+ALGORITHM = "AWS4-HMAC-SHA256"
+
+
+def _hmac(key: bytes, message: str) -> bytes:
+    return hmac.new(key, message.encode(), hashlib.sha256).digest()
+
+
+def _quote(value: str) -> str:
+    return urllib.parse.quote(value, safe="-_.~")
+
+
+def _quote_path(path: str) -> str:
+    return "/".join(_quote(segment) for segment in path.split("/"))
+
+
+def sign_request(
+    method: str,
+    url: str,
+    body: bytes,
+    access_key: str,
+    secret_key: str,
+    region: str,
+    service: str,
+    session_token: Optional[str] = None,
+    now: Optional[datetime.datetime] = None,
+) -> Dict[str, str]:
+    """Returns the headers needed for an AWS Signature Version 4 signed request.
+    See: https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+    """
+    now = now or datetime.datetime.now(datetime.timezone.utc)
+    amz_date = now.strftime("%Y%m%dT%H%M%SZ")
+    date = now.strftime("%Y%m%d")
+    parts = urllib.parse.urlsplit(url)
+
+    headers = {"host": parts.netloc, "x-amz-date": amz_date}
+    if session_token:
+        headers["x-amz-security-token"] = session_token
+    signed_headers = ";".join(sorted(headers))
+    canonical_headers = "".join(f"{name}:{headers[name]}\n" for name in sorted(headers))
+    query = urllib.parse.parse_qsl(parts.query, keep_blank_values=True)
+    canonical_query = "&".join(
+        f"{_quote(name)}={_quote(value)}" for name, value in sorted(query)
+    )
+    canonical_request = "\n".join(
+        [
+            method,
+            _quote_path(parts.path or "/"),
+            canonical_query,
+            canonical_headers,
+            signed_headers,
+            hashlib.sha256(body).hexdigest(),
+        ]
+    )
+
+    credential_scope = f"{date}/{region}/{service}/aws4_request"
+    string_to_sign = "\n".join(
+        [
+            ALGORITHM,
+            amz_date,
+            credential_scope,
+            hashlib.sha256(canonical_request.encode()).hexdigest(),
+        ]
+    )
+    key = _hmac(f"AWS4{secret_key}".encode(), date)
+    for scope_part in (region, service, "aws4_request"):
+        key = _hmac(key, scope_part)
+    signature = hmac.new(key, string_to_sign.encode(), hashlib.sha256).hexdigest()
+
+    authorization = (
+        f"{ALGORITHM} Credential={access_key}/{credential_scope}, "
+        f"SignedHeaders={signed_headers}, Signature={signature}"
+    )
+    signed = {name: value for name, value in headers.items() if name != "host"}
+    signed["Authorization"] = authorization
+    return signed
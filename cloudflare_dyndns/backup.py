@@ -0,0 +1,99 @@
+"""The records as they were before the tool changed them, so an accidental mass
+update can be inspected and reverted after the fact. Only the latest changes
+are kept, in a file next to the cache.
+"""
+import threading
+import time
+from pathlib import Path
+from typing import Dict, List, Optional, Sequence, Tuple, Union
+from pydantic import BaseModel
+from .types import RecordType
+from . import printer
+
+
+DEFAULT_KEEP = 500
+
+
+class RecordBackup(BaseModel):
+    # timestamp of the change
+    time: float
+    domain: str
+    record_type: RecordType
+    zone_id: str
+    record_id: str
+    # the record as the API returned it before the change, None when it was created
+    record: Optional[dict] = None
+
+
+def backup_file(cache_file: Union[str, Path]) -> Path:
+    cache_file = Path(cache_file).expanduser()
+    return cache_file.with_name(cache_file.name + ".backups")
+
+
+class BackupStore:
+    """One backup per line, the oldest ones are dropped over the limit."""
+
+    def __init__(self, path: Union[str, Path], keep: int = DEFAULT_KEEP):
+        self._path = Path(path).expanduser()
+        self._keep = keep
+        self._lock = threading.Lock()
+
+    def load(self) -> List[RecordBackup]:
+        try:
+            lines = self._path.read_text().splitlines()
+        except FileNotFoundError:
+            return []
+        backups = []
+        for line in lines:
+            try:
+                backups.append(RecordBackup.parse_raw(line))
+            except ValueError:
+                printer.warning(f"Invalid line in the backup file: {self._path}")
+        return backups
+
+    def save(
+        self,
+        domain: str,
+        record_type: RecordType,
+        zone_id: str,
+        record_id: str,
+        record: Optional[dict],
+    ):
+        backup = RecordBackup(
+            time=time.time(),
+            domain=domain,
+            record_type=record_type,
+            zone_id=zone_id,
+            record_id=record_id,
+            record=record,
+        )
+        with self._lock:
+            backups = [*self.load(), backup][-self._keep :]
+            try:
+                self._path.parent.mkdir(exist_ok=True, parents=True)
+                tmp_path = self._path.with_name(self._path.name + ".tmp")
+                tmp_path.write_text("".join(b.json() + "\n" for b in backups))
+                tmp_path.replace(self._path)
+            except OSError as e:
+                printer.warning(f"Failed to back up the record of {domain}: {e}")
+
+
+def select_backups(
+    backups: List[RecordBackup],
+    since: Optional[float] = None,
+    domains: Sequence[str] = (),
+) -> List[RecordBackup]:
+    """The backup to restore of each record: the state before the first change
+    since the given time, or before the last change without it.
+    """
+    selected: Dict[Tuple[str, str], RecordBackup] = {}
+    for backup in backups:
+        if domains and backup.domain not in domains:
+            continue
+        elif since is not None and backup.time < since:
+            continue
+        key = (backup.zone_id, backup.record_id)
+        # the file is in chronological order
+        if since is None or key not in selected:
+            selected[key] = backup
+    return sorted(selected.values(), key=lambda b: (b.domain, b.record_type))
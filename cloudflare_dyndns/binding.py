@@ -0,0 +1,132 @@
+"""Makes the outbound connections leave through a chosen interface or from a
+chosen source address, for hosts with multiple WAN links, and resolves their
+hostnames with the configured resolver.
+"""
+import contextlib
+import contextvars
+import ipaddress
+import socket
+import sys
+from typing import Callable, Dict, List, Optional
+import urllib3.util.connection
+
+
+# from linux/socket.h, not every Python version exposes it
+SO_BINDTODEVICE = getattr(socket, "SO_BINDTODEVICE", 25)
+
+_interface: Optional[str] = None
+_source_addresses: Dict[int, str] = {}
+_resolve: Callable = socket.getaddrinfo
+# interface or source address overriding the above, e.g. for detecting the
+# address of one WAN link
+_link: contextvars.ContextVar[Optional[str]] = contextvars.ContextVar(
+    "link", default=None
+)
+
+
+def bind(sock: socket.socket):
+    """Applies the settings on a socket before it connects or sends anything."""
+    link = _link.get()
+    if link is not None:
+        _bind_link(sock, link)
+        return
+    if _interface:
+        sock.setsockopt(socket.SOL_SOCKET, SO_BINDTODEVICE, _interface.encode())
+    source_address = _source_addresses.get(sock.family)
+    if source_address:
+        sock.bind((source_address, 0))
+
+
+def _bind_link(sock: socket.socket, link: str):
+    try:
+        address = ipaddress.ip_address(link)
+    except ValueError:
+        sock.setsockopt(socket.SOL_SOCKET, SO_BINDTODEVICE, link.encode())
+        return
+    family = socket.AF_INET if address.version == 4 else socket.AF_INET6
+    if sock.family == family:
+        sock.bind((link, 0))
+
+
+def check_link(link: str):
+    """Raises ValueError when the link can't be used on this system."""
+    try:
+        ipaddress.ip_address(link)
+    except ValueError:
+        if not sys.platform.startswith("linux"):
+            raise ValueError("Binding to an interface only works on Linux")
+
+
+def current_link() -> Optional[str]:
+    return _link.get()
+
+
+@contextlib.contextmanager
+def bound(link: str):
+    """Sockets created in the block are bound to this interface or source
+    address, instead of the global settings.
+    """
+    urllib3.util.connection.create_connection = create_connection
+    token = _link.set(link)
+    try:
+        yield
+    finally:
+        _link.reset(token)
+
+
+def getaddrinfo(host: str, port, family: int = 0, type: int = 0):
+    """socket.getaddrinfo() with the resolver set by use_resolver()."""
+    return _resolve(host, port, family, type)
+
+
+def create_connection(
+    address,
+    timeout=socket._GLOBAL_DEFAULT_TIMEOUT,
+    source_address=None,
+    socket_options=None,
+):
+    """Replacement of the urllib3 function, which binds the socket with our
+    settings, so requests made through the requests library use them too.
+    """
+    host, port = address
+    error = None
+    family = urllib3.util.connection.allowed_gai_family()
+    for family, socktype, proto, _, sockaddr in getaddrinfo(
+        host.strip("[]"), port, family, socket.SOCK_STREAM
+    ):
+        sock = socket.socket(family, socktype, proto)
+        try:
+            for option in socket_options or ():
+                sock.setsockopt(*option)
+            bind(sock)
+            if timeout is not socket._GLOBAL_DEFAULT_TIMEOUT:
+                sock.settimeout(timeout)
+            sock.connect(sockaddr)
+            return sock
+        except OSError as e:
+            error = e
+            sock.close()
+    raise error or OSError(f"Cannot resolve {host}")
+
+
+def configure(interface: Optional[str], source_addresses: List[str]):
+    global _interface
+    if interface:
+        check_link(interface)
+    for address in source_addresses:
+        version = ipaddress.ip_address(address).version
+        family = socket.AF_INET if version == 4 else socket.AF_INET6
+        if family in _source_addresses:
+            raise ValueError("Only one IPv4 and one IPv6 source address can be given")
+        _source_addresses[family] = address
+    _interface = interface
+    urllib3.util.connection.create_connection = create_connection
+
+
+def use_resolver(resolve: Callable):
+    """Resolve every hostname with this function instead of the system resolver.
+    It has to work like socket.getaddrinfo().
+    """
+    global _resolve
+    _resolve = resolve
+    urllib3.util.connection.create_connection = create_connection
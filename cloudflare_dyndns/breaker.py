@@ -0,0 +1,54 @@
+"""IP sources which keep failing, e.g. a discontinued service, are skipped for a
+while (the circuit is open) instead of waiting for them in every run. The state
+is kept in the cache, so it works across the runs of a cron job too.
+"""
+import time
+from typing import Dict, Optional
+from pydantic import BaseModel
+
+
+# consecutive failures before a source is skipped
+FAILURE_THRESHOLD = 3
+# seconds a failing source is skipped for, then it gets one more chance
+COOLDOWN = 3600
+
+
+class SourceState(BaseModel):
+    consecutive_failures: int = 0
+    open_until: Optional[float] = None
+
+
+_states: Dict[str, SourceState] = {}
+
+
+def use(states: Dict[str, SourceState]):
+    """Keep the state in this dict from now on, e.g. the one in the cache."""
+    global _states
+    _states = states
+
+
+def get() -> Dict[str, SourceState]:
+    return _states
+
+
+def open_until(name: str) -> Optional[float]:
+    """Until when the source is skipped, None when it can be used."""
+    state = _states.get(name)
+    if state is None or state.open_until is None or state.open_until <= time.time():
+        return None
+    return state.open_until
+
+
+def record_success(name: str):
+    _states.pop(name, None)
+
+
+def record_failure(name: str) -> bool:
+    """Returns whether the circuit has just been opened."""
+    state = _states.setdefault(name, SourceState())
+    state.consecutive_failures += 1
+    # after the cooldown, one more failure is enough to skip it again
+    if state.consecutive_failures >= FAILURE_THRESHOLD:
+        state.open_until = time.time() + COOLDOWN
+        return True
+    return False
@@ -0,0 +1,57 @@
+"""Where the running program came from, so bug reports from distribution packages,
+Docker images and binaries can be traced to an exact build. Build pipelines write
+the commit and the build date into _build.json next to this module, for example:
+
+    {"commit": "1a2b3c4", "build_date": "2024-05-01T12:00:00Z"}
+"""
+import functools
+import json
+import platform
+import sys
+from pathlib import Path
+from typing import List, Optional
+from pydantic import BaseModel
+from .update_check import installed_version
+
+
+BUILD_FILE = Path(__file__).with_name("_build.json")
+
+
+class BuildInfo(BaseModel):
+    version: str
+    commit: Optional[str] = None
+    build_date: Optional[str] = None
+    python: str
+    platform: str
+
+
+def read_build_file(path: Path) -> dict:
+    try:
+        data = json.loads(path.read_text())
+    except (OSError, ValueError):
+        # running from a source checkout or a build which didn't write it
+        return {}
+    return data if isinstance(data, dict) else {}
+
+
+@functools.lru_cache(maxsize=None)
+def get() -> BuildInfo:
+    data = read_build_file(BUILD_FILE)
+    return BuildInfo(
+        version=installed_version() or "dev",
+        # empty build arguments mean unknown too
+        commit=data.get("commit") or None,
+        build_date=data.get("build_date") or None,
+        python=f"{platform.python_implementation()} {platform.python_version()}",
+        platform=f"{sys.platform}-{platform.machine()}",
+    )
+
+
+def describe(info: BuildInfo) -> List[str]:
+    return [
+        f"cloudflare-dyndns {info.version}",
+        f"Commit:      {info.commit or 'unknown'}",
+        f"Build date:  {info.build_date or 'unknown'}",
+        f"Python:      {info.python}",
+        f"Platform:    {info.platform}",
+    ]
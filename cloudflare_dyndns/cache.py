@@ -1,6 +1,9 @@
+import threading
+import time
 from pathlib import Path
-from typing import Dict, Optional, Union
+from typing import Dict, List, Optional, Union
 from pydantic import BaseModel
+from .breaker import SourceState
 from .types import Domain, IPAddress
 from . import printer
 
@@ -15,20 +18,56 @@ class ZoneRecord(BaseModel):
     zone_id: str
     record_id: str
     proxied: bool = False
+    # when the record written by something else was adopted with --takeover
+    taken_over: Optional[float] = None
+    # only set with --adaptive-ttl, otherwise it's the default of the provider
+    ttl: Optional[int] = None
+
+
+class DomainError(BaseModel):
+    message: str
+    # when it failed the last time
+    time: float
+
+
+class IPChange(BaseModel):
+    time: float
+    address: IPAddress
 
 
 class IPCache(BaseModel):
     address: Optional[IPAddress] = None
+    # every address of the round-robin records with multiple WAN links
+    addresses: List[IPAddress] = []
     updated_domains: Dict[Domain, ZoneRecord] = dict()
+    # timestamp of the last write to Cloudflare
+    last_update: Optional[float] = None
+    # every address seen, with the time it was first seen
+    changes: List[IPChange] = []
+    # the last error of the domains failing to update, until they succeed
+    last_errors: Dict[Domain, DomainError] = dict()
 
     def clear(self):
         self.address = None
+        self.addresses = []
         self.updated_domains = dict()
 
 
 class Cache(BaseModel):
     ipv4 = IPCache()
     ipv6 = IPCache()
+    # domains updated through other WAN links, by interface or source address
+    link_ipv4: Dict[str, IPCache] = dict()
+    link_ipv6: Dict[str, IPCache] = dict()
+    # timestamp of the last check for a new release
+    last_update_check: Optional[float] = None
+    # timestamp of the last check of the expiry date of the API token
+    last_token_check: Optional[float] = None
+    # when the records were last compared with the ones at the provider
+    last_verification: Optional[float] = None
+    runs_since_verification: int = 0
+    # IP sources which failed in the last runs, by description
+    ip_sources: Dict[str, SourceState] = dict()
 
 
 class CacheManager:
@@ -65,8 +104,74 @@ class CacheManager:
         if self._debug:
             printer.info(f"Saving cache: {cache_json}")
         printer.info(f"Saving cache to: {self._path}")
-        self._path.write_text(cache_json)
+        # write to a temporary file first, so an interrupted save can't corrupt it
+        tmp_path = self._path.with_name(self._path.name + ".tmp")
+        tmp_path.write_text(cache_json)
+        tmp_path.replace(self._path)
 
     def delete(self):
         printer.warning(f"Deleting cache at: {self._path}")
         self._path.unlink(missing_ok=True)
+
+
+# zones are rarely deleted and recreated, a wrong ID is noticed anyway
+ZONE_CACHE_TTL = 86400
+
+
+class ZoneEntry(BaseModel):
+    zone_id: str
+    # timestamp of the lookup
+    fetched: float
+
+
+class ZoneCacheData(BaseModel):
+    zones: Dict[str, ZoneEntry] = dict()
+
+
+class ZoneCache:
+    """Zone name to ID mapping on disk, so routine runs don't have to look up
+    the zones at all. Entries expire after the TTL.
+    """
+
+    def __init__(self, path: Union[str, Path], ttl: int = ZONE_CACHE_TTL):
+        self._path = Path(path).expanduser()
+        self._ttl = ttl
+        self._data: Optional[ZoneCacheData] = None
+        self._lock = threading.Lock()
+
+    def _load(self) -> ZoneCacheData:
+        if self._data is None:
+            try:
+                self._data = ZoneCacheData.parse_raw(self._path.read_text())
+            except FileNotFoundError:
+                self._data = ZoneCacheData()
+            except Exception:
+                printer.warning(f"Invalid zone cache, ignoring it: {self._path}")
+                self._data = ZoneCacheData()
+        return self._data
+
+    def _save(self):
+        try:
+            self._path.parent.mkdir(exist_ok=True, parents=True)
+            tmp_path = self._path.with_name(self._path.name + ".tmp")
+            tmp_path.write_text(self._load().json())
+            tmp_path.replace(self._path)
+        except OSError as e:
+            printer.warning(f"Failed to save the zone cache: {e}")
+
+    def get(self, name: str) -> Optional[str]:
+        entry = self._load().zones.get(name)
+        if entry is None or time.time() - entry.fetched > self._ttl:
+            return None
+        return entry.zone_id
+
+    def set(self, name: str, zone_id: str):
+        with self._lock:
+            entry = ZoneEntry(zone_id=zone_id, fetched=time.time())
+            self._load().zones[name] = entry
+            self._save()
+
+    def invalidate(self, name: str):
+        with self._lock:
+            if self._load().zones.pop(name, None) is not None:
+                self._save()
@@ -0,0 +1,81 @@
+"""Updating one designated record first and checking that the new address really
+works, before the rest of the domains follow it. A wrong address, e.g. from a
+misbehaving IP service, then only breaks the canary.
+"""
+import ipaddress
+import socket
+import time
+from typing import Optional
+import attr
+from .dns_lookup import TYPE_A, TYPE_AAAA, DNSLookupError
+from .types import IPAddress
+from . import dns_lookup, printer
+
+
+# a public resolver which gets the changes of Cloudflare zones quickly
+DEFAULT_RESOLVER = "1.1.1.1"
+DEFAULT_TIMEOUT = 120
+RETRY_DELAY = 5
+CONNECT_TIMEOUT = 5
+
+
+class CanaryError(Exception):
+    """The canary record doesn't work with the new address."""
+
+
+def _resolves_to(answers, ip: IPAddress) -> bool:
+    for answer in answers:
+        try:
+            if ipaddress.ip_address(answer) == ip:
+                return True
+        except ValueError:
+            continue
+    return False
+
+
+@attr.s(auto_attribs=True)
+class Canary:
+    domain: str
+    # checked with a TCP connection to the new address, when given
+    port: Optional[int] = None
+    resolver: str = DEFAULT_RESOLVER
+    # how long to wait for the resolver to return the new address
+    timeout: int = DEFAULT_TIMEOUT
+
+    def verify(self, ip: IPAddress, proxied: bool):
+        """Raises CanaryError when the domain doesn't resolve to the new address
+        in time or the port is not reachable on it.
+        """
+        # proxied records resolve to the addresses of Cloudflare instead
+        if not proxied:
+            self.wait_for_resolution(ip)
+        if self.port is not None:
+            self.check_reachable(ip)
+        printer.success(f"Canary {self.domain} works with {ip}.")
+
+    def wait_for_resolution(self, ip: IPAddress):
+        record_type = TYPE_A if ip.version == 4 else TYPE_AAAA
+        deadline = time.monotonic() + self.timeout
+        while True:
+            try:
+                answers = dns_lookup.query(self.domain, record_type, self.resolver)
+            except DNSLookupError as e:
+                printer.info(f"Looking up the canary {self.domain} failed: {e}")
+                answers = []
+            if _resolves_to(answers, ip):
+                return
+            elif time.monotonic() >= deadline:
+                resolved = ", ".join(answers) or "nothing"
+                raise CanaryError(
+                    f"{self.domain} resolves to {resolved} instead of {ip} "
+                    f"after {self.timeout} seconds"
+                )
+            printer.flush()
+            time.sleep(RETRY_DELAY)
+
+    def check_reachable(self, ip: IPAddress):
+        try:
+            with socket.create_connection((str(ip), self.port), CONNECT_TIMEOUT):
+                pass
+        except OSError as e:
+            raise CanaryError(f"port {self.port} is not reachable on {ip}: {e}")
@@ -1,36 +1,389 @@
+import contextlib
+import datetime
+import fnmatch
 import functools
-from typing import Optional
+import ipaddress
+import time
+from typing import List, Optional, Tuple, Union
 import CloudFlare
+from .backup import BackupStore, RecordBackup
+from .cache import ZoneCache, ZoneRecord
+from .providers import (
+    AuthenticationError,
+    CurrentRecord,
+    DNSProvider,
+    DNSProviderError,
+    RateLimitedError,
+)
+from .ratelimit import TokenBucket
 from .types import IPAddress, RecordType, get_record_type
-from . import printer
+from . import printer, stats
 
 
-class CloudFlareError(Exception):
+# the maximum the API allows
+RECORDS_PER_PAGE = 100
+# error code of the API for records which don't exist
+RECORD_NOT_FOUND = 81044
+# error codes of the API for zone IDs which don't exist (anymore)
+ZONE_NOT_FOUND = (1001, 7003)
+# written on every record the tool creates or updates
+MANAGED_COMMENT = "managed by cloudflare-dyndns"
+# error codes of the API for missing, invalid or expired credentials
+AUTH_ERRORS = (6003, 6111, 9103, 9106, 9109, 10000, 10001)
+# error codes of the API for too many requests
+RATE_LIMITED = (971,)
+# error codes of the API for creating a record with the content of an existing one
+RECORD_EXISTS = (81057, 81058)
+# what a rollback puts back from the backup of a record
+RESTORED_FIELDS = ("name", "type", "content", "proxied", "ttl", "comment")
+
+
+class CloudFlareError(DNSProviderError):
     """We can't communicate with CloudFlare API as expected."""
 
 
-class CloudFlareWrapper:
-    def __init__(self, api_token: str):
-        self._cf = CloudFlare.CloudFlare(token=api_token)
+class CloudFlareAuthError(CloudFlareError, AuthenticationError):
+    """The API token is not accepted anymore."""
+
+
+class CloudFlareRateLimited(CloudFlareError, RateLimitedError):
+    """Too many API requests, Cloudflare allows 1200 in 5 minutes."""
+
+
+class CloudFlareRecordNotFound(CloudFlareError):
+    """The record doesn't exist (anymore)."""
+
+
+class CloudFlareZoneNotFound(CloudFlareError):
+    """The zone doesn't exist (anymore)."""
+
+
+class CloudFlareRecordExists(CloudFlareError):
+    """A record with the same content exists already."""
+
+
+def _api_error(e: CloudFlare.exceptions.CloudFlareAPIError) -> CloudFlareError:
+    """The error types by the error codes of the API, so the callers don't have
+    to look at the codes or the messages.
+    """
+    code = int(e)
+    if code in AUTH_ERRORS:
+        return CloudFlareAuthError(f"Invalid API token: {e}")
+    elif code in RATE_LIMITED:
+        return CloudFlareRateLimited(f"Rate limited by Cloudflare: {e}")
+    elif code == RECORD_NOT_FOUND:
+        return CloudFlareRecordNotFound(str(e))
+    elif code in ZONE_NOT_FOUND:
+        return CloudFlareZoneNotFound(str(e))
+    elif code in RECORD_EXISTS:
+        return CloudFlareRecordExists(str(e))
+    return CloudFlareError(str(e))
+
+
+class CloudFlareWrapper(DNSProvider):
+    name = "Cloudflare"
+
+    def __init__(
+        self,
+        api_token: str,
+        base_url: Optional[str] = None,
+        zone_cache: Optional[ZoneCache] = None,
+        strict_ownership: bool = False,
+        takeover: bool = False,
+        rate_limiter: Optional[TokenBucket] = None,
+        backup_store: Optional[BackupStore] = None,
+    ):
+        # a different base_url is only useful for testing against a fake API
+        options = {"base_url": base_url} if base_url else {}
+        self._cf = CloudFlare.CloudFlare(token=api_token, **options)
+        self._zone_cache = zone_cache
+        # records without MANAGED_COMMENT are left alone
+        self._strict_ownership = strict_ownership
+        # records without MANAGED_COMMENT are only overwritten when allowed
+        self._takeover = takeover
+        # shared by the wrappers created for a rotated API token
+        self._rate_limiter = rate_limiter
+        # the records are saved there before they are changed
+        self._backup_store = backup_store
+
+    @contextlib.contextmanager
+    def _request(self, operation: str):
+        if self._rate_limiter is not None:
+            self._rate_limiter.acquire()
+        with stats.timed("cloudflare", operation):
+            yield
+
+    def _backup(
+        self,
+        domain: str,
+        record_type: RecordType,
+        zone_id: str,
+        record_id: str,
+        record: Optional[dict] = None,
+    ):
+        """The record before it's changed, None for the ones just created."""
+        if self._backup_store is not None:
+            self._backup_store.save(domain, record_type, zone_id, record_id, record)
+
+    def _verify_token(self) -> dict:
+        try:
+            with self._request("GET user/tokens/verify"):
+                token = self._cf.user.tokens.verify.get()
+        except CloudFlare.exceptions.CloudFlareAPIError as e:
+            error = _api_error(e)
+            if isinstance(error, (CloudFlareAuthError, CloudFlareRateLimited)):
+                raise error from e
+            raise CloudFlareError(f"Failed to verify the API token: {e}") from e
+        if token.get("status") != "active":
+            raise CloudFlareAuthError(f"The API token is {token.get('status')}.")
+        return token
+
+    def verify_credentials(self):
+        self._verify_token()
+
+    def credentials_expire_on(self) -> Optional[datetime.datetime]:
+        expires_on = self._verify_token().get("expires_on")
+        if not expires_on:
+            return None
+        try:
+            # like 2024-01-01T00:00:00Z, before Python 3.11 fromisoformat can't parse Z
+            return datetime.datetime.fromisoformat(expires_on.replace("Z", "+00:00"))
+        except ValueError as e:
+            raise CloudFlareError(f"Invalid expiry date of the API token: {e}")
+
+    def supports_ttl(self, domain: str) -> bool:
+        return True
+
+    def check_zone(self, domain: str):
+        try:
+            self.get_zone_id(domain)
+        except CloudFlare.exceptions.CloudFlareAPIError as e:
+            raise _api_error(e) from e
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        # the owner of a cached record might have changed since, it has to be checked
+        if cached is not None and not self._strict_ownership:
+            try:
+                self.update_record(
+                    domain, ip, cached.zone_id, cached.record_id, proxied, ttl
+                )
+            except CloudFlare.exceptions.CloudFlareAPIError as e:
+                error = _api_error(e)
+                # looking it up again wouldn't work either
+                if isinstance(error, (CloudFlareAuthError, CloudFlareRateLimited)):
+                    raise error from e
+                printer.error("Invalid cache, looking up the record again.")
+            else:
+                return cached.copy(update={"proxied": proxied, "ttl": ttl})
+
+        taken_over = None
+        try:
+            zone_id = self.get_zone_id(domain)
+            try:
+                record = self._find_record(zone_id, domain, get_record_type(ip))
+            except CloudFlareRecordNotFound:
+                record_id = self.create_record(domain, ip, proxied, ttl)
+            else:
+                self._check_ownership(domain, [record])
+                record_id = record["id"]
+                # e.g. after losing the cache or with --force, nothing to write
+                if _has_content(record, ip, proxied, ttl):
+                    printer.info(f'"{domain}" already points to {ip}.', domain=domain)
+                else:
+                    taken_over = self._take_over(domain, [record], cached)
+                    self.update_record(
+                        domain, ip, zone_id, record_id, proxied, ttl, previous=record
+                    )
+        except CloudFlare.exceptions.CloudFlareAPIError as e:
+            error = _api_error(e)
+            if isinstance(error, CloudFlareZoneNotFound):
+                self.forget_zone(domain)
+            raise error from e
+
+        return ZoneRecord(
+            zone_id=zone_id,
+            record_id=record_id,
+            proxied=proxied,
+            taken_over=taken_over,
+            ttl=ttl,
+        )
 
     @functools.lru_cache
     def get_zone_id(self, domain: str) -> str:
         without_subdomains = ".".join(domain.rsplit(".")[-2:])
-        zone_list = self._cf.zones.get(params={"name": without_subdomains})
+        if self._zone_cache is not None:
+            zone_id = self._zone_cache.get(without_subdomains)
+            if zone_id is not None:
+                return zone_id
+        with self._request("GET zones"):
+            zone_list = self._cf.zones.get(params={"name": without_subdomains})
 
         # not sure if multiple zones can exist for the same domain
         try:
             zone = zone_list[0]
         except IndexError:
             printer.error(f'Cannot find domain "{domain}" at CloudFlare')
-            raise CloudFlareError
+            raise CloudFlareError(f"No zone for {domain}")
 
+        if self._zone_cache is not None:
+            self._zone_cache.set(without_subdomains, zone["id"])
         return zone["id"]
 
+    def forget_zone(self, domain: str):
+        """The zone ID turned out to be wrong, it's looked up again next time."""
+        without_subdomains = ".".join(domain.rsplit(".")[-2:])
+        if self._zone_cache is not None:
+            self._zone_cache.invalidate(without_subdomains)
+        self.get_zone_id.cache_clear()
+
+    def _list_records(self, zone_id: str, **filters) -> list:
+        records, page = [], 1
+        while True:
+            params = {**filters, "page": page, "per_page": RECORDS_PER_PAGE}
+            with self._request("GET dns_records"):
+                result = self._cf.zones.dns_records.get(zone_id, params=params)
+            records.extend(result)
+            if len(result) < RECORDS_PER_PAGE:
+                return records
+            page += 1
+
     @functools.lru_cache
-    def _get_records(self, domain: str) -> dict:
-        zone_id = self.get_zone_id(domain)
-        return self._cf.zones.dns_records.get(zone_id, params={"name": domain})
+    def _get_records(self, domain: str) -> list:
+        return self._list_records(self.get_zone_id(domain), name=domain)
+
+    def ensure_record_set(
+        self,
+        domain: str,
+        ips: List[IPAddress],
+        proxied: bool = False,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ips[0])
+        contents = [str(ip) for ip in ips]
+        printer.info(
+            f'Updating "{domain}" {record_type} records to {", ".join(contents)}.',
+            domain=domain,
+            record_type=record_type,
+        )
+        try:
+            zone_id = self.get_zone_id(domain)
+            records = self._list_records(zone_id, name=domain, type=record_type)
+            self._check_ownership(domain, records)
+            kept, unused = {}, []
+            for record in records:
+                if record["content"] in contents and record["content"] not in kept:
+                    kept[record["content"]] = record
+                else:
+                    unused.append(record)
+            rewritten = [
+                record
+                for record in kept.values()
+                if not _has_content(record, record["content"], proxied, ttl)
+            ]
+            taken_over = self._take_over(domain, [*rewritten, *unused])
+
+            for content in contents:
+                payload = {
+                    "name": domain,
+                    "type": record_type,
+                    "content": content,
+                    "proxied": proxied,
+                    "comment": MANAGED_COMMENT,
+                }
+                if ttl:
+                    payload["ttl"] = ttl
+                record = kept.get(content) or (unused.pop(0) if unused else None)
+                if record is None:
+                    try:
+                        with self._request("POST dns_records"):
+                            created = self._cf.zones.dns_records.post(
+                                zone_id, data={"ttl": 1, **payload}
+                            )
+                    except CloudFlare.exceptions.CloudFlareAPIError as e:
+                        if not isinstance(_api_error(e), CloudFlareRecordExists):
+                            raise
+                        # another instance was faster, the record is what we want
+                        printer.info(
+                            f'"{domain}" already has a record for {content}.',
+                            domain=domain,
+                        )
+                        continue
+                    self._backup(domain, record_type, zone_id, created["id"])
+                elif not _has_content(record, content, proxied, ttl):
+                    self._backup(domain, record_type, zone_id, record["id"], record)
+                    with self._request("PUT dns_records"):
+                        self._cf.zones.dns_records.put(
+                            zone_id, record["id"], data=payload
+                        )
+
+            # the links which are gone
+            for record in unused:
+                self._backup(domain, record_type, zone_id, record["id"], record)
+                with self._request("DELETE dns_records"):
+                    self._cf.zones.dns_records.delete(zone_id, record["id"])
+        except CloudFlare.exceptions.CloudFlareAPIError as e:
+            error = _api_error(e)
+            if isinstance(error, CloudFlareZoneNotFound):
+                self.forget_zone(domain)
+            raise error from e
+
+        self._get_records.cache_clear()
+        return ZoneRecord(
+            zone_id=zone_id,
+            record_id="",
+            proxied=proxied,
+            taken_over=taken_over,
+            ttl=ttl,
+        )
+
+    def verify_record(self, domain: str, ip: IPAddress, cached: ZoneRecord) -> bool:
+        try:
+            with self._request("GET dns_records"):
+                record = self._cf.zones.dns_records.get(
+                    cached.zone_id, cached.record_id
+                )
+        except CloudFlare.exceptions.CloudFlareAPIError as e:
+            error = _api_error(e)
+            if isinstance(error, CloudFlareRecordNotFound):
+                return False
+            raise error from e
+        return record["name"] == domain and _has_content(
+            record, ip, cached.proxied, cached.ttl
+        )
+
+    def current_records(
+        self, domain: str, record_type: RecordType
+    ) -> List[CurrentRecord]:
+        try:
+            zone_id = self.get_zone_id(domain)
+            records = self._list_records(zone_id, name=domain, type=record_type)
+        except CloudFlare.exceptions.CloudFlareAPIError as e:
+            raise _api_error(e) from e
+        return [
+            CurrentRecord(record["content"], record.get("proxied", False))
+            for record in records
+        ]
+
+    def find_domains(self, pattern: str) -> List[str]:
+        try:
+            records = self._list_records(self.get_zone_id(pattern))
+        except CloudFlare.exceptions.CloudFlareAPIError as e:
+            raise _api_error(e) from e
+        pattern = pattern.lower()
+        names = {
+            record["name"]
+            for record in records
+            if record["type"] in ("A", "AAAA")
+            and fnmatch.fnmatchcase(record["name"], pattern)
+        }
+        return sorted(names)
 
     @functools.lru_cache
     def get_record_id(self, domain: str, record_type: RecordType) -> str:
@@ -40,26 +393,131 @@ class CloudFlareWrapper:
 
         # This is not a fatal error yet
         printer.info(f'Failed to get domain records for "{domain}"')
-        raise CloudFlareError(f"Cannot find {record_type} record for {domain}")
+        raise CloudFlareRecordNotFound(f"Cannot find {record_type} record for {domain}")
 
-    def create_record(self, domain: str, ip: IPAddress, proxied: bool = False) -> str:
+    def _check_ownership(self, domain: str, records: List[dict]):
+        if not self._strict_ownership:
+            return
+        for record in records:
+            if not _is_managed(record):
+                message = (
+                    f'The {record["type"]} record of "{domain}" has no '
+                    f'"{MANAGED_COMMENT}" comment, not touching it '
+                    "(--strict-ownership)."
+                )
+                printer.error(message, domain=domain)
+                raise CloudFlareError(message)
+
+    def _take_over(
+        self, domain: str, records: List[dict], cached: Optional[ZoneRecord] = None
+    ) -> Optional[float]:
+        """Records about to be overwritten which were not written by the tool,
+        e.g. because of a typo in the domain name, need --takeover. Returns the
+        time of the takeover, None when every record was ours.
+        """
+        foreign = [record for record in records if not _is_managed(record, cached)]
+        if not foreign:
+            return None
+        record = foreign[0]
+        if not self._takeover:
+            message = (
+                f'The {record["type"]} record of "{domain}" points to '
+                f'{record["content"]}, which was not set by cloudflare-dyndns, '
+                "use --takeover to overwrite it."
+            )
+            printer.error(message, domain=domain)
+            raise CloudFlareError(message)
+        printer.warning(
+            f'Taking over the {record["type"]} record of "{domain}" (--takeover).',
+            domain=domain,
+        )
+        return time.time()
+
+    def _find_record(self, zone_id: str, domain: str, record_type: RecordType) -> dict:
+        """Always fresh from the API, so its content can be compared."""
+        records = self._list_records(zone_id, name=domain, type=record_type)
+        for record in records:
+            if record["name"] == domain:
+                return record
+
+        # This is not a fatal error yet
+        printer.info(f'Failed to get domain records for "{domain}"')
+        raise CloudFlareRecordNotFound(f"Cannot find {record_type} record for {domain}")
+
+    def create_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        ttl: Optional[int] = None,
+    ) -> str:
         zone_id = self.get_zone_id(domain)
         record_type = get_record_type(ip)
-        printer.info(f'Creating a new {record_type} record for "{domain}".')
+        printer.info(
+            f'Creating a new {record_type} record for "{domain}".',
+            domain=domain,
+            record_type=record_type,
+        )
         payload = {
             "name": domain,
             "type": record_type,
             "content": str(ip),
-            "ttl": 1,
+            "ttl": ttl or 1,
             "proxied": proxied,
+            "comment": MANAGED_COMMENT,
         }
         try:
-            record = self._cf.zones.dns_records.post(zone_id, data=payload)
+            try:
+                with self._request("POST dns_records"):
+                    record = self._cf.zones.dns_records.post(zone_id, data=payload)
+            except CloudFlare.exceptions.CloudFlareAPIError as e:
+                if not isinstance(_api_error(e), CloudFlareRecordExists):
+                    raise
+                return self._use_existing_record(zone_id, domain, ip, proxied, ttl)
         except Exception as e:
-            printer.error(f'Failed to create new record for "{domain}": {e}')
+            printer.error(
+                f'Failed to create new record for "{domain}": {e}', domain=domain
+            )
             raise
+        self._backup(domain, record_type, zone_id, record["id"])
         return record["id"]
 
+    def _use_existing_record(
+        self,
+        zone_id: str,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool,
+        ttl: Optional[int],
+    ) -> str:
+        """The record was created in the meantime, e.g. by another instance
+        updating the same domains, so it's used instead of failing.
+        """
+        record_type = get_record_type(ip)
+        printer.info(
+            f'"{domain}" already has a {record_type} record for {ip}, using it.',
+            domain=domain,
+            record_type=record_type,
+        )
+        records = self._list_records(zone_id, name=domain, type=record_type)
+        for record in records:
+            if record["name"] == domain and _same_address(record, ip):
+                self._check_ownership(domain, [record])
+                if not _has_content(record, ip, proxied, ttl):
+                    self.update_record(
+                        domain,
+                        ip,
+                        zone_id,
+                        record["id"],
+                        proxied,
+                        ttl,
+                        previous=record,
+                    )
+                return record["id"]
+        raise CloudFlareRecordNotFound(
+            f"Cannot find the existing {record_type} record for {domain}"
+        )
+
     def update_record(
         self,
         domain: str,
@@ -67,29 +525,148 @@ class CloudFlareWrapper:
         zone_id: Optional[str] = None,
         record_id: Optional[str] = None,
         proxied: bool = False,
+        ttl: Optional[int] = None,
+        previous: Optional[dict] = None,
     ):
         zone_id = zone_id or self.get_zone_id(domain)
         record_type = get_record_type(ip)
         record_id = record_id or self.get_record_id(domain, record_type)
-        printer.info(f'Updating "{domain}" {record_type} record.')
+        printer.info(
+            f'Updating "{domain}" {record_type} record.',
+            domain=domain,
+            record_type=record_type,
+        )
         payload = {
             "name": domain,
             "type": record_type,
             "content": str(ip),
             "proxied": proxied,
+            "comment": MANAGED_COMMENT,
         }
+        if ttl:
+            payload["ttl"] = ttl
+        if self._backup_store is not None:
+            if previous is None:
+                with self._request("GET dns_records"):
+                    previous = self._cf.zones.dns_records.get(zone_id, record_id)
+            self._backup(domain, record_type, zone_id, record_id, previous)
         try:
-            self._cf.zones.dns_records.put(zone_id, record_id, data=payload)
+            with self._request("PUT dns_records"):
+                self._cf.zones.dns_records.put(zone_id, record_id, data=payload)
         except Exception as e:
-            printer.error(f'Failed to update domain "{domain}": {e}')
+            printer.error(f'Failed to update domain "{domain}": {e}', domain=domain)
             raise
 
     def delete_record(self, domain: str, record_type: RecordType):
-        printer.warning(f'Deleting {record_type} record for "{domain}".')
+        printer.warning(
+            f'Deleting {record_type} record for "{domain}".',
+            domain=domain,
+            record_type=record_type,
+        )
         zone_id = self.get_zone_id(domain)
         try:
             record_id = self.get_record_id(domain, record_type)
-        except CloudFlareError:
+        except CloudFlareRecordNotFound:
             printer.info(f'{record_type} record for "{domain}" doesn\'t exist.')
             return
-        self._cf.zones.dns_records.delete(zone_id, record_id)
+        records = [r for r in self._get_records(domain) if r["id"] == record_id]
+        self._check_ownership(domain, records)
+        self._backup(domain, record_type, zone_id, record_id, records[0])
+        try:
+            with self._request("DELETE dns_records"):
+                self._cf.zones.dns_records.delete(zone_id, record_id)
+        except CloudFlare.exceptions.CloudFlareAPIError as e:
+            raise _api_error(e) from e
+
+    def restore_record(self, backup: RecordBackup):
+        """Puts the record back as it was in the backup, recreating it when it
+        was deleted since, and deleting it when it was created then.
+        """
+        domain, record_type = backup.domain, backup.record_type
+        zone_id, record_id = backup.zone_id, backup.record_id
+        try:
+            try:
+                with self._request("GET dns_records"):
+                    current = self._cf.zones.dns_records.get(zone_id, record_id)
+            except CloudFlare.exceptions.CloudFlareAPIError as e:
+                if not isinstance(_api_error(e), CloudFlareRecordNotFound):
+                    raise
+                current = None
+
+            if backup.record is None:
+                if current is not None:
+                    self._backup(domain, record_type, zone_id, record_id, current)
+                    with self._request("DELETE dns_records"):
+                        self._cf.zones.dns_records.delete(zone_id, record_id)
+                return
+            payload = {
+                field: backup.record[field]
+                for field in RESTORED_FIELDS
+                if field in backup.record
+            }
+            if current is None:
+                with self._request("POST dns_records"):
+                    created = self._cf.zones.dns_records.post(zone_id, data=payload)
+                self._backup(domain, record_type, zone_id, created["id"])
+            else:
+                self._backup(domain, record_type, zone_id, record_id, current)
+                with self._request("PUT dns_records"):
+                    self._cf.zones.dns_records.put(zone_id, record_id, data=payload)
+        except CloudFlare.exceptions.CloudFlareAPIError as e:
+            raise _api_error(e) from e
+
+    def get_txt_record(self, domain: str) -> Optional[Tuple[str, str]]:
+        """Returns the id and content of the TXT record, always fresh from the API."""
+        zone_id = self.get_zone_id(domain)
+        with self._request("GET dns_records"):
+            records = self._cf.zones.dns_records.get(
+                zone_id, params={"name": domain, "type": "TXT"}
+            )
+        for record in records:
+            if record["name"] == domain:
+                return record["id"], record["content"]
+        return None
+
+    def set_txt_record(
+        self, domain: str, content: str, record_id: Optional[str] = None
+    ) -> str:
+        zone_id = self.get_zone_id(domain)
+        payload = {"name": domain, "type": "TXT", "content": content, "ttl": 60}
+        if record_id is None:
+            with self._request("POST dns_records"):
+                record = self._cf.zones.dns_records.post(zone_id, data=payload)
+            return record["id"]
+        with self._request("PUT dns_records"):
+            self._cf.zones.dns_records.put(zone_id, record_id, data=payload)
+        return record_id
+
+
+def _is_managed(record: dict, cached: Optional[ZoneRecord] = None) -> bool:
+    # written before the comment was added, it gets the comment with this write
+    if cached is not None and record["id"] == cached.record_id:
+        return True
+    return MANAGED_COMMENT in (record.get("comment") or "")
+
+
+def _same_address(record: dict, ip: Union[IPAddress, str]) -> bool:
+    # the same IPv6 address can be written in many ways
+    try:
+        return ipaddress.ip_address(record["content"]) == ipaddress.ip_address(ip)
+    except ValueError:
+        return False
+
+
+def _has_content(
+    record: dict,
+    ip: Union[IPAddress, str],
+    proxied: bool,
+    ttl: Optional[int] = None,
+) -> bool:
+    """Whether the record is what would be written, the TTL only matters when
+    it's given.
+    """
+    return (
+        _same_address(record, ip)
+        and record.get("proxied", False) == proxied
+        and (ttl is None or record.get("ttl") == ttl)
+    )
@@ -0,0 +1,206 @@
+"""Local control of the daemon through a unix socket, for scripts on the same
+machine. Only the owner of the daemon process can connect, because the socket
+file is only accessible by them, so no token is needed.
+
+The protocol is one JSON object per line in both directions, e.g.
+{"command": "update", "force": true} and {"ok": true}.
+"""
+import json
+import os
+import socket
+import socketserver
+import stat
+import tempfile
+import threading
+from pathlib import Path
+from typing import Optional, Union
+import click
+from .daemon import Daemon
+from . import printer
+
+
+SOCKET_NAME = "cloudflare-dyndns.sock"
+COMMANDS = ("status", "update", "reload", "pause", "resume")
+# requests are tiny, anything longer is not from the ctl command
+MAX_REQUEST_SIZE = 4096
+
+
+class ControlError(Exception):
+    """The daemon can't be reached or it refused the command."""
+
+
+def default_socket() -> Path:
+    """In the runtime directory of the user, or in a directory of the temp
+    directory which only they can enter, so nobody else can take the name first.
+    """
+    runtime_dir = os.environ.get("XDG_RUNTIME_DIR")
+    if runtime_dir:
+        return Path(runtime_dir) / SOCKET_NAME
+    # there are no uids (and no unix sockets) on Windows
+    uid = os.getuid() if hasattr(os, "getuid") else 0
+    return Path(tempfile.gettempdir()) / f"cloudflare-dyndns-{uid}" / SOCKET_NAME
+
+
+DEFAULT_SOCKET = default_socket()
+
+
+def check_private_dir(path: Path):
+    try:
+        info = path.lstat()
+    except FileNotFoundError:
+        # there is no socket in it to connect to either
+        return
+    if (
+        not stat.S_ISDIR(info.st_mode)
+        or info.st_uid != os.getuid()
+        or info.st_mode & 0o077
+    ):
+        raise ValueError(f"{path} has to be a directory only the user can access")
+
+
+def handle_command(daemon: Daemon, request: dict) -> dict:
+    command = request.get("command")
+    if command == "status":
+        return {"ok": True, "status": daemon.status()}
+    elif command == "update":
+        daemon.trigger_update(force=bool(request.get("force")))
+        return {"ok": True, "message": "Update requested."}
+    elif command == "reload":
+        if not daemon.request_reload():
+            return {"ok": False, "error": "Nothing to reload"}
+        return {"ok": True, "message": "Reload requested."}
+    elif command == "pause":
+        daemon.pause()
+        return {"ok": True, "message": "Updates paused."}
+    elif command == "resume":
+        daemon.resume()
+        return {"ok": True, "message": "Updates resumed."}
+    return {"ok": False, "error": f"Unknown command: {command}"}
+
+
+class _RequestHandler(socketserver.StreamRequestHandler):
+    def handle(self):
+        line = self.rfile.readline(MAX_REQUEST_SIZE)
+        try:
+            request = json.loads(line)
+            if not isinstance(request, dict):
+                raise ValueError("not an object")
+        except ValueError as e:
+            response = {"ok": False, "error": f"Invalid request: {e}"}
+        else:
+            response = handle_command(self.server.daemon, request)
+        self.wfile.write(json.dumps(response).encode() + b"\n")
+
+
+class ControlSocketServer:
+    def __init__(self, path: Union[str, Path], daemon: Daemon):
+        # there are no unix sockets on Windows
+        if not hasattr(socketserver, "UnixStreamServer"):
+            raise ValueError("Unix sockets are not supported on this platform")
+
+        class Server(socketserver.ThreadingMixIn, socketserver.UnixStreamServer):
+            daemon_threads = True
+
+        self.path = Path(path)
+        # the user might be different from the one the default was made for
+        if self.path == default_socket():
+            self.path.parent.mkdir(mode=0o700, exist_ok=True)
+            check_private_dir(self.path.parent)
+        self._remove_stale_socket()
+        self._server = Server(str(self.path), _RequestHandler, bind_and_activate=False)
+        try:
+            self._server.server_bind()
+            # only the owner can connect, it's not listening before this
+            self.path.chmod(0o600)
+            self._server.server_activate()
+        except OSError as e:
+            self._server.server_close()
+            raise ValueError(f"Can't listen on {self.path}: {e}")
+        self._server.daemon = daemon
+        self._thread: Optional[threading.Thread] = None
+
+    def _remove_stale_socket(self):
+        if not self.path.exists():
+            return
+        elif not self.path.is_socket():
+            raise ValueError(f"{self.path} exists and it's not a socket")
+        try:
+            send_command(self.path, "status", timeout=1)
+        except ControlError:
+            # left behind by a daemon which was killed
+            self.path.unlink()
+        else:
+            raise ValueError(f"Another daemon is listening on {self.path}")
+
+    def start(self):
+        printer.info(f"Listening for control commands on {self.path}")
+        self._thread = threading.Thread(target=self._server.serve_forever, daemon=True)
+        self._thread.start()
+
+    def close(self):
+        if self._thread is not None:
+            self._server.shutdown()
+        self._server.server_close()
+        self.path.unlink(missing_ok=True)
+
+
+def send_command(
+    path: Union[str, Path], command: str, timeout: float = 10, **arguments
+) -> dict:
+    if Path(path) == default_socket():
+        # somebody else could answer in place of the daemon
+        try:
+            check_private_dir(Path(path).parent)
+        except ValueError as e:
+            raise ControlError(str(e))
+    request = json.dumps({"command": command, **arguments}).encode() + b"\n"
+    try:
+        with socket.socket(socket.AF_UNIX, socket.SOCK_STREAM) as client:
+            client.settimeout(timeout)
+            client.connect(str(path))
+            client.sendall(request)
+            with client.makefile("rb") as reader:
+                line = reader.readline()
+    except OSError as e:
+        raise ControlError(f"Can't connect to the daemon on {path}: {e}")
+    try:
+        response = json.loads(line)
+    except ValueError:
+        raise ControlError(f"Invalid response from the daemon on {path}")
+    if not response.get("ok"):
+        raise ControlError(response.get("error", "The command failed"))
+    return response
+
+
+@click.command(short_help="Control a running daemon through its --control-socket.")
+@click.argument("command", type=click.Choice(COMMANDS))
+@click.option(
+    "--socket",
+    "socket_path",
+    type=click.Path(dir_okay=False),
+    default=DEFAULT_SOCKET,
+    show_default=True,
+    envvar="CLOUDFLARE_DYNDNS_CONTROL_SOCKET",
+    help="The --control-socket of the daemon.",
+)
+@click.option("--force", is_flag=True, help="With update, ignore the cache.")
+@click.pass_context
+def ctl(ctx: click.Context, command: str, socket_path: str, force: bool):
+    """Sends a command to the daemon running on the same machine: print its
+    status as JSON, update now, reload the configuration, pause or resume the
+    scheduled updates. Exits with 1 when the daemon can't be reached.
+
+    \b
+    Example:
+        cloudflare-dyndns ctl update --force
+    """
+    arguments = {"force": True} if force else {}
+    try:
+        response = send_command(socket_path, command, **arguments)
+    except ControlError as e:
+        click.secho(str(e), fg="red", err=True)
+        ctx.exit(1)
+    if command == "status":
+        click.echo(json.dumps(response["status"], indent=2))
+    else:
+        click.echo(response["message"])
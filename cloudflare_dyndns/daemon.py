@@ -0,0 +1,327 @@
+import collections
+import datetime
+import json
+import queue
+import threading
+import time
+from pathlib import Path
+from typing import Callable, Deque, Dict, List, Optional, Tuple
+from .providers import AuthenticationError
+from .report import Report
+from .types import IPAddress, RecordType
+from . import breaker, printer, sd_notify
+
+
+# runs a slow subscriber can fall behind with, later ones are dropped meanwhile
+SUBSCRIBER_QUEUE_SIZE = 100
+# the longest wait between the checks of rejected credentials, retrying them
+# every interval could trip the abuse protection of the provider
+AUTH_BACKOFF_MAX = 6 * 60 * 60
+# the shortest wait after rate limiting, Cloudflare counts the requests in 5 minutes
+RATE_LIMIT_BACKOFF = 5 * 60
+
+
+def _isoformat(timestamp: Optional[float]) -> Optional[str]:
+    if timestamp is None:
+        return None
+    return datetime.datetime.fromtimestamp(timestamp, datetime.timezone.utc).isoformat()
+
+
+class Daemon:
+    """Runs the update periodically, until the process is stopped."""
+
+    def __init__(
+        self,
+        run: Callable[[bool, Dict[RecordType, IPAddress]], Report],
+        interval: int,
+        force: bool,
+        domains: List[str],
+        reload: Optional[Callable[[], None]] = None,
+        pause_updates: Optional[Callable[[bool], None]] = None,
+        pause_file: Optional[Path] = None,
+        verify_credentials: Optional[Callable[[], None]] = None,
+    ):
+        self._run = run
+        self._interval = interval
+        self._force = force
+        self._reload = reload
+        # tells the updater to only detect the addresses, without it the scheduled
+        # runs are skipped completely while paused
+        self._pause_updates = pause_updates
+        # updates are paused while this file exists
+        self._pause_file = pause_file
+        self._paused = False
+        self._file_paused = False
+        # only checks the credentials after they were rejected, instead of
+        # running every update with them, raises an exception when they still fail
+        self._verify_credentials = verify_credentials
+        self._auth_failures = 0
+        self._watchdog_interval = sd_notify.watchdog_interval()
+        self._wake_up = threading.Event()
+        # requests from other threads are handled in the main loop
+        self._update_requested = False
+        self._reload_requested = False
+        self._addresses: Dict[RecordType, IPAddress] = {}
+        self.domains = domains
+        self.last_report: Optional[Report] = None
+        self.last_run: Optional[float] = None
+        self.last_success: Optional[float] = None
+        # when the next scheduled update is due, None while updating
+        self.next_run: Optional[float] = None
+        # (timestamp, report) of the last runs
+        self.history: Deque[Tuple[float, Report]] = collections.deque(maxlen=20)
+        self._history_lock = threading.Lock()
+        # clients streaming the reports of the runs, e.g. over gRPC
+        self._subscribers: List[queue.Queue] = []
+        self._subscribers_lock = threading.Lock()
+
+    @property
+    def interval(self) -> int:
+        return self._interval
+
+    def trigger_update(
+        self,
+        force: bool = False,
+        addresses: Optional[Dict[RecordType, IPAddress]] = None,
+    ):
+        """Wakes up the main loop to run an update immediately.
+        Given addresses are used instead of detecting the current ones.
+        """
+        printer.info("Update requested.")
+        self._force = self._force or force
+        self._addresses.update(addresses or {})
+        self._update_requested = True
+        self._wake_up.set()
+
+    def request_reload(self) -> bool:
+        """Reloads the configuration before the next update, which runs immediately."""
+        if self._reload is None:
+            return False
+        printer.info("Configuration reload requested.")
+        self._reload_requested = True
+        self._update_requested = True
+        self._wake_up.set()
+        return True
+
+    @property
+    def paused(self) -> bool:
+        return self._paused or self._file_paused
+
+    def pause(self):
+        """Scheduled updates don't touch the records until resumed, explicit
+        requests still run.
+        """
+        printer.warning("Updates paused.")
+        self._paused = True
+        sd_notify.notify("STATUS=Paused")
+
+    def resume(self):
+        printer.info("Updates resumed.")
+        self._paused = False
+        if self._file_paused:
+            printer.warning(f"Updates stay paused while {self._pause_file} exists.")
+        self._update_requested = True
+        self._wake_up.set()
+
+    @property
+    def auth_backoff(self) -> int:
+        """Seconds until the next try after the credentials were rejected."""
+        longest = max(AUTH_BACKOFF_MAX, self._interval)
+        return min(self._interval * 2**self._auth_failures, longest)
+
+    def credentials_valid(self) -> Optional[bool]:
+        """None when they couldn't be checked, e.g. the network is down."""
+        try:
+            self._verify_credentials()
+        except AuthenticationError as e:
+            printer.error(f"The credentials are still rejected: {e}")
+            return False
+        except Exception as e:
+            printer.error(f"Could not check the credentials, retrying later: {e}")
+            return None
+        printer.success("The credentials are valid again, resuming the updates.")
+        return True
+
+    def back_off(self):
+        self._auth_failures += 1
+        delay = self.auth_backoff
+        printer.error(
+            "The credentials were rejected, they are probably invalid or expired! "
+            f"Backing off, trying again in {delay} seconds."
+        )
+        sd_notify.notify(f"STATUS=Credentials rejected, retrying in {delay} seconds")
+        self.next_run = time.time() + delay
+        self.sleep(delay)
+
+    def check_pause_file(self):
+        file_paused = self._pause_file is not None and self._pause_file.exists()
+        if file_paused == self._file_paused:
+            return
+        self._file_paused = file_paused
+        if file_paused:
+            printer.warning(f"Updates paused, {self._pause_file} exists.")
+            sd_notify.notify("STATUS=Paused")
+        else:
+            printer.info(f"Updates resumed, {self._pause_file} was removed.")
+
+    def subscribe(self) -> "queue.Queue[Tuple[float, Report]]":
+        """(timestamp, report) of every run from now on will be put in the queue."""
+        subscriber: queue.Queue = queue.Queue(maxsize=SUBSCRIBER_QUEUE_SIZE)
+        with self._subscribers_lock:
+            self._subscribers.append(subscriber)
+        return subscriber
+
+    def unsubscribe(self, subscriber: queue.Queue):
+        with self._subscribers_lock:
+            if subscriber in self._subscribers:
+                self._subscribers.remove(subscriber)
+
+    def past_runs(self) -> List[Tuple[float, Report]]:
+        """A copy of the history, e.g. for the web dashboard's thread."""
+        with self._history_lock:
+            return list(self.history)
+
+    def _publish(self, timestamp: float, report: Report):
+        with self._subscribers_lock:
+            subscribers = list(self._subscribers)
+        for subscriber in subscribers:
+            try:
+                subscriber.put_nowait((timestamp, report))
+            except queue.Full:
+                # a stuck client must not block the updates
+                pass
+
+    @property
+    def ready(self) -> bool:
+        return self.last_report is not None
+
+    def health(self) -> Tuple[bool, dict]:
+        """Healthy when the last cycle succeeded and it was not too long ago."""
+        now = time.time()
+        # leave time for the update itself to finish
+        max_age = self._interval * 2 + 60
+        seconds_ago = None if self.last_run is None else round(now - self.last_run)
+        healthy = (
+            self.last_report is not None
+            and self.last_report.exit_code == 0
+            # no updates are expected while paused
+            and (self.paused or seconds_ago <= max_age)
+        )
+        body = {
+            "healthy": healthy,
+            "paused": self.paused,
+            "status": self.last_report.status if self.last_report else None,
+            "exit_code": self.last_report.exit_code if self.last_report else None,
+            "last_run": _isoformat(self.last_run),
+            "last_success": _isoformat(self.last_success),
+            "seconds_since_last_run": seconds_ago,
+            "max_age": max_age,
+        }
+        return healthy, body
+
+    def status(self) -> dict:
+        _, body = self.health()
+        body["interval"] = self._interval
+        body["domains"] = self.domains
+        # through JSON, so IP addresses are serialized the same way as in reports
+        report = json.loads(self.last_report.json()) if self.last_report else None
+        body["results"] = report["results"] if report else []
+        body["maintenance"] = report["maintenance"] if report else {}
+        body["ip_sources"] = {
+            name: {
+                "consecutive_failures": state.consecutive_failures,
+                "skipped_until": _isoformat(breaker.open_until(name)),
+            }
+            for name, state in breaker.get().items()
+        }
+        return body
+
+    def status_message(self, report: Report) -> str:
+        now = time.strftime("%H:%M")
+        addresses = ", ".join(
+            f"IP {result.new_ip}" for result in report.results if result.new_ip
+        )
+        message = f"last update {now}, {report.status}"
+        return f"{message}, {addresses}" if addresses else message
+
+    def sleep(self, seconds: float):
+        """Sleeps until the next update is due or requested,
+        but keeps pinging the systemd watchdog meanwhile.
+        """
+        deadline = time.monotonic() + seconds
+        while True:
+            remaining = deadline - time.monotonic()
+            if remaining <= 0:
+                return
+            timeout = min(remaining, self._watchdog_interval or remaining)
+            if self._wake_up.wait(timeout):
+                self._wake_up.clear()
+                return
+            sd_notify.notify("WATCHDOG=1")
+
+    def run(self):
+        printer.info(f"Running as a daemon, checking every {self._interval} seconds.")
+        sd_notify.notify("READY=1", "STATUS=Starting first update")
+        while True:
+            requested, self._update_requested = self._update_requested, False
+            if self._reload_requested:
+                self._reload_requested = False
+                self.reload()
+            self.check_pause_file()
+            # explicit requests try the credentials right away, e.g. after a reload
+            if self._auth_failures and not requested and self._verify_credentials:
+                valid = self.credentials_valid()
+                if valid is None:
+                    # they weren't tried, so the backoff stays the same
+                    self.next_run = time.time() + self._interval
+                    self.sleep(self._interval)
+                    continue
+                if not valid:
+                    self.back_off()
+                    continue
+            paused = self.paused and not requested
+            if paused and self._pause_updates is None:
+                printer.info("Updates are paused, skipping.")
+                self.next_run = time.time() + self._interval
+                self.sleep(self._interval)
+                continue
+            if self._pause_updates is not None:
+                self._pause_updates(paused)
+
+            force, self._force = self._force, False
+            addresses, self._addresses = self._addresses, {}
+            self.next_run = None
+            report = self._run(force, addresses)
+            self.last_report = report
+            self.last_run = time.time()
+            with self._history_lock:
+                self.history.appendleft((self.last_run, report))
+            self._publish(self.last_run, report)
+            if report.exit_code == 0:
+                self.last_success = self.last_run
+            sd_notify.notify(f"STATUS={self.status_message(report)}", "WATCHDOG=1")
+            if report.auth_failed:
+                self.back_off()
+                continue
+            self._auth_failures = 0
+            delay = self._interval
+            if report.postponed_until is not None:
+                # push the latest address as soon as the update is allowed again
+                delay = min(delay, max(round(report.postponed_until - time.time()), 1))
+            if report.proxied_change_at is not None:
+                # the schedule is followed on time, regardless of the interval
+                until_change = round(report.proxied_change_at - time.time())
+                delay = min(delay, max(until_change, 1))
+            if report.rate_limited:
+                delay = max(delay, RATE_LIMIT_BACKOFF)
+            printer.info(f"Next check in {delay} seconds.")
+            self.next_run = time.time() + delay
+            self.sleep(delay)
+
+    def reload(self):
+        try:
+            self._reload()
+        except Exception as e:
+            printer.error(f"Failed to reload configuration, keeping the old one: {e}")
+        else:
+            printer.success("Configuration reloaded.")
@@ -0,0 +1,106 @@
+import datetime
+import html
+from typing import Optional
+from .daemon import Daemon
+from . import printer
+
+
+STYLE = """
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { text-align: left; padding: .3em .6em; border-bottom: 1px solid #ddd; }
+.changed, .success { color: #1a7f37; }
+.failed, .error { color: #cf222e; }
+.warning { color: #9a6700; }
+pre { background: #f6f8fa; padding: 1em; overflow-x: auto; font-size: .85em; }
+"""
+
+
+def _time(timestamp: Optional[float]) -> str:
+    if timestamp is None:
+        return "never"
+    return datetime.datetime.fromtimestamp(timestamp).strftime("%Y-%m-%d %H:%M:%S")
+
+
+def _domain_status(daemon: Daemon, domain: str, record_type: str) -> str:
+    result = daemon.last_report.get_result(record_type)
+    if result is None:
+        return ""
+    elif domain in result.domain_errors:
+        error = html.escape(result.domain_errors[domain].message)
+        return f'<span class="failed">failed: {error}</span>'
+    elif domain in result.failed_domains:
+        return '<span class="failed">failed</span>'
+    elif domain in result.updated_domains:
+        return '<span class="changed">updated</span>'
+    elif result.errors:
+        return '<span class="failed">unknown</span>'
+    return "up-to-date"
+
+
+def render(daemon: Daemon, message: str = "") -> str:
+    esc = html.escape
+    report = daemon.last_report
+    parts = [
+        "<!doctype html><html><head><meta charset='utf-8'>",
+        "<title>CloudFlare DynDNS</title>",
+        f"<meta http-equiv='refresh' content='30'><style>{STYLE}</style>",
+        "</head><body><h1>CloudFlare DynDNS</h1>",
+    ]
+    if message:
+        parts.append(f"<p><strong>{esc(message)}</strong></p>")
+
+    if report is None:
+        parts.append("<p>The first update is still running.</p>")
+    else:
+        parts.append(
+            f"<p>Last update: {_time(daemon.last_run)} "
+            f"(<span class='{report.status}'>{report.status}</span>), "
+            f"last successful update: {_time(daemon.last_success)}, "
+            f"checking every {daemon.interval} seconds.</p>"
+        )
+        parts.append("<h2>Current IP addresses</h2><table>")
+        for result in report.results:
+            address = esc(str(result.new_ip or "unknown"))
+            errors = esc("; ".join(result.errors))
+            parts.append(
+                f"<tr><th>{result.record_type}</th><td>{address}</td>"
+                f"<td class='error'>{errors}</td></tr>"
+            )
+        parts.append("</table>")
+
+        record_types = [result.record_type for result in report.results]
+        parts.append("<h2>Domains</h2><table><tr><th>Domain</th>")
+        parts.extend(f"<th>{record_type}</th>" for record_type in record_types)
+        parts.append("</tr>")
+        for domain in daemon.domains:
+            parts.append(f"<tr><td>{esc(domain)}</td>")
+            parts.extend(
+                f"<td>{_domain_status(daemon, domain, record_type)}</td>"
+                for record_type in record_types
+            )
+            parts.append("</tr>")
+        parts.append("</table>")
+
+    parts.append(
+        "<form method='post' action='/update'>"
+        "<input type='password' name='token' placeholder='Control token'> "
+        "<button type='submit'>Force update now</button></form>"
+    )
+
+    parts.append("<h2>History</h2><table>")
+    for timestamp, past_report in daemon.past_runs():
+        parts.append(
+            f"<tr><td>{_time(timestamp)}</td>"
+            f"<td class='{past_report.status}'>{past_report.status}</td>"
+            f"<td>{esc(past_report.summary())}</td></tr>"
+        )
+    parts.append("</table>")
+
+    parts.append("<h2>Log</h2><pre>")
+    for timestamp, level, line in printer.recent_messages():
+        parts.append(
+            f"<span class='{level}'>{timestamp:%H:%M:%S} {esc(line)}</span>\n"
+        )
+    parts.append("</pre></body></html>")
+    return "".join(parts)
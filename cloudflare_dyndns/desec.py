@@ -0,0 +1,109 @@
+import os
+from typing import Optional
+import requests
+from .cache import ZoneRecord
+from .providers import DNSProvider, DNSProviderError
+from .types import IPAddress, RecordType, get_record_type
+from . import printer, stats
+
+
+API_URL = "https://desec.io/api/v1"
+
+
+class DeSECError(DNSProviderError):
+    """The deSEC API returned an error."""
+
+
+class DeSECProvider(DNSProvider):
+    """Updates RRsets of domains hosted at deSEC.io. The API token is read from
+    the DESEC_TOKEN environment variable if not given.
+    """
+
+    name = "deSEC"
+
+    def __init__(self, api_token: Optional[str] = None):
+        api_token = api_token or os.environ.get("DESEC_TOKEN")
+        if not api_token:
+            raise DeSECError(
+                "deSEC API token is missing, set the DESEC_TOKEN environment variable."
+            )
+        printer.register_secret(api_token)
+        self._session = requests.Session()
+        self._session.headers["Authorization"] = f"Token {api_token}"
+        self._domains = {}
+
+    def _request(self, method: str, path: str, json=None, **kwargs):
+        try:
+            with stats.timed("desec", f"{method} {path.split('?')[0]}"):
+                response = self._session.request(
+                    method, API_URL + path, json=json, timeout=30, **kwargs
+                )
+        except requests.RequestException as e:
+            raise DeSECError(f"deSEC API request failed: {e}")
+        if response.status_code == 429:
+            retry_after = response.headers.get("Retry-After", "a few")
+            raise DeSECError(f"deSEC API rate limit, retry after {retry_after}s")
+        try:
+            data = response.json() if response.content else None
+        except ValueError:
+            raise DeSECError(f"Invalid deSEC API response: {response.status_code}")
+        if not response.ok:
+            message = data.get("detail", response.reason) if data else response.reason
+            raise DeSECError(f"deSEC API error: {message}")
+        return data
+
+    def get_domain(self, domain: str) -> dict:
+        """The deSEC domain which the record belongs to."""
+        if domain in self._domains:
+            return self._domains[domain]
+        owners = self._request("GET", "/domains/", params={"owns_qname": domain})
+        if not owners:
+            printer.error(f'Cannot find domain "{domain}" at deSEC')
+            raise DeSECError(f"No domain for {domain}")
+        self._domains[domain] = owners[0]
+        return owners[0]
+
+    def _patch_rrset(self, domain: str, record_type: RecordType, records: list):
+        owner = self.get_domain(domain)
+        subname = domain[: -len(owner["name"]) - 1] if domain != owner["name"] else ""
+        rrset = {
+            "subname": subname,
+            "type": record_type,
+            # lower TTLs are rejected, dedyn.io domains allow 60 seconds
+            "ttl": owner.get("minimum_ttl", 3600),
+            "records": records,
+        }
+        # a bulk PATCH creates the RRset when needed, empty records delete it
+        self._request("PATCH", f"/domains/{owner['name']}/rrsets/", [rrset])
+        return owner["name"], subname or "@"
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record at deSEC.',
+            domain=domain,
+            record_type=record_type,
+        )
+        zone, subname = self._patch_rrset(domain, record_type, [str(ip)])
+        return ZoneRecord(zone_id=zone, record_id=subname, proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        printer.warning(
+            f'Deleting {record_type} record for "{domain}" at deSEC.',
+            domain=domain,
+            record_type=record_type,
+        )
+        self._patch_rrset(domain, record_type, [])
+
+    def verify_credentials(self):
+        self._request("GET", "/auth/account/")
+
+    def check_zone(self, domain: str):
+        self.get_domain(domain)
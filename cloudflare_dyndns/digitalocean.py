@@ -0,0 +1,169 @@
+import os
+from typing import Optional
+import requests
+from .cache import ZoneRecord
+from .providers import (
+    AuthenticationError,
+    DNSProvider,
+    DNSProviderError,
+    RateLimitedError,
+)
+from .types import IPAddress, RecordType, get_record_type
+from . import printer, stats
+
+
+API_URL = "https://api.digitalocean.com/v2"
+
+
+class DigitalOceanError(DNSProviderError):
+    """The DigitalOcean API returned an error."""
+
+
+class DigitalOceanAuthError(DigitalOceanError, AuthenticationError):
+    """The API token is invalid or it has no access to the domain."""
+
+
+class DigitalOceanRateLimited(DigitalOceanError, RateLimitedError):
+    """Too many API requests, DigitalOcean allows 5000 in an hour."""
+
+
+class DigitalOceanNotFound(DigitalOceanError):
+    """The domain or the record doesn't exist (anymore)."""
+
+
+def _api_error(status_code: int, message: str) -> DigitalOceanError:
+    if status_code in (401, 403):
+        return DigitalOceanAuthError(message)
+    elif status_code == 429:
+        return DigitalOceanRateLimited(message)
+    elif status_code == 404:
+        return DigitalOceanNotFound(message)
+    return DigitalOceanError(message)
+
+
+class DigitalOceanProvider(DNSProvider):
+    """Updates records of domains managed by DigitalOcean. The API token is read
+    from the DIGITALOCEAN_TOKEN environment variable if not given.
+    """
+
+    name = "DigitalOcean"
+
+    def __init__(self, api_token: Optional[str] = None, ttl: int = 60):
+        api_token = api_token or os.environ.get("DIGITALOCEAN_TOKEN")
+        if not api_token:
+            raise DigitalOceanError(
+                "DigitalOcean API token is missing, set the DIGITALOCEAN_TOKEN "
+                "environment variable."
+            )
+        printer.register_secret(api_token)
+        self._session = requests.Session()
+        self._session.headers["Authorization"] = f"Bearer {api_token}"
+        # the minimum TTL DigitalOcean allows is 30 seconds
+        self._ttl = max(ttl, 30)
+        self._zones = {}
+
+    def _request(
+        self, method: str, path: str, json: Optional[dict] = None, **kwargs
+    ) -> dict:
+        try:
+            with stats.timed("digitalocean", f"{method} {path}"):
+                response = self._session.request(
+                    method, API_URL + path, json=json, timeout=30, **kwargs
+                )
+        except requests.RequestException as e:
+            raise DigitalOceanError(f"DigitalOcean API request failed: {e}")
+        if response.status_code == 204:
+            return {}
+        try:
+            data = response.json()
+        except ValueError:
+            raise DigitalOceanError(
+                f"Invalid DigitalOcean API response: {response.status_code}"
+            )
+        if not response.ok:
+            message = data.get("message", response.reason)
+            raise _api_error(response.status_code, f"DigitalOcean API error: {message}")
+        return data
+
+    def get_zone(self, domain: str) -> str:
+        """The longest parent domain managed by DigitalOcean."""
+        if domain in self._zones:
+            return self._zones[domain]
+        labels = domain.rstrip(".").split(".")
+        for index in range(len(labels) - 1):
+            candidate = ".".join(labels[index:])
+            try:
+                self._request("GET", f"/domains/{candidate}")
+            except DigitalOceanNotFound:
+                continue
+            self._zones[domain] = candidate
+            return candidate
+        printer.error(f'Cannot find domain "{domain}" at DigitalOcean')
+        raise DigitalOceanError(f"No domain for {domain}")
+
+    def _get_record_id(
+        self, zone: str, domain: str, record_type: RecordType
+    ) -> Optional[str]:
+        params = {"type": record_type, "name": domain}
+        data = self._request("GET", f"/domains/{zone}/records", params=params)
+        records = data.get("domain_records", [])
+        return str(records[0]["id"]) if records else None
+
+    def _update_record(self, zone: str, record_id: str, ip: IPAddress):
+        body = {"type": get_record_type(ip), "data": str(ip)}
+        self._request("PUT", f"/domains/{zone}/records/{record_id}", body)
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record at DigitalOcean.',
+            domain=domain,
+            record_type=record_type,
+        )
+        if cached is not None:
+            try:
+                self._update_record(cached.zone_id, cached.record_id, ip)
+            except DigitalOceanNotFound:
+                printer.error("Invalid cache, looking up the record again.")
+            else:
+                return cached.copy(update={"proxied": proxied})
+
+        zone = self.get_zone(domain)
+        record_id = self._get_record_id(zone, domain, record_type)
+        if record_id is not None:
+            self._update_record(zone, record_id, ip)
+            return ZoneRecord(zone_id=zone, record_id=record_id, proxied=proxied)
+
+        # record names are relative to the domain, "@" is the domain itself
+        name = domain[: -len(zone) - 1] if domain != zone else "@"
+        body = {"type": record_type, "name": name, "data": str(ip), "ttl": self._ttl}
+        data = self._request("POST", f"/domains/{zone}/records", body)
+        printer.success(f"Created new record: {data['domain_record']}")
+        record_id = str(data["domain_record"]["id"])
+        return ZoneRecord(zone_id=zone, record_id=record_id, proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        printer.warning(
+            f'Deleting {record_type} record for "{domain}" at DigitalOcean.',
+            domain=domain,
+            record_type=record_type,
+        )
+        zone = self.get_zone(domain)
+        record_id = self._get_record_id(zone, domain, record_type)
+        if record_id is None:
+            printer.info(f'{record_type} record for "{domain}" doesn\'t exist.')
+            return
+        self._request("DELETE", f"/domains/{zone}/records/{record_id}")
+
+    def verify_credentials(self):
+        self._request("GET", "/account")
+
+    def check_zone(self, domain: str):
+        self.get_zone(domain)
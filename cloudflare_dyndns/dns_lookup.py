@@ -0,0 +1,109 @@
+import random
+import socket
+import struct
+from typing import List, Tuple
+from . import binding
+
+
+TYPE_A = 1
+TYPE_TXT = 16
+TYPE_AAAA = 28
+CLASS_IN = 1
+
+DNS_PORT = 53
+
+
+class DNSLookupError(Exception):
+    pass
+
+
+def _encode_name(name: str) -> bytes:
+    labels = [label.encode("idna") for label in name.rstrip(".").split(".")]
+    return b"".join(bytes([len(label)]) + label for label in labels) + b"\0"
+
+
+def _skip_name(message: bytes, offset: int) -> int:
+    while True:
+        length = message[offset]
+        if length == 0:
+            return offset + 1
+        # compression pointer, which always ends the name
+        elif length & 0xC0 == 0xC0:
+            return offset + 2
+        offset += length + 1
+
+
+def _parse_rdata(record_type: int, rdata: bytes) -> str:
+    if record_type == TYPE_TXT:
+        strings, offset = [], 0
+        while offset < len(rdata):
+            length = rdata[offset]
+            strings.append(rdata[offset + 1 : offset + 1 + length].decode())
+            offset += length + 1
+        return "".join(strings)
+    family = socket.AF_INET if record_type == TYPE_A else socket.AF_INET6
+    return socket.inet_ntop(family, rdata)
+
+
+def parse_response(message: bytes, query_id: int, record_type: int) -> List[str]:
+    response_id, flags, qdcount, ancount = struct.unpack("!HHHH", message[:8])
+    if response_id != query_id:
+        raise DNSLookupError("Response for a different query")
+    rcode = flags & 0xF
+    if rcode != 0:
+        raise DNSLookupError(f"DNS server returned error code {rcode}")
+
+    offset = 12
+    for _ in range(qdcount):
+        offset = _skip_name(message, offset) + 4
+    answers = []
+    for _ in range(ancount):
+        offset = _skip_name(message, offset)
+        rtype, _, _, rdlength = struct.unpack("!HHIH", message[offset : offset + 10])
+        offset += 10
+        rdata = message[offset : offset + rdlength]
+        offset += rdlength
+        if rtype == record_type:
+            answers.append(_parse_rdata(rtype, rdata))
+    return answers
+
+
+def build_query(name: str, record_type: int) -> Tuple[int, bytes]:
+    """Returns the random query id and the query message with recursion desired."""
+    query_id = random.randrange(0x10000)
+    header = struct.pack("!HHHHHH", query_id, 0x0100, 1, 0, 0, 0)
+    question = _encode_name(name) + struct.pack("!HH", record_type, CLASS_IN)
+    return query_id, header + question
+
+
+def query(
+    name: str,
+    record_type: int,
+    server: str,
+    family: int = socket.AF_INET,
+    timeout: float = 5,
+) -> List[str]:
+    """Sends a single DNS query over UDP to the given server.
+    The address family of the server decides which public IP address
+    the server sees, so it can be used for detecting the IPv4 or IPv6 address.
+    """
+    try:
+        server_address = binding.getaddrinfo(
+            server, DNS_PORT, family, socket.SOCK_DGRAM
+        )[0][4]
+    except socket.gaierror as e:
+        raise DNSLookupError(f"Cannot resolve {server}: {e}")
+
+    query_id, request = build_query(name, record_type)
+    with socket.socket(family, socket.SOCK_DGRAM) as sock:
+        sock.settimeout(timeout)
+        try:
+            binding.bind(sock)
+            sock.sendto(request, server_address)
+            message, _ = sock.recvfrom(4096)
+        except OSError as e:
+            raise DNSLookupError(f"No response from {server}: {e}")
+    try:
+        return parse_response(message, query_id, record_type)
+    except (struct.error, IndexError, UnicodeDecodeError, ValueError) as e:
+        raise DNSLookupError(f"Invalid response from {server}: {e}")
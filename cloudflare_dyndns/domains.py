@@ -0,0 +1,90 @@
+"""Syntax check of the domain names, so typos are caught before any request."""
+import fnmatch
+import re
+import socket
+from typing import Iterable, List, Optional
+
+
+MAX_LENGTH = 253
+LABEL_PATTERN = re.compile(r"^(?!-)[a-z0-9_-]{1,63}(?<!-)$", re.IGNORECASE)
+
+
+def syntax_error(domain: str) -> Optional[str]:
+    """Returns why the domain is not a valid hostname, None when it's valid."""
+    labels = domain.rstrip(".").split(".")
+    if "" in labels:
+        return "empty label"
+    if len(labels) < 2:
+        return "not a fully qualified domain name"
+    try:
+        labels = [label.encode("idna").decode() for label in labels]
+    except UnicodeError:
+        return "invalid internationalized domain name"
+    if len(".".join(labels)) > MAX_LENGTH:
+        return f"longer than {MAX_LENGTH} characters"
+    for index, label in enumerate(labels):
+        # wildcard records are fine too
+        if index == 0 and label == "*":
+            continue
+        if not LABEL_PATTERN.match(label):
+            return f'invalid label "{label}"'
+    return None
+
+
+def expand_subdomains(zone: str, subdomains: List[str]) -> List[str]:
+    """Fully qualified names of the subdomains in the zone. Every value can hold
+    more names separated by commas, "@" means the zone itself.
+    """
+    zone = zone.strip().rstrip(".")
+    domains = []
+    for value in subdomains:
+        for name in value.split(","):
+            name = name.strip()
+            if not name:
+                continue
+            domain = zone if name == "@" else f"{name}.{zone}"
+            if domain not in domains:
+                domains.append(domain)
+    return domains
+
+
+def expand_placeholders(domain: str, hostname: Optional[str] = None) -> str:
+    """Replaces {hostname} with the hostname of the machine and {hostname_short}
+    with its first label, so the same configuration works on every machine.
+    """
+    hostname = (hostname or socket.gethostname()).lower().rstrip(".")
+    domain = domain.replace("{hostname_short}", hostname.split(".")[0])
+    return domain.replace("{hostname}", hostname)
+
+
+def hostname_domain(zone: str, hostname: Optional[str] = None) -> str:
+    """The short hostname of the machine as a subdomain of the zone."""
+    zone = zone.strip().rstrip(".")
+    domain = expand_placeholders("{hostname_short}." + zone, hostname)
+    short_hostname = domain.split(".")[0]
+    if short_hostname in ("", "localhost"):
+        raise ValueError(f'The hostname "{short_hostname}" can\'t be a subdomain.')
+    return domain
+
+
+def www_domain(domain: str) -> Optional[str]:
+    """The www pair of the domain, None for www domains themselves."""
+    if domain.startswith("www."):
+        return None
+    return f"www.{domain}"
+
+
+def with_www(domains: List[str]) -> List[str]:
+    """Every domain followed by its www subdomain."""
+    result = []
+    for domain in domains:
+        for name in (domain, www_domain(domain)):
+            if name is not None and name not in result:
+                result.append(name)
+    return result
+
+
+def is_excluded(domain: str, patterns: Iterable[str]) -> bool:
+    """Whether the domain matches one of the shell-style patterns."""
+    domain = domain.lower().rstrip(".")
+    return any(fnmatch.fnmatchcase(domain, pattern.lower()) for pattern in patterns)
@@ -0,0 +1,89 @@
+import os
+from typing import Optional
+import requests
+from .cache import ZoneRecord
+from .providers import DNSProvider, DNSProviderError
+from .types import IPAddress, RecordType, get_record_type
+from . import printer, stats
+
+
+UPDATE_URL = "https://www.duckdns.org/update"
+DUCKDNS_DOMAIN = "duckdns.org"
+
+
+class DuckDNSError(DNSProviderError):
+    """DuckDNS refused the update."""
+
+
+class DuckDNSProvider(DNSProvider):
+    """Updates "<name>.duckdns.org" subdomains. The account token is read from
+    the DUCKDNS_TOKEN environment variable if not given.
+    """
+
+    name = "DuckDNS"
+
+    def __init__(self, token: Optional[str] = None):
+        token = token or os.environ.get("DUCKDNS_TOKEN")
+        if not token:
+            raise DuckDNSError(
+                "DuckDNS token is missing, set the DUCKDNS_TOKEN environment variable."
+            )
+        printer.register_secret(token)
+        self._token = token
+        self._session = requests.Session()
+
+    def _update(self, domain: str, **params):
+        subdomain = _subdomain(domain)
+        params = {"domains": subdomain, "token": self._token, **params}
+        try:
+            with stats.timed("duckdns", "GET update"):
+                response = self._session.get(UPDATE_URL, params=params, timeout=30)
+        except requests.RequestException as e:
+            raise DuckDNSError(f"DuckDNS request failed: {e}")
+        # the answer is "KO" for an invalid token or subdomain, without details
+        if response.text.strip() != "OK":
+            raise DuckDNSError(f'DuckDNS refused to update "{domain}"')
+        return subdomain
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record at DuckDNS.',
+            domain=domain,
+            record_type=record_type,
+        )
+        param = "ip" if ip.version == 4 else "ipv6"
+        subdomain = self._update(domain, **{param: str(ip)})
+        return ZoneRecord(zone_id=DUCKDNS_DOMAIN, record_id=subdomain, proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        # clearing would remove the address of the other record type too
+        printer.warning(
+            f'DuckDNS can only clear both addresses at once, keeping "{domain}".',
+            domain=domain,
+            record_type=record_type,
+        )
+
+    def verify_credentials(self):
+        # there is no endpoint for checking the token without changing a record
+        pass
+
+    def check_zone(self, domain: str):
+        _subdomain(domain)
+
+
+def _subdomain(domain: str) -> str:
+    """The registered name, e.g. "home" for "home.duckdns.org" and for
+    "www.home.duckdns.org" too, as DuckDNS resolves those to the same address.
+    """
+    suffix = "." + DUCKDNS_DOMAIN
+    if not domain.endswith(suffix):
+        raise DuckDNSError(f'"{domain}" is not a {DUCKDNS_DOMAIN} subdomain')
+    return domain[: -len(suffix)].rsplit(".", 1)[-1]
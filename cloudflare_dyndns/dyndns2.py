@@ -0,0 +1,98 @@
+import os
+from typing import Optional
+import requests
+from .cache import ZoneRecord
+from .providers import DNSProvider, DNSProviderError
+from .types import IPAddress, RecordType, get_record_type
+from .user_agent import default_user_agent
+from . import printer, stats
+
+
+# the meaning of the error codes from the DynDNS2 protocol
+RETURN_CODES = {
+    "badauth": "invalid username or password",
+    "notfqdn": "the hostname is not a fully qualified domain name",
+    "nohost": "the hostname doesn't exist in this account",
+    "numhost": "too many hosts in one request",
+    "abuse": "the hostname is blocked for update abuse",
+    "badagent": "the user agent was rejected",
+    "dnserr": "DNS error on the server side",
+    "911": "problem or maintenance on the server side",
+}
+
+
+class DynDNS2Error(DNSProviderError):
+    """The DynDNS2 service refused the update."""
+
+
+class DynDNS2Provider(DNSProvider):
+    """Updates hostnames with any service speaking the DynDNS2 protocol, like
+    ddclient does. The update URL and the credentials are read from the
+    DYNDNS2_URL, DYNDNS2_USERNAME and DYNDNS2_PASSWORD environment variables
+    if not given.
+    """
+
+    name = "DynDNS2"
+
+    def __init__(
+        self,
+        url: Optional[str] = None,
+        username: Optional[str] = None,
+        password: Optional[str] = None,
+    ):
+        self._url = url or os.environ.get("DYNDNS2_URL")
+        username = username or os.environ.get("DYNDNS2_USERNAME")
+        password = password or os.environ.get("DYNDNS2_PASSWORD")
+        if not self._url or not username or not password:
+            raise DynDNS2Error(
+                "DynDNS2 service is not configured, set the DYNDNS2_URL, "
+                "DYNDNS2_USERNAME and DYNDNS2_PASSWORD environment variables."
+            )
+        printer.register_secret(password)
+        self._session = requests.Session()
+        self._session.auth = (username, password)
+        # the protocol requires a user agent identifying the client
+        self._session.headers["User-Agent"] = default_user_agent()
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record with DynDNS2.',
+            domain=domain,
+            record_type=record_type,
+        )
+        params = {"hostname": domain, "myip": str(ip)}
+        try:
+            with stats.timed("dyndns2", "GET update"):
+                response = self._session.get(self._url, params=params, timeout=30)
+        except requests.RequestException as e:
+            raise DynDNS2Error(f"DynDNS2 request failed: {e}")
+        check_response(response.text)
+        return ZoneRecord(zone_id=self._url, record_id=domain, proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        printer.warning(
+            f'The DynDNS2 protocol can\'t delete records, keeping "{domain}".',
+            domain=domain,
+            record_type=record_type,
+        )
+
+    def verify_credentials(self):
+        # the credentials can only be checked by updating a record
+        pass
+
+
+def check_response(text: str):
+    """Raises DynDNS2Error unless the answer is "good" or "nochg"."""
+    return_code = text.strip().split(" ", 1)[0]
+    if return_code in ("good", "nochg"):
+        return
+    reason = RETURN_CODES.get(return_code, text.strip() or "empty response")
+    raise DynDNS2Error(f"DynDNS2 update failed: {reason}")
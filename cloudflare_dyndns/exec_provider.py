@@ -0,0 +1,100 @@
+import json
+import os
+import shlex
+import subprocess
+from typing import Optional
+from .cache import ZoneRecord
+from .providers import DNSProvider, DNSProviderError
+from .types import IPAddress, RecordType, get_record_type
+from . import printer, stats
+
+
+class ExecProviderError(DNSProviderError):
+    """The provider command failed."""
+
+
+class ExecProvider(DNSProvider):
+    """Runs an external command for every operation, so providers can be
+    written in any language. The command gets a JSON request on its standard
+    input, like {"action": "ensure_record", "domain": "example.com", "ip":
+    "127.0.0.1", "record_type": "A", "proxied": false, "cached": null}, and has
+    to print a JSON response, {"zone_id": "...", "record_id": "..."} for
+    ensure_record and {} for the delete_record and verify_credentials actions.
+    A non-zero exit code means failure, with the error message on stderr.
+    The command is read from the DYNDNS_PROVIDER_COMMAND environment variable
+    if not given.
+    """
+
+    name = "exec"
+
+    def __init__(self, command: Optional[str] = None):
+        command = command or os.environ.get("DYNDNS_PROVIDER_COMMAND")
+        if not command:
+            raise ExecProviderError(
+                "Provider command is missing, set the DYNDNS_PROVIDER_COMMAND "
+                "environment variable."
+            )
+        self.command = command
+
+    def _call(self, action: str, **params) -> dict:
+        request = json.dumps({"action": action, **params})
+        try:
+            with stats.timed("exec", action):
+                completed = subprocess.run(
+                    shlex.split(self.command),
+                    input=request,
+                    capture_output=True,
+                    text=True,
+                    timeout=60,
+                )
+        except (OSError, subprocess.TimeoutExpired) as e:
+            raise ExecProviderError(f"Provider command failed: {e}")
+        if completed.returncode != 0:
+            message = completed.stderr.strip() or f"exit code {completed.returncode}"
+            raise ExecProviderError(f"Provider command failed: {message}")
+        try:
+            response = json.loads(completed.stdout or "{}")
+        except ValueError:
+            raise ExecProviderError("Provider command printed invalid JSON")
+        if not isinstance(response, dict):
+            raise ExecProviderError("Provider command has to print a JSON object")
+        return response
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record with {self.command}.',
+            domain=domain,
+            record_type=record_type,
+        )
+        response = self._call(
+            "ensure_record",
+            domain=domain,
+            ip=str(ip),
+            record_type=record_type,
+            proxied=proxied,
+            cached=cached.dict() if cached is not None else None,
+        )
+        return ZoneRecord(
+            zone_id=str(response.get("zone_id", "")),
+            record_id=str(response.get("record_id", domain)),
+            proxied=proxied,
+        )
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        printer.warning(
+            f'Deleting {record_type} record for "{domain}" with {self.command}.',
+            domain=domain,
+            record_type=record_type,
+        )
+        self._call("delete_record", domain=domain, record_type=record_type)
+
+    def verify_credentials(self):
+        self._call("verify_credentials")
@@ -0,0 +1,75 @@
+"""The effective configuration and the state of the managed records, for backups,
+reviewing the setup or feeding it into other tools.
+"""
+import json
+from pathlib import Path
+from typing import Any, Dict, List, Union
+import click
+from .cache import Cache
+from . import printer
+
+
+FORMATS = ["json", "yaml"]
+SECRET_PARAMS = (
+    "api_token",
+    "digitalocean_token",
+    "control_token",
+    "inbound_webhook_secret",
+    "webhook_secret",
+    "matrix_access_token",
+)
+
+
+def _redact(value: Any) -> Any:
+    if isinstance(value, str):
+        return printer.redact(value)
+    elif isinstance(value, (list, tuple)):
+        return [_redact(item) for item in value]
+    return value
+
+
+def redact_params(params: Dict[str, Any]) -> Dict[str, Any]:
+    """Secrets are replaced, even when they appear in other values, like the
+    passwords in URLs.
+    """
+    for name in SECRET_PARAMS:
+        printer.register_secret(params.get(name))
+    config = {}
+    for name, value in params.items():
+        if name in SECRET_PARAMS and value:
+            value = printer.REDACTED
+        config[name] = _redact(value)
+    return config
+
+
+def read_state(cache_file: Union[str, Path]) -> Dict[str, Any]:
+    """The cache without any messages, so they don't end up in the output."""
+    try:
+        cache = Cache.parse_raw(Path(cache_file).expanduser().read_text())
+    except FileNotFoundError:
+        cache = Cache()
+    except Exception as e:
+        raise click.ClickException(f"Invalid cache file {cache_file}: {e}")
+    return json.loads(cache.json())
+
+
+def export_data(params: Dict[str, Any], domains: List[str]) -> Dict[str, Any]:
+    data = {
+        "configuration": redact_params(params),
+        "domains": domains,
+        "state": read_state(params["cache_file"]),
+    }
+    # e.g. paths and IP addresses as plain strings
+    return json.loads(json.dumps(data, default=str))
+
+
+def dump(data: Dict[str, Any], output_format: str) -> str:
+    if output_format == "json":
+        return json.dumps(data, indent=2)
+    try:
+        import yaml
+    except ImportError:
+        raise click.ClickException(
+            "YAML output needs PyYAML, install cloudflare-dyndns[yaml]"
+        )
+    return yaml.safe_dump(data, sort_keys=False)
@@ -0,0 +1,116 @@
+import os
+from typing import Optional
+import requests
+from .cache import ZoneRecord
+from .providers import DNSProvider, DNSProviderError
+from .types import IPAddress, RecordType, get_record_type
+from . import printer, stats
+
+
+API_URL = "https://api.gandi.net/v5/livedns"
+# the minimum TTL allowed by LiveDNS
+MIN_TTL = 300
+
+
+class GandiError(DNSProviderError):
+    """The Gandi LiveDNS API returned an error."""
+
+
+class GandiProvider(DNSProvider):
+    """Updates records of domains using Gandi LiveDNS. The Personal Access Token
+    is read from the GANDI_PAT environment variable if not given.
+    """
+
+    name = "Gandi"
+
+    def __init__(self, access_token: Optional[str] = None, ttl: int = MIN_TTL):
+        access_token = access_token or os.environ.get("GANDI_PAT")
+        if not access_token:
+            raise GandiError(
+                "Gandi Personal Access Token is missing, set the GANDI_PAT "
+                "environment variable."
+            )
+        printer.register_secret(access_token)
+        self._session = requests.Session()
+        self._session.headers["Authorization"] = f"Bearer {access_token}"
+        self._ttl = max(ttl, MIN_TTL)
+        self._zones = {}
+
+    def _request(
+        self, method: str, path: str, json: Optional[dict] = None
+    ) -> requests.Response:
+        try:
+            with stats.timed("gandi", f"{method} {path}"):
+                response = self._session.request(
+                    method, API_URL + path, json=json, timeout=30
+                )
+        except requests.RequestException as e:
+            raise GandiError(f"Gandi LiveDNS API request failed: {e}")
+        if not response.ok and response.status_code != 404:
+            try:
+                message = response.json().get("message", response.reason)
+            except ValueError:
+                message = response.reason
+            raise GandiError(f"Gandi LiveDNS API error: {message}")
+        return response
+
+    def get_zone(self, domain: str) -> str:
+        """The longest parent domain managed by LiveDNS."""
+        if domain in self._zones:
+            return self._zones[domain]
+        labels = domain.rstrip(".").split(".")
+        for index in range(len(labels) - 1):
+            candidate = ".".join(labels[index:])
+            if self._request("GET", f"/domains/{candidate}").ok:
+                self._zones[domain] = candidate
+                return candidate
+        printer.error(f'Cannot find domain "{domain}" at Gandi LiveDNS')
+        raise GandiError(f"No domain for {domain}")
+
+    def _rrset_path(self, zone: str, domain: str, record_type: RecordType) -> str:
+        # record names are relative to the domain, "@" is the domain itself
+        name = domain[: -len(zone) - 1] if domain != zone else "@"
+        return f"/domains/{zone}/records/{name}/{record_type}"
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record at Gandi.',
+            domain=domain,
+            record_type=record_type,
+        )
+        zone = cached.zone_id if cached is not None else self.get_zone(domain)
+        # PUT creates the record set or replaces all of its values
+        body = {"rrset_values": [str(ip)], "rrset_ttl": self._ttl}
+        path = self._rrset_path(zone, domain, record_type)
+        response = self._request("PUT", path, body)
+        if response.status_code == 404:
+            if cached is None:
+                raise GandiError(f"Domain {zone} not found")
+            printer.error("Invalid cache, looking up the domain again.")
+            return self.ensure_record(domain, ip, proxied)
+        return ZoneRecord(zone_id=zone, record_id=path, proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        printer.warning(
+            f'Deleting {record_type} record for "{domain}" at Gandi.',
+            domain=domain,
+            record_type=record_type,
+        )
+        zone = self.get_zone(domain)
+        response = self._request("DELETE", self._rrset_path(zone, domain, record_type))
+        if response.status_code == 404:
+            printer.info(f'{record_type} record for "{domain}" doesn\'t exist.')
+
+    def verify_credentials(self):
+        self._request("GET", "/domains?per_page=1")
+
+    def check_zone(self, domain: str):
+        self.get_zone(domain)
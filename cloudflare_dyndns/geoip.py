@@ -0,0 +1,124 @@
+"""Where a new IP address is, by country and network (ASN), so notifications
+make it obvious when a record suddenly points to another country or provider.
+The data comes from local MaxMind DB files (e.g. GeoLite2-Country and
+GeoLite2-ASN) or from ipinfo.io.
+"""
+import re
+from typing import List, Optional
+import requests
+from pydantic import BaseModel
+from .types import IPAddress
+from . import printer
+
+
+IPINFO_URL = "https://ipinfo.io/{ip}/json"
+# ipinfo.io puts the ASN in front of the name of the organization
+ORG_PATTERN = re.compile(r"^AS(\d+)\s+(.*)$")
+
+
+class GeoInfo(BaseModel):
+    country_code: Optional[str] = None
+    country: Optional[str] = None
+    asn: Optional[int] = None
+    organization: Optional[str] = None
+
+    def __str__(self):
+        parts = []
+        if self.country or self.country_code:
+            parts.append(self.country or self.country_code)
+        if self.asn is not None and self.organization:
+            parts.append(f"AS{self.asn} {self.organization}")
+        elif self.asn is not None:
+            parts.append(f"AS{self.asn}")
+        return ", ".join(parts)
+
+
+class GeoIPError(Exception):
+    """The location of the address could not be looked up."""
+
+
+class GeoIPLookup:
+    name = "GeoIP"
+
+    def lookup(self, ip: IPAddress) -> GeoInfo:
+        raise NotImplementedError
+
+
+def from_mmdb_record(record: dict) -> GeoInfo:
+    """Country (or City) and ASN database records have different fields,
+    the ones which are there are used.
+    """
+    country = record.get("country") or record.get("registered_country") or {}
+    return GeoInfo(
+        country_code=country.get("iso_code"),
+        country=country.get("names", {}).get("en"),
+        asn=record.get("autonomous_system_number"),
+        organization=record.get("autonomous_system_organization"),
+    )
+
+
+class MMDBLookup(GeoIPLookup):
+    name = "MaxMind DB"
+
+    def __init__(self, paths: List[str]):
+        try:
+            import maxminddb
+        except ImportError:
+            raise ValueError(
+                "GeoIP databases need maxminddb, install cloudflare-dyndns[geoip]"
+            )
+        self._readers = []
+        for path in paths:
+            try:
+                self._readers.append(maxminddb.open_database(path))
+            except (OSError, ValueError) as e:
+                raise ValueError(f"Can't open GeoIP database {path}: {e}")
+
+    def lookup(self, ip: IPAddress) -> GeoInfo:
+        info = GeoInfo()
+        for reader in self._readers:
+            try:
+                record = reader.get(str(ip)) or {}
+            except ValueError as e:
+                raise GeoIPError(str(e))
+            found = from_mmdb_record(record)
+            # e.g. the country from one database, the ASN from the other
+            info = info.copy(update=found.dict(exclude_none=True))
+        return info
+
+
+def from_ipinfo(data: dict) -> GeoInfo:
+    info = GeoInfo(country_code=data.get("country"))
+    match = ORG_PATTERN.match(data.get("org") or "")
+    if match:
+        info.asn, info.organization = int(match.group(1)), match.group(2)
+    return info
+
+
+class IPInfoLookup(GeoIPLookup):
+    name = "ipinfo.io"
+
+    def __init__(self, url: str = IPINFO_URL):
+        self._url = url
+
+    def lookup(self, ip: IPAddress) -> GeoInfo:
+        try:
+            res = requests.get(self._url.format(ip=ip), timeout=10)
+            res.raise_for_status()
+            return from_ipinfo(res.json())
+        except (requests.RequestException, ValueError) as e:
+            raise GeoIPError(str(e))
+
+
+def annotate(geoip: GeoIPLookup, ip: IPAddress) -> Optional[GeoInfo]:
+    """The location of the address, None when it can't be looked up, because
+    it's only extra information, the update went through anyway.
+    """
+    try:
+        info = geoip.lookup(ip)
+    except GeoIPError as e:
+        printer.warning(f"{geoip.name} lookup of {ip} failed: {e}")
+        return None
+    if str(info):
+        printer.info(f"{ip} is in {info}", ip=ip)
+    return info
@@ -0,0 +1,260 @@
+"""gRPC control API of the daemon for services which want a typed client,
+generated from proto/dyndns.proto: triggering updates, the status and a stream
+of the results of every run, e.g. to react to address changes.
+
+The server builds the message classes from MESSAGES at runtime, so no generated
+code is needed here, keep it in sync with the .proto file.
+"""
+import hmac
+import ipaddress
+import json
+import queue
+from concurrent import futures
+from pathlib import Path
+from typing import Callable, Dict, Iterable, Iterator, Tuple
+from .daemon import Daemon, _isoformat
+from .report import Report
+from . import printer
+
+
+PROTO_FILE = Path(__file__).parent / "proto" / "dyndns.proto"
+PACKAGE = "cloudflare_dyndns.v1"
+SERVICE_NAME = f"{PACKAGE}.Control"
+# every stream occupies a worker while it's open
+MAX_WORKERS = 10
+# how often a stream checks whether the client is still there
+STREAM_POLL_INTERVAL = 1.0
+
+# the fields of the messages in the order of their numbers, as in the .proto file
+MESSAGES = {
+    "TriggerUpdateRequest": [("force", "bool"), ("ipv4", "string"), ("ipv6", "string")],
+    "TriggerUpdateResponse": [("accepted", "bool")],
+    "GetStatusRequest": [],
+    "Result": [
+        ("record_type", "string"),
+        ("old_ip", "string"),
+        ("new_ip", "string"),
+        ("updated_domains", "repeated string"),
+        ("failed_domains", "repeated string"),
+        ("errors", "repeated string"),
+        ("link", "string"),
+        ("skipped", "bool"),
+    ],
+    "Status": [
+        ("healthy", "bool"),
+        ("paused", "bool"),
+        ("status", "string"),
+        ("exit_code", "int32"),
+        ("last_run", "string"),
+        ("last_success", "string"),
+        ("interval", "int32"),
+        ("domains", "repeated string"),
+        ("results", "repeated Result"),
+    ],
+    "StreamEventsRequest": [("only_changes", "bool")],
+    "Event": [
+        ("time", "string"),
+        ("status", "string"),
+        ("exit_code", "int32"),
+        ("results", "repeated Result"),
+    ],
+}
+
+
+def _without_none(data: dict) -> dict:
+    # proto3 has no null, unset fields are the default values
+    return {key: value for key, value in data.items() if value is not None}
+
+
+def result_message(result: dict) -> dict:
+    fields = [name for name, _ in MESSAGES["Result"]]
+    return _without_none({name: result.get(name) for name in fields})
+
+
+class ControlService:
+    """The calls of the API on plain dicts, shaped like the messages."""
+
+    def __init__(self, daemon: Daemon, control_token: str):
+        self._daemon = daemon
+        self._control_token = control_token
+
+    def is_authorized(self, metadata: Iterable[Tuple[str, str]]) -> bool:
+        for key, value in metadata:
+            if key.lower() != "authorization":
+                continue
+            scheme, _, token = value.partition(" ")
+            if scheme.lower() == "bearer":
+                return hmac.compare_digest(token.encode(), self._control_token.encode())
+        return False
+
+    def trigger_update(self, request: dict) -> dict:
+        addresses = {}
+        for record_type, key, version in (("A", "ipv4", 4), ("AAAA", "ipv6", 6)):
+            if not request.get(key):
+                continue
+            try:
+                address = ipaddress.ip_address(request[key])
+            except ValueError:
+                raise ValueError(f"Invalid IP address in {key}: {request[key]}")
+            if address.version != version:
+                raise ValueError(f"{key} has to be an IPv{version} address")
+            addresses[record_type] = address
+        self._daemon.trigger_update(request.get("force", False), addresses)
+        return {"accepted": True}
+
+    def get_status(self) -> dict:
+        status = self._daemon.status()
+        fields = [name for name, _ in MESSAGES["Status"]]
+        message = _without_none({name: status.get(name) for name in fields})
+        message["results"] = [result_message(r) for r in status["results"]]
+        return message
+
+    def event(self, timestamp: float, report: Report) -> dict:
+        results = json.loads(report.json())["results"]
+        return {
+            "time": _isoformat(timestamp),
+            "status": report.status,
+            "exit_code": report.exit_code,
+            "results": [result_message(result) for result in results],
+        }
+
+    def stream_events(
+        self, only_changes: bool, is_active: Callable[[], bool]
+    ) -> Iterator[dict]:
+        subscriber = self._daemon.subscribe()
+        try:
+            while is_active():
+                try:
+                    timestamp, report = subscriber.get(timeout=STREAM_POLL_INTERVAL)
+                except queue.Empty:
+                    continue
+                if only_changes and not report.changed:
+                    continue
+                yield self.event(timestamp, report)
+        finally:
+            self._daemon.unsubscribe(subscriber)
+
+
+def build_message_classes() -> Dict[str, type]:
+    from google.protobuf import descriptor_pb2, descriptor_pool, message_factory
+
+    field_types = {
+        "bool": descriptor_pb2.FieldDescriptorProto.TYPE_BOOL,
+        "string": descriptor_pb2.FieldDescriptorProto.TYPE_STRING,
+        "int32": descriptor_pb2.FieldDescriptorProto.TYPE_INT32,
+    }
+    file_proto = descriptor_pb2.FileDescriptorProto(
+        name="cloudflare_dyndns/proto/dyndns.proto", package=PACKAGE, syntax="proto3"
+    )
+    for message_name, fields in MESSAGES.items():
+        message_proto = file_proto.message_type.add(name=message_name)
+        for number, (name, field_type) in enumerate(fields, start=1):
+            repeated, _, field_type = field_type.rpartition(" ")
+            field = message_proto.field.add(name=name, number=number)
+            field.label = (
+                descriptor_pb2.FieldDescriptorProto.LABEL_REPEATED
+                if repeated
+                else descriptor_pb2.FieldDescriptorProto.LABEL_OPTIONAL
+            )
+            if field_type in field_types:
+                field.type = field_types[field_type]
+            else:
+                field.type = descriptor_pb2.FieldDescriptorProto.TYPE_MESSAGE
+                field.type_name = f".{PACKAGE}.{field_type}"
+
+    pool = descriptor_pool.DescriptorPool()
+    pool.AddSerializedFile(file_proto.SerializeToString())
+    classes = {}
+    for message_name in MESSAGES:
+        descriptor = pool.FindMessageTypeByName(f"{PACKAGE}.{message_name}")
+        if hasattr(message_factory, "GetMessageClass"):
+            classes[message_name] = message_factory.GetMessageClass(descriptor)
+        else:
+            # protobuf before 4.21
+            factory = message_factory.MessageFactory(pool)
+            classes[message_name] = factory.GetPrototype(descriptor)
+    return classes
+
+
+class GRPCServer:
+    def __init__(self, address: str, service: ControlService):
+        try:
+            import grpc
+            from google.protobuf import json_format
+        except ImportError:
+            raise ValueError(
+                "The gRPC API needs grpcio and protobuf, "
+                "install cloudflare-dyndns[grpc]"
+            )
+        self._grpc = grpc
+        self._json_format = json_format
+        self._service = service
+        self._address = address
+        self._messages = build_message_classes()
+
+        def handler(method, request_name, response_name, streaming=False):
+            make_handler = (
+                grpc.unary_stream_rpc_method_handler
+                if streaming
+                else grpc.unary_unary_rpc_method_handler
+            )
+            return make_handler(
+                method,
+                request_deserializer=self._messages[request_name].FromString,
+                response_serializer=self._messages[response_name].SerializeToString,
+            )
+
+        handlers = {
+            "TriggerUpdate": handler(
+                self._trigger_update, "TriggerUpdateRequest", "TriggerUpdateResponse"
+            ),
+            "GetStatus": handler(self._get_status, "GetStatusRequest", "Status"),
+            "StreamEvents": handler(
+                self._stream_events, "StreamEventsRequest", "Event", streaming=True
+            ),
+        }
+        self._server = grpc.server(futures.ThreadPoolExecutor(max_workers=MAX_WORKERS))
+        self._server.add_generic_rpc_handlers(
+            (grpc.method_handlers_generic_handler(SERVICE_NAME, handlers),)
+        )
+        try:
+            self.port = self._server.add_insecure_port(address)
+        except RuntimeError as e:
+            raise ValueError(f"Can't listen on {address}: {e}")
+        if not self.port:
+            raise ValueError(f"Can't listen on {address}")
+
+    def _message(self, name: str, data: dict):
+        return self._json_format.ParseDict(data, self._messages[name]())
+
+    def _check_authorization(self, context):
+        if not self._service.is_authorized(context.invocation_metadata()):
+            context.abort(self._grpc.StatusCode.UNAUTHENTICATED, "Unauthorized")
+
+    def _trigger_update(self, request, context):
+        self._check_authorization(context)
+        request = self._json_format.MessageToDict(
+            request, preserving_proto_field_name=True
+        )
+        try:
+            response = self._service.trigger_update(request)
+        except ValueError as e:
+            context.abort(self._grpc.StatusCode.INVALID_ARGUMENT, str(e))
+        return self._message("TriggerUpdateResponse", response)
+
+    def _get_status(self, request, context):
+        self._check_authorization(context)
+        return self._message("Status", self._service.get_status())
+
+    def _stream_events(self, request, context):
+        self._check_authorization(context)
+        events = self._service.stream_events(request.only_changes, context.is_active)
+        for event in events:
+            yield self._message("Event", event)
+
+    def start(self):
+        self._server.start()
+        printer.info(f"gRPC API listening on {self._address}")
+
+    def stop(self):
+        self._server.stop(grace=1)
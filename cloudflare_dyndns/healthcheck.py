@@ -0,0 +1,92 @@
+import time
+from pathlib import Path
+from typing import Optional, Tuple
+import click
+import requests
+from .report import Report
+from . import printer
+
+
+def check_url(url: str, timeout: float) -> Tuple[bool, str]:
+    """Asks the health endpoint of a running daemon."""
+    try:
+        response = requests.get(url, timeout=timeout)
+    except requests.RequestException as e:
+        return False, f"Health endpoint is not reachable: {e}"
+    if response.status_code != 200:
+        return False, f"Unhealthy, {url} returned HTTP {response.status_code}"
+    return True, "Healthy."
+
+
+def check_report_file(path: Path, max_age: int) -> Tuple[bool, str]:
+    """The report file is written after every run, so its modification time
+    tells when the last update happened.
+    """
+    try:
+        age = time.time() - path.stat().st_mtime
+        report = Report.parse_raw(path.read_text())
+    except FileNotFoundError:
+        return False, f"No report file yet at {path}"
+    except Exception as e:
+        return False, f"Invalid report file {path}: {e}"
+    if report.exit_code != 0:
+        return False, f"The last update failed: {report.summary()}"
+    elif age > max_age:
+        return False, f"The last update was {age:.0f} seconds ago."
+    return True, f"Healthy, the last update was {age:.0f} seconds ago."
+
+
+@click.command(short_help="Exit 0 if the last update succeeded recently.")
+@click.option(
+    "--url",
+    metavar="URL",
+    help="Health endpoint of the daemon, e.g. http://127.0.0.1:8080/healthz",
+)
+@click.option(
+    "--report-file",
+    type=click.Path(dir_okay=False),
+    help="Check the report file written by the update command with --report-file.",
+)
+@click.option(
+    "--max-age",
+    type=click.IntRange(min=1),
+    default=3600,
+    show_default=True,
+    metavar="SECONDS",
+    help="With --report-file, the last update has to be more recent than this.",
+)
+@click.option(
+    "--timeout",
+    type=click.FloatRange(min=0),
+    default=5,
+    show_default=True,
+    metavar="SECONDS",
+    help="Timeout of the --url request.",
+)
+@click.pass_context
+def healthcheck(
+    ctx: click.Context,
+    url: Optional[str],
+    report_file: Optional[str],
+    max_age: int,
+    timeout: float,
+):
+    """Exits with 0 only if the last update succeeded and it is recent enough,
+    1 otherwise. Meant to be used as a Docker HEALTHCHECK command, e.g.:
+
+    \b
+    HEALTHCHECK CMD cloudflare-dyndns healthcheck --url http://127.0.0.1:8080/healthz
+    """
+    if bool(url) == bool(report_file):
+        raise click.UsageError("Use either --url or --report-file.", ctx=ctx)
+
+    if url:
+        healthy, message = check_url(url, timeout)
+    else:
+        healthy, message = check_report_file(Path(report_file), max_age)
+
+    if healthy:
+        printer.success(message)
+    else:
+        printer.error(message)
+        ctx.exit(1)
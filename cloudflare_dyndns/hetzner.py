@@ -0,0 +1,177 @@
+import os
+from typing import Optional
+import requests
+from .cache import ZoneRecord
+from .providers import (
+    AuthenticationError,
+    DNSProvider,
+    DNSProviderError,
+    RateLimitedError,
+)
+from .types import IPAddress, RecordType, get_record_type
+from . import printer, stats
+
+
+API_URL = "https://dns.hetzner.com/api/v1"
+
+
+class HetznerError(DNSProviderError):
+    """The Hetzner DNS API returned an error."""
+
+
+class HetznerAuthError(HetznerError, AuthenticationError):
+    """The API token is invalid or it has been revoked."""
+
+
+class HetznerRateLimited(HetznerError, RateLimitedError):
+    """Too many API requests in a short time."""
+
+
+class HetznerNotFound(HetznerError):
+    """The zone or the record doesn't exist (anymore)."""
+
+
+def _api_error(status_code: int, message: str) -> HetznerError:
+    if status_code in (401, 403):
+        return HetznerAuthError(message)
+    elif status_code == 429:
+        return HetznerRateLimited(message)
+    elif status_code == 404:
+        return HetznerNotFound(message)
+    return HetznerError(message)
+
+
+class HetznerProvider(DNSProvider):
+    """Updates records in Hetzner DNS zones. The API token is read from the
+    HETZNER_DNS_TOKEN environment variable if not given.
+    """
+
+    name = "Hetzner"
+
+    def __init__(self, api_token: Optional[str] = None, ttl: int = 60):
+        api_token = api_token or os.environ.get("HETZNER_DNS_TOKEN")
+        if not api_token:
+            raise HetznerError(
+                "Hetzner DNS API token is missing, set the HETZNER_DNS_TOKEN "
+                "environment variable."
+            )
+        printer.register_secret(api_token)
+        self._session = requests.Session()
+        self._session.headers["Auth-API-Token"] = api_token
+        self._ttl = ttl
+        self._zones = {}
+
+    def _request(
+        self, method: str, path: str, json: Optional[dict] = None, **kwargs
+    ) -> dict:
+        try:
+            with stats.timed("hetzner", f"{method} {path}"):
+                response = self._session.request(
+                    method, API_URL + path, json=json, timeout=30, **kwargs
+                )
+        except requests.RequestException as e:
+            raise HetznerError(f"Hetzner DNS API request failed: {e}")
+        if not response.ok:
+            raise _api_error(
+                response.status_code,
+                f"Hetzner DNS API error: {response.status_code} {response.reason}",
+            )
+        try:
+            return response.json() if response.content else {}
+        except ValueError:
+            raise HetznerError("Invalid Hetzner DNS API response")
+
+    def get_zone(self, domain: str) -> dict:
+        """The zone of the longest matching parent domain."""
+        if domain in self._zones:
+            return self._zones[domain]
+        labels = domain.rstrip(".").split(".")
+        for index in range(len(labels) - 1):
+            candidate = ".".join(labels[index:])
+            try:
+                data = self._request("GET", "/zones", params={"name": candidate})
+            except HetznerNotFound:
+                continue
+            for zone in data.get("zones", []):
+                if zone["name"] == candidate:
+                    self._zones[domain] = zone
+                    return zone
+        printer.error(f'Cannot find zone for "{domain}" at Hetzner')
+        raise HetznerError(f"No zone for {domain}")
+
+    def _find_record(self, zone: dict, domain: str, record_type: RecordType):
+        name = _record_name(zone, domain)
+        data = self._request("GET", "/records", params={"zone_id": zone["id"]})
+        for record in data.get("records", []):
+            if record["name"] == name and record["type"] == record_type:
+                return record
+        return None
+
+    def _record_body(self, zone: dict, domain: str, ip: IPAddress) -> dict:
+        return {
+            "zone_id": zone["id"],
+            "type": get_record_type(ip),
+            "name": _record_name(zone, domain),
+            "value": str(ip),
+            "ttl": self._ttl,
+        }
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record at Hetzner.',
+            domain=domain,
+            record_type=record_type,
+        )
+        zone = self.get_zone(domain)
+        body = self._record_body(zone, domain, ip)
+        if cached is not None:
+            try:
+                self._request("PUT", f"/records/{cached.record_id}", body)
+            except HetznerNotFound:
+                printer.error("Invalid cache, looking up the record again.")
+            else:
+                return cached.copy(update={"proxied": proxied})
+
+        record = self._find_record(zone, domain, record_type)
+        if record is not None:
+            self._request("PUT", f"/records/{record['id']}", body)
+            record_id = record["id"]
+        else:
+            data = self._request("POST", "/records", body)
+            printer.success(f"Created new record: {data['record']}")
+            record_id = data["record"]["id"]
+        return ZoneRecord(zone_id=zone["id"], record_id=record_id, proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        printer.warning(
+            f'Deleting {record_type} record for "{domain}" at Hetzner.',
+            domain=domain,
+            record_type=record_type,
+        )
+        zone = self.get_zone(domain)
+        record = self._find_record(zone, domain, record_type)
+        if record is None:
+            printer.info(f'{record_type} record for "{domain}" doesn\'t exist.')
+            return
+        self._request("DELETE", f"/records/{record['id']}")
+
+    def verify_credentials(self):
+        self._request("GET", "/zones", params={"per_page": 1})
+
+    def check_zone(self, domain: str):
+        self.get_zone(domain)
+
+
+def _record_name(zone: dict, domain: str) -> str:
+    """Record names are relative to the zone, "@" is the zone itself."""
+    if domain == zone["name"]:
+        return "@"
+    return domain[: -len(zone["name"]) - 1]
@@ -0,0 +1,121 @@
+"""The addresses seen over time, kept in the cache, so the TTL of the records can
+follow how often the address changes, and users can see how often it does.
+"""
+import datetime
+import time
+from typing import List, Optional
+from pydantic import BaseModel
+from .cache import IPCache, IPChange
+from .types import IPAddress
+
+
+# the oldest entries are dropped after this many
+MAX_CHANGES = 1000
+# Cloudflare allows 60 seconds to a day
+MIN_TTL = 60
+MAX_TTL = 86400
+# the TTL grows to about 1/24 of the time the address has been stable,
+# e.g. one hour after a day, in these steps, so it doesn't change in every run
+TTL_STEPS = (60, 120, 300, 600, 1800, 3600, 7200, 14400, 43200, 86400)
+STABLE_TIME_PER_TTL = 24
+
+MINUTE = 60
+HOUR = 60 * MINUTE
+DAY = 24 * HOUR
+WEEK = 7 * DAY
+
+
+def record_change(
+    ip_cache: IPCache, address: IPAddress, now: Optional[float] = None
+) -> bool:
+    """Adds the address when it's different from the last one seen.
+    Returns whether it was added.
+    """
+    changes = ip_cache.changes
+    if changes and changes[-1].address == address:
+        return False
+    changes.append(IPChange(time=now or time.time(), address=address))
+    del changes[:-MAX_CHANGES]
+    return True
+
+
+def adaptive_ttl(
+    changes: List[IPChange], floor: int, ceiling: int, now: Optional[float] = None
+) -> int:
+    """Long TTL for an address which has been stable for a long time, short one
+    right after a change.
+    """
+    if not changes:
+        return floor
+    stable_for = (now or time.time()) - changes[-1].time
+    target = stable_for / STABLE_TIME_PER_TTL
+    ttl = max([step for step in TTL_STEPS if step <= target], default=TTL_STEPS[0])
+    return min(max(ttl, floor), ceiling)
+
+
+class ChangeStats(BaseModel):
+    """How often the address changes, for choosing the interval and the TTL.
+    The first address seen is not a change.
+    """
+
+    observed_since: Optional[float] = None
+    changes: int = 0
+    changes_per_week: Optional[float] = None
+    longest_stable: Optional[float] = None
+    shortest_stable: Optional[float] = None
+    stable_for: Optional[float] = None
+    # in local time, from 0 to 23
+    changes_by_hour: List[int] = [0] * 24
+
+
+def change_stats(changes: List[IPChange], now: Optional[float] = None) -> ChangeStats:
+    stats = ChangeStats()
+    if not changes:
+        return stats
+    now = now or time.time()
+    times = [change.time for change in changes]
+    stats.observed_since = times[0]
+    stats.changes = len(times) - 1
+    stats.stable_for = now - times[-1]
+    weeks = (now - times[0]) / WEEK
+    if weeks > 0:
+        stats.changes_per_week = round(stats.changes / weeks, 2)
+    # the current period is not over yet, it can only be the longest one
+    periods = [later - earlier for earlier, later in zip(times, times[1:])]
+    stats.longest_stable = max([*periods, stats.stable_for])
+    stats.shortest_stable = min(periods, default=None)
+    for changed in times[1:]:
+        stats.changes_by_hour[datetime.datetime.fromtimestamp(changed).hour] += 1
+    return stats
+
+
+def format_duration(seconds: float) -> str:
+    parts = []
+    for unit, unit_seconds in (("d", DAY), ("h", HOUR), ("m", MINUTE)):
+        if seconds >= unit_seconds:
+            parts.append(f"{int(seconds // unit_seconds)}{unit}")
+            seconds %= unit_seconds
+    return " ".join(parts[:2]) or f"{int(seconds)}s"
+
+
+def format_stats(stats: ChangeStats) -> List[str]:
+    if stats.observed_since is None:
+        return ["No addresses seen yet."]
+    since = datetime.datetime.fromtimestamp(stats.observed_since)
+    lines = [
+        f"Observed since:    {since:%Y-%m-%d %H:%M}",
+        f"Changes:           {stats.changes}",
+    ]
+    if stats.changes_per_week is not None:
+        lines.append(f"Changes per week:  {stats.changes_per_week}")
+    lines.append(f"Longest stable:    {format_duration(stats.longest_stable)}")
+    if stats.shortest_stable is not None:
+        lines.append(f"Shortest stable:   {format_duration(stats.shortest_stable)}")
+    lines.append(f"Current address:   for {format_duration(stats.stable_for)}")
+    if stats.changes:
+        lines.append("Changes by hour of the day:")
+        most = max(stats.changes_by_hour)
+        for hour, count in enumerate(stats.changes_by_hour):
+            bar = "#" * round(count / most * 40)
+            lines.append(f"  {hour:02}:00 {count:4} {bar}".rstrip())
+    return lines
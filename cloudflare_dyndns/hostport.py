@@ -0,0 +1,13 @@
+"""The host[:port] addresses of the servers given in the options, where the host
+can be an IPv6 address too.
+"""
+from typing import Tuple
+
+
+def split_host_port(address: str, default_port: int) -> Tuple[str, int]:
+    """host, host:port, IPv6 or [IPv6]:port"""
+    host, _, port = address.rpartition(":")
+    # a bare IPv6 address has colons, but no brackets
+    if not host or not port.isdigit() or (":" in host and "]" not in host):
+        return address.strip("[]"), default_port
+    return host.strip("[]"), int(port)
@@ -0,0 +1,81 @@
+import contextlib
+import contextvars
+import functools
+import os
+from typing import Optional
+from urllib.parse import urlsplit
+import requests
+from requests.utils import should_bypass_proxies
+from . import printer
+
+
+# socks5h resolves the hostnames on the proxy side too
+PROXY_SCHEMES = ("http", "https", "socks5", "socks5h")
+# "api" is every request which is not for detecting the IP address
+TRAFFIC_TYPES = ("all", "api", "detection")
+
+_proxy_url: Optional[str] = None
+_proxied_traffic = "all"
+_current_traffic = contextvars.ContextVar("current_traffic", default="api")
+
+
+@contextlib.contextmanager
+def detection():
+    """Marks the requests made inside as IP detection traffic."""
+    token = _current_traffic.set("detection")
+    try:
+        yield
+    finally:
+        _current_traffic.reset(token)
+
+
+def _should_proxy(url: str) -> bool:
+    if not _proxy_url or should_bypass_proxies(url, _no_proxy()):
+        return False
+    return _proxied_traffic in ("all", _current_traffic.get())
+
+
+def _no_proxy() -> Optional[str]:
+    return os.environ.get("NO_PROXY") or os.environ.get("no_proxy")
+
+
+def _proxied_send(send):
+    @functools.wraps(send)
+    def proxied_send(session, request: requests.PreparedRequest, **kwargs):
+        if _should_proxy(request.url):
+            kwargs["proxies"] = {"http": _proxy_url, "https": _proxy_url}
+        return send(session, request, **kwargs)
+
+    proxied_send.is_proxied = True
+    return proxied_send
+
+
+def parse_proxy_url(proxy_url: str) -> str:
+    parts = urlsplit(proxy_url)
+    if parts.scheme not in PROXY_SCHEMES or not parts.hostname:
+        schemes = " or ".join(f"{scheme}://" for scheme in PROXY_SCHEMES)
+        raise ValueError(f"Invalid proxy URL, it has to start with {schemes}")
+    if parts.scheme.startswith("socks"):
+        try:
+            import socks  # noqa: F401
+        except ImportError:
+            raise ValueError(
+                "SOCKS proxies need PySocks, install cloudflare-dyndns[socks]"
+            )
+    return proxy_url
+
+
+def enable(proxy_url: str, traffic: str = "all"):
+    """Send every HTTP request made through the requests library through the proxy,
+    except for the hosts in NO_PROXY. Without this, requests only uses the
+    HTTP_PROXY and HTTPS_PROXY environment variables. The traffic can be
+    limited to the API or the IP detection requests.
+    """
+    global _proxy_url, _proxied_traffic
+    _proxy_url = parse_proxy_url(proxy_url)
+    _proxied_traffic = traffic
+    printer.register_secret(urlsplit(proxy_url).password)
+    send = requests.Session.send
+    if getattr(send, "is_proxied", False):
+        return
+    requests.Session.send = _proxied_send(send)
@@ -0,0 +1,223 @@
+import hmac
+import json
+import threading
+import urllib.parse
+from http import HTTPStatus
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from typing import Optional, Tuple
+from . import dashboard, printer, receiver
+
+
+# request bodies are only small forms
+MAX_BODY_SIZE = 4096
+
+API_PREFIX = "/api/"
+
+WEBHOOK_PATH = "/webhook"
+
+
+def parse_listen_address(address: str) -> Tuple[str, int]:
+    host, _, port = address.rpartition(":")
+    if not port.isdigit():
+        raise ValueError(f"Invalid listen address: {address}, use HOST:PORT")
+    return host.strip("[]") or "0.0.0.0", int(port)
+
+
+class RequestHandler(BaseHTTPRequestHandler):
+    server_version = "cloudflare-dyndns"
+
+    @property
+    def daemon(self):
+        return self.server.daemon
+
+    def send_json(self, status: HTTPStatus, body: dict):
+        payload = json.dumps(body).encode()
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def send_html(self, status: HTTPStatus, body: str):
+        payload = body.encode()
+        self.send_response(status)
+        self.send_header("Content-Type", "text/html; charset=utf-8")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def read_body(self) -> bytes:
+        length = min(int(self.headers.get("Content-Length") or 0), MAX_BODY_SIZE)
+        return self.rfile.read(length)
+
+    def read_form(self) -> dict:
+        body = self.read_body().decode(errors="replace")
+        return dict(urllib.parse.parse_qsl(body))
+
+    def read_webhook_params(self, method: str) -> dict:
+        url = urllib.parse.urlsplit(self.path)
+        params = dict(urllib.parse.parse_qsl(url.query))
+        if method == "POST":
+            content_type = self.headers.get("Content-Type", "")
+            if content_type.startswith("application/json"):
+                try:
+                    body = json.loads(self.read_body() or b"{}")
+                except ValueError:
+                    raise receiver.InvalidWebhook("Invalid JSON body")
+                if not isinstance(body, dict):
+                    raise receiver.InvalidWebhook("The JSON body must be an object")
+                params.update(body)
+            else:
+                params.update(self.read_form())
+        return params
+
+    def handle_webhook(self, method: str):
+        expected = self.server.webhook_secret
+        if not expected:
+            self.send_json(HTTPStatus.NOT_FOUND, {"error": "Not found"})
+            return
+        try:
+            params = self.read_webhook_params(method)
+        except receiver.InvalidWebhook as e:
+            self.send_json(HTTPStatus.BAD_REQUEST, {"error": str(e)})
+            return
+
+        auth_header = self.headers.get("Authorization", "")
+        scheme, _, token = auth_header.partition(" ")
+        given = (
+            self.headers.get(receiver.SECRET_HEADER)
+            or (token if scheme.lower() == "bearer" else None)
+            or params.get("secret")
+        )
+        if not receiver.check_secret(expected, given):
+            self.send_json(HTTPStatus.UNAUTHORIZED, {"error": "Unauthorized"})
+            return
+        try:
+            addresses = receiver.parse_addresses(params)
+        except receiver.InvalidWebhook as e:
+            self.send_json(HTTPStatus.BAD_REQUEST, {"error": str(e)})
+            return
+
+        self.daemon.trigger_update(addresses=addresses)
+        body = {
+            "update": "requested",
+            "addresses": {key: str(value) for key, value in addresses.items()},
+        }
+        self.send_json(HTTPStatus.ACCEPTED, body)
+
+    def is_authorized(self, form: Optional[dict] = None) -> bool:
+        """The control token can be given as a Bearer token or a form field."""
+        expected = self.server.control_token
+        if not expected:
+            return False
+        auth_header = self.headers.get("Authorization", "")
+        scheme, _, token = auth_header.partition(" ")
+        if scheme.lower() != "bearer":
+            token = (form or {}).get("token", "")
+        return hmac.compare_digest(token.encode(), expected.encode())
+
+    def handle_api(self, method: str):
+        if not self.server.control_token:
+            error = "The API is disabled, set a control token to enable it"
+            self.send_json(HTTPStatus.FORBIDDEN, {"error": error})
+            return
+        elif not self.is_authorized():
+            self.send_json(HTTPStatus.UNAUTHORIZED, {"error": "Unauthorized"})
+            return
+
+        endpoint = (method, self.path[len(API_PREFIX) :])
+        if endpoint == ("GET", "status"):
+            self.send_json(HTTPStatus.OK, self.daemon.status())
+        elif endpoint == ("POST", "update"):
+            self.daemon.trigger_update(force=True)
+            self.send_json(HTTPStatus.ACCEPTED, {"update": "requested"})
+        elif endpoint == ("POST", "pause"):
+            self.daemon.pause()
+            self.send_json(HTTPStatus.OK, {"paused": True})
+        elif endpoint == ("POST", "resume"):
+            self.daemon.resume()
+            self.send_json(HTTPStatus.OK, {"paused": False})
+        elif endpoint == ("POST", "reload"):
+            if self.daemon.request_reload():
+                self.send_json(HTTPStatus.ACCEPTED, {"reload": "requested"})
+            else:
+                error = "Nothing to reload"
+                self.send_json(HTTPStatus.CONFLICT, {"error": error})
+        else:
+            self.send_json(HTTPStatus.NOT_FOUND, {"error": "Not found"})
+
+    def do_GET(self):
+        if urllib.parse.urlsplit(self.path).path == WEBHOOK_PATH:
+            self.handle_webhook("GET")
+        elif self.path.startswith(API_PREFIX):
+            self.handle_api("GET")
+        elif self.path == "/" and self.server.dashboard:
+            self.send_html(HTTPStatus.OK, dashboard.render(self.daemon))
+        elif self.path == "/healthz":
+            healthy, body = self.daemon.health()
+            status = HTTPStatus.OK if healthy else HTTPStatus.SERVICE_UNAVAILABLE
+            self.send_json(status, body)
+        elif self.path == "/readyz":
+            ready = self.daemon.ready
+            status = HTTPStatus.OK if ready else HTTPStatus.SERVICE_UNAVAILABLE
+            self.send_json(status, {"ready": ready})
+        else:
+            self.send_json(HTTPStatus.NOT_FOUND, {"error": "Not found"})
+
+    def do_POST(self):
+        if urllib.parse.urlsplit(self.path).path == WEBHOOK_PATH:
+            self.handle_webhook("POST")
+            return
+        elif self.path.startswith(API_PREFIX):
+            self.handle_api("POST")
+            return
+        elif self.path != "/update":
+            self.send_json(HTTPStatus.NOT_FOUND, {"error": "Not found"})
+            return
+        form = self.read_form()
+        from_dashboard = "token" in form
+        if not self.is_authorized(form):
+            if from_dashboard:
+                page = dashboard.render(self.daemon, "Invalid control token.")
+                self.send_html(HTTPStatus.FORBIDDEN, page)
+            else:
+                self.send_json(HTTPStatus.FORBIDDEN, {"error": "Forbidden"})
+            return
+
+        self.daemon.trigger_update(force=True)
+        if from_dashboard:
+            # Post/Redirect/Get, so reloading the page doesn't trigger an update again
+            self.send_response(HTTPStatus.SEE_OTHER)
+            self.send_header("Location", "/")
+            self.send_header("Content-Length", "0")
+            self.end_headers()
+        else:
+            self.send_json(HTTPStatus.ACCEPTED, {"update": "requested"})
+
+    def log_message(self, format: str, *args):
+        # health checks would flood the output
+        pass
+
+
+class StatusServer(ThreadingHTTPServer):
+    daemon_threads = True
+
+    def __init__(
+        self,
+        address: Tuple[str, int],
+        daemon,
+        dashboard: bool = False,
+        control_token: Optional[str] = None,
+        webhook_secret: Optional[str] = None,
+    ):
+        super().__init__(address, RequestHandler)
+        self.daemon = daemon
+        self.dashboard = dashboard
+        self.control_token = control_token
+        self.webhook_secret = webhook_secret
+
+    def start(self):
+        host, port = self.server_address[:2]
+        printer.info(f"Listening on http://{host}:{port}/")
+        thread = threading.Thread(target=self.serve_forever, daemon=True)
+        thread.start()
@@ -0,0 +1,56 @@
+import functools
+import time
+from typing import Mapping
+import requests
+from . import printer
+
+
+REDACTED_HEADERS = {"authorization", "x-auth-key", "x-auth-email", "cookie"}
+
+
+def redact_headers(headers: Mapping[str, str]) -> dict:
+    return {
+        name: "<redacted>" if name.lower() in REDACTED_HEADERS else value
+        for name, value in headers.items()
+    }
+
+
+def _format_headers(headers: Mapping[str, str]) -> str:
+    return ", ".join(f"{name}: {value}" for name, value in headers.items())
+
+
+def _traced_send(send):
+    @functools.wraps(send)
+    def traced_send(session, request: requests.PreparedRequest, **kwargs):
+        printer.info(f"--> {request.method} {request.url}")
+        printer.info(f"    {_format_headers(redact_headers(request.headers))}")
+        start = time.monotonic()
+        try:
+            response = send(session, request, **kwargs)
+        except requests.exceptions.RequestException as e:
+            duration = time.monotonic() - start
+            printer.warning(f"<-- {request.method} {request.url} failed: {e}")
+            printer.warning(f"    duration: {duration * 1000:.0f}ms")
+            raise
+
+        duration = time.monotonic() - start
+        ray_id = response.headers.get("cf-ray", "-")
+        printer.info(
+            f"<-- {response.status_code} {response.reason} {request.method} "
+            f"{request.url} ({duration * 1000:.0f}ms, CF-Ray: {ray_id})"
+        )
+        printer.info(f"    {_format_headers(redact_headers(response.headers))}")
+        return response
+
+    traced_send.is_traced = True
+    return traced_send
+
+
+def enable():
+    """Log every HTTP request and response made through the requests library.
+    Both the Cloudflare client and the IP services use it, so this covers everything.
+    """
+    send = requests.Session.send
+    if getattr(send, "is_traced", False):
+        return
+    requests.Session.send = _traced_send(send)
@@ -0,0 +1,462 @@
+"""Converts the configuration of other dynamic DNS clients to an environment
+file for this tool, which systemd can load with EnvironmentFile= or a shell can
+source, so migrating doesn't need re-entering every token and hostname.
+"""
+import os
+import re
+import shlex
+from pathlib import Path
+from typing import Any, Dict, List, Optional, Tuple
+import attr
+import click
+from . import printer
+
+
+DURATION_UNITS = {"s": 1, "m": 60, "h": 3600, "d": 86400}
+
+# ddclient lines are "key=value, key=value host1,host2", values can be quoted
+DDCLIENT_SETTING = re.compile(r"""[\s,]*([\w-]+)\s*=\s*('[^']*'|"[^"]*"|[^\s,]*)""")
+# whitespace, comments, quoted strings, punctuation and bare words of inadyn.conf
+INADYN_TOKEN = re.compile(
+    r"""\s+|#[^\n]*|"([^"]*)"|'([^']*)'|([{}=,])|([^\s{}=,"'#]+)"""
+)
+
+
+class ConfigImportError(Exception):
+    """The configuration can't be converted."""
+
+
+@attr.s(auto_attribs=True)
+class ImportedConfig:
+    api_token: Optional[str] = None
+    domains: List[str] = attr.Factory(list)
+    ipv4: bool = True
+    ipv6: bool = False
+    ipv4_sources: List[str] = attr.Factory(list)
+    ipv6_sources: List[str] = attr.Factory(list)
+    interval: Optional[int] = None
+    proxied: bool = False
+    # record types of the domains which differ from ipv4 and ipv6, e.g. "4,6"
+    domain_families: Dict[str, str] = attr.Factory(dict)
+    # settings which have no equivalent, written as comments
+    notes: List[str] = attr.Factory(list)
+
+    def add_domain(self, domain: str):
+        domain = domain.strip().rstrip(".")
+        if domain and domain not in self.domains:
+            self.domains.append(domain)
+
+    def set_domains(self, ipv4_domains: List[str], ipv6_domains: List[str]):
+        """The two lists can be different, then the domains which are only in
+        one of them get their own record types.
+        """
+        self.ipv4, self.ipv6 = bool(ipv4_domains), bool(ipv6_domains)
+        for domain in [*ipv4_domains, *ipv6_domains]:
+            self.add_domain(domain)
+        for domain in self.domains:
+            families = [
+                family
+                for family, domains in (("4", ipv4_domains), ("6", ipv6_domains))
+                if domain in domains
+            ]
+            if len(families) < self.ipv4 + self.ipv6:
+                self.domain_families[domain] = ",".join(families)
+
+    def set_api_token(self, api_token: str):
+        if self.api_token is not None and api_token != self.api_token:
+            raise ConfigImportError(
+                "The domains use different API tokens, "
+                "which needs a separate configuration for each of them."
+            )
+        self.api_token = api_token
+
+    def to_env_file(self) -> str:
+        if not self.domains:
+            raise ConfigImportError("There are no Cloudflare domains to import.")
+        if self.api_token is None:
+            raise ConfigImportError("There is no API token in the configuration.")
+        variables = {
+            "CLOUDFLARE_API_TOKEN": self.api_token,
+            "CLOUDFLARE_DOMAINS": " ".join(self.domains),
+        }
+        # only the changes from the defaults
+        if not self.ipv4:
+            variables["CLOUDFLARE_DYNDNS_IPV4"] = "false"
+        if self.ipv6:
+            variables["CLOUDFLARE_DYNDNS_IPV6"] = "true"
+        if self.ipv4_sources:
+            variables["CLOUDFLARE_DYNDNS_IPV4_SOURCES"] = " ".join(self.ipv4_sources)
+        if self.ipv6_sources:
+            variables["CLOUDFLARE_DYNDNS_IPV6_SOURCES"] = " ".join(self.ipv6_sources)
+        if self.domain_families:
+            variables["CLOUDFLARE_DYNDNS_DOMAIN_FAMILIES"] = " ".join(
+                f"{domain}={families}"
+                for domain, families in self.domain_families.items()
+            )
+        if self.proxied:
+            variables["CLOUDFLARE_DYNDNS_PROXIED"] = "true"
+        if self.interval is not None:
+            variables["CLOUDFLARE_DYNDNS_INTERVAL"] = str(self.interval)
+        lines = [f"# not imported: {note}" for note in self.notes]
+        lines += [f"{name}={shlex.quote(value)}" for name, value in variables.items()]
+        return "\n".join(lines) + "\n"
+
+
+def _seconds(value: str) -> Optional[int]:
+    number, unit = value, "s"
+    if value and value[-1] in DURATION_UNITS:
+        number, unit = value[:-1], value[-1]
+    if not number.isdigit() or int(number) == 0:
+        return None
+    return int(number) * DURATION_UNITS[unit]
+
+
+def _unquote(value: str) -> str:
+    if len(value) >= 2 and value[0] == value[-1] and value[0] in "'\"":
+        return value[1:-1]
+    return value
+
+
+def _ddclient_lines(text: str) -> List[str]:
+    """Logical lines without the comments, continuation lines joined."""
+    lines, current = [], ""
+    for line in text.splitlines():
+        line = re.sub(r"(?<!\\)#.*", "", line).rstrip()
+        if line.endswith("\\"):
+            current += line[:-1] + " "
+            continue
+        current += line
+        if current.strip():
+            lines.append(current.strip())
+        current = ""
+    if current.strip():
+        lines.append(current.strip())
+    return lines
+
+
+def _ddclient_source(
+    settings: Dict[str, str], method: str, config: ImportedConfig
+) -> Optional[str]:
+    """IP source from a use=, usev4= or usev6= method, None for the default."""
+    kind = method[:-2] if method.endswith(("v4", "v6")) else method
+    value = settings.get(method) or settings.get(kind, "")
+    if kind == "web":
+        # the named services of ddclient are replaced with the built-in ones
+        return f"http:{value}" if value.startswith(("http://", "https://")) else None
+    elif kind == "if" and value:
+        return f"interface:{value}"
+    elif kind == "cmd" and value:
+        if " " in value:
+            config.notes.append(f"{method}={value} (commands with arguments)")
+            return None
+        return f"exec:{value}"
+    config.notes.append(f"use={method} (the address is detected instead)")
+    return None
+
+
+def parse_ddclient(text: str) -> ImportedConfig:
+    """Imports the hosts with protocol=cloudflare from a ddclient.conf."""
+    config = ImportedConfig()
+    global_settings: Dict[str, str] = {}
+    imported_settings: Optional[Dict[str, str]] = None
+    for line in _ddclient_lines(text):
+        settings, position = {}, 0
+        while True:
+            match = DDCLIENT_SETTING.match(line, position)
+            if not match:
+                break
+            settings[match[1].lower()] = _unquote(match[2])
+            position = match.end()
+        hosts = [host for host in re.split(r"[\s,]+", line[position:]) if host]
+        if not hosts:
+            global_settings.update(settings)
+            continue
+
+        host_settings = {**global_settings, **settings}
+        if host_settings.get("protocol") != "cloudflare":
+            continue
+        if host_settings.get("login", "token") != "token":
+            raise ConfigImportError(
+                f"{', '.join(hosts)}: only API tokens (login=token) can be "
+                "imported, create one instead of the Global API Key."
+            )
+        if "password" not in host_settings:
+            raise ConfigImportError(f"{', '.join(hosts)}: no API token (password=)")
+        config.set_api_token(host_settings["password"])
+        for host in hosts:
+            config.add_domain(host)
+        if imported_settings is None:
+            imported_settings = host_settings
+
+    if imported_settings is None:
+        raise ConfigImportError("There are no hosts with protocol=cloudflare.")
+    _import_ddclient_settings(imported_settings, config)
+    return config
+
+
+def _import_ddclient_settings(settings: Dict[str, str], config: ImportedConfig):
+    usev4 = settings.get("usev4") or settings.get("use")
+    usev6 = settings.get("usev6")
+    config.ipv6 = usev6 not in (None, "disabled")
+    config.ipv4 = usev4 != "disabled" and (usev4 is not None or not config.ipv6)
+    if config.ipv4 and usev4 is not None:
+        source = _ddclient_source(settings, usev4, config)
+        if source:
+            config.ipv4_sources.append(source)
+    if config.ipv6:
+        source = _ddclient_source(settings, usev6, config)
+        if source:
+            config.ipv6_sources.append(source)
+    if "daemon" in settings:
+        config.interval = _seconds(settings["daemon"])
+        if config.interval is None:
+            config.notes.append(f"daemon={settings['daemon']}")
+    if "ttl" in settings:
+        config.notes.append(f"ttl={settings['ttl']} (records keep their TTL)")
+
+
+def _load_yaml(text: str) -> Any:
+    try:
+        import yaml
+    except ImportError:
+        raise ConfigImportError(
+            "Importing YAML needs PyYAML, install cloudflare-dyndns[yaml]"
+        )
+    try:
+        return yaml.safe_load(text)
+    except yaml.YAMLError as e:
+        raise ConfigImportError(f"Invalid YAML: {e}")
+
+
+def _ddns_go_domain(value: str, config: ImportedConfig) -> str:
+    """ddns-go domains can be "sub:example.com" and have parameters after a ?."""
+    domain, _, params = value.strip().partition("?")
+    if "proxied=true" in params.lower().split("&"):
+        config.proxied = True
+    subdomain, sep, zone = domain.partition(":")
+    return f"{subdomain}.{zone}" if sep else domain
+
+
+def _ddns_go_sources(settings: Dict[str, Any], config: ImportedConfig) -> List[str]:
+    get_type = str(settings.get("gettype") or "url").lower()
+    if get_type == "url":
+        urls = str(settings.get("url") or "").split(",")
+        return [f"http:{url.strip()}" for url in urls if url.strip()]
+    elif get_type == "netinterface" and settings.get("netinterface"):
+        return [f"interface:{settings['netinterface']}"]
+    elif get_type == "cmd" and settings.get("cmd"):
+        if " " in settings["cmd"]:
+            config.notes.append(f"cmd: {settings['cmd']} (commands with arguments)")
+            return []
+        return [f"exec:{settings['cmd']}"]
+    config.notes.append(f"gettype: {get_type} (the address is detected instead)")
+    return []
+
+
+def parse_ddns_go(data: Any) -> ImportedConfig:
+    """Imports the Cloudflare entries of a parsed ddns-go config.yaml. Older
+    versions had only one entry at the top level, newer ones a dnsconf list.
+    """
+    if not isinstance(data, dict):
+        raise ConfigImportError("Not a ddns-go configuration.")
+    config = ImportedConfig()
+    entries = [
+        entry
+        for entry in data.get("dnsconf", [data])
+        if (entry.get("dns") or {}).get("name") == "cloudflare"
+    ]
+    if not entries:
+        raise ConfigImportError("There are no entries with Cloudflare as DNS.")
+    domains: Dict[str, List[str]] = {"ipv4": [], "ipv6": []}
+    for entry in entries:
+        if not entry["dns"].get("secret"):
+            raise ConfigImportError(f"{entry.get('name') or 'dnsconf'}: no API token")
+        config.set_api_token(entry["dns"]["secret"])
+        for family, family_domains in domains.items():
+            settings = entry.get(family) or {}
+            if not settings.get("enable"):
+                continue
+            for value in settings.get("domains") or []:
+                family_domains.append(_ddns_go_domain(value, config))
+            sources = config.ipv4_sources if family == "ipv4" else config.ipv6_sources
+            if entry is entries[0]:
+                sources.extend(_ddns_go_sources(settings, config))
+        if entry.get("ttl"):
+            config.notes.append(f"ttl: {entry['ttl']} (records keep their TTL)")
+    config.set_domains(domains["ipv4"], domains["ipv6"])
+    return config
+
+
+def _inadyn_tokens(text: str) -> List[str]:
+    tokens, position = [], 0
+    while position < len(text):
+        match = INADYN_TOKEN.match(text, position)
+        if not match:
+            raise ConfigImportError(f"Unexpected character: {text[position]}")
+        position = match.end()
+        token = next((group for group in match.groups() if group is not None), None)
+        if token is not None:
+            tokens.append(token)
+    return tokens
+
+
+def _inadyn_settings(
+    tokens: List[str], position: int, in_section: bool
+) -> Tuple[Dict[str, Any], List[Tuple[str, Dict[str, Any]]], int]:
+    """Settings and sections (provider NAME { ... }) from the position until the
+    closing brace of the section, or the end of the file.
+    """
+    settings: Dict[str, Any] = {}
+    sections = []
+    try:
+        while position < len(tokens):
+            key = tokens[position]
+            if key == "}" and in_section:
+                return settings, sections, position + 1
+            elif key in ("provider", "custom"):
+                name, brace = tokens[position + 1], tokens[position + 2]
+                if brace != "{":
+                    raise ConfigImportError(f'Missing "{{" after {key} {name}')
+                section, _, position = _inadyn_settings(tokens, position + 3, True)
+                sections.append((name, section))
+                continue
+            if tokens[position + 1] != "=":
+                raise ConfigImportError(f'Missing "=" after {key}')
+            value: Any = tokens[position + 2]
+            position += 3
+            if value == "{":
+                value = []
+                while tokens[position] != "}":
+                    if tokens[position] != ",":
+                        value.append(tokens[position])
+                    position += 1
+                position += 1
+            settings[key.lower()] = value
+    except IndexError:
+        raise ConfigImportError("Unexpected end of file")
+    if in_section:
+        raise ConfigImportError('Missing "}" at the end of the file')
+    return settings, sections, position
+
+
+def _inadyn_source(settings: Dict[str, Any], config: ImportedConfig) -> Optional[str]:
+    if "checkip-command" in settings:
+        command = settings["checkip-command"]
+        if " " in command:
+            config.notes.append(f"checkip-command = {command} (with arguments)")
+            return None
+        return f"exec:{command}"
+    elif "checkip-server" in settings:
+        ssl = str(settings.get("checkip-ssl", "true")).lower() != "false"
+        scheme = "https" if ssl else "http"
+        path = settings.get("checkip-path", "/")
+        return f"http:{scheme}://{settings['checkip-server']}{path}"
+    elif "iface" in settings:
+        return f"interface:{settings['iface']}"
+    return None
+
+
+def parse_inadyn(text: str) -> ImportedConfig:
+    """Imports the cloudflare.com providers of an inadyn.conf. The ones named
+    ipv6@cloudflare.com update AAAA records.
+    """
+    global_settings, sections, _ = _inadyn_settings(_inadyn_tokens(text), 0, False)
+    config = ImportedConfig()
+    domains: Dict[str, List[str]] = {"ipv4": [], "ipv6": []}
+    imported = False
+    for name, settings in sections:
+        family, _, provider = name.rpartition("@")
+        if provider.split(":")[0].lower() != "cloudflare.com":
+            continue
+        if "password" not in settings:
+            raise ConfigImportError(f"{name}: no API token (password =)")
+        config.set_api_token(settings["password"])
+        hostnames = settings.get("hostname", [])
+        if isinstance(hostnames, str):
+            hostnames = [hostnames]
+        family = "ipv6" if family.lower() == "ipv6" else "ipv4"
+        domains[family].extend(hostnames)
+        if str(settings.get("proxied", "false")).lower() == "true":
+            config.proxied = True
+        sources = config.ipv4_sources if family == "ipv4" else config.ipv6_sources
+        source = _inadyn_source({**global_settings, **settings}, config)
+        if source and source not in sources:
+            sources.append(source)
+        if "ttl" in settings:
+            config.notes.append(f"ttl = {settings['ttl']} (records keep their TTL)")
+        imported = True
+
+    if not imported:
+        raise ConfigImportError("There are no cloudflare.com providers.")
+    config.set_domains(domains["ipv4"], domains["ipv6"])
+    if "period" in global_settings:
+        config.interval = _seconds(str(global_settings["period"]))
+    return config
+
+
+def write_config(config: ImportedConfig, output: Optional[str]):
+    try:
+        env_file = config.to_env_file()
+    except ConfigImportError as e:
+        raise click.ClickException(str(e))
+    if output is None:
+        # the notes are in the comments
+        click.echo(env_file, nl=False)
+        return
+    for note in config.notes:
+        printer.warning(f"Not imported: {note}")
+    path = Path(output)
+    # it holds the API token
+    path.touch(mode=0o600)
+    os.chmod(path, 0o600)
+    path.write_text(env_file)
+    printer.success(f"Configuration written to {path}")
+
+
+OUTPUT_OPTION = click.option(
+    "-o",
+    "--output",
+    type=click.Path(dir_okay=False),
+    help="Write the environment file here instead of the standard output.",
+)
+
+
+@click.group(name="import")
+def import_config():
+    """Import the configuration of another dynamic DNS client."""
+
+
+@import_config.command()
+@click.argument("config_file", type=click.Path(exists=True, dir_okay=False))
+@OUTPUT_OPTION
+def ddclient(config_file: str, output: Optional[str]):
+    """Convert the Cloudflare hosts of a ddclient.conf."""
+    try:
+        config = parse_ddclient(Path(config_file).read_text())
+    except ConfigImportError as e:
+        raise click.ClickException(str(e))
+    write_config(config, output)
+
+
+@import_config.command(name="ddns-go")
+@click.argument("config_file", type=click.Path(exists=True, dir_okay=False))
+@OUTPUT_OPTION
+def ddns_go(config_file: str, output: Optional[str]):
+    """Convert the Cloudflare entries of a ddns-go config.yaml (needs PyYAML)."""
+    try:
+        config = parse_ddns_go(_load_yaml(Path(config_file).read_text()))
+    except ConfigImportError as e:
+        raise click.ClickException(str(e))
+    write_config(config, output)
+
+
+@import_config.command()
+@click.argument("config_file", type=click.Path(exists=True, dir_okay=False))
+@OUTPUT_OPTION
+def inadyn(config_file: str, output: Optional[str]):
+    """Convert the cloudflare.com providers of an inadyn.conf."""
+    try:
+        config = parse_inadyn(Path(config_file).read_text())
+    except ConfigImportError as e:
+        raise click.ClickException(str(e))
+    write_config(config, output)
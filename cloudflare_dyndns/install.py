@@ -0,0 +1,501 @@
+import os
+import plistlib
+import shlex
+import shutil
+import subprocess
+import sys
+from pathlib import Path
+from typing import List, Optional, Tuple, Union
+import click
+from . import printer
+
+
+SERVICE_NAME = "cloudflare-dyndns"
+SYSTEMD_UNIT_DIR = Path("/etc/systemd/system")
+CREDENTIALS_DIR = Path("/etc/cloudflare-dyndns")
+
+LAUNCHD_LABEL = "com.github.kissgyorgy.cloudflare-dyndns"
+WINDOWS_TASK_NAME = "CloudFlare DynDNS"
+# changes whenever the network configuration (and the DNS resolver) changes
+MACOS_NETWORK_CHANGE_PATH = "/private/var/run/resolv.conf"
+
+
+def find_executable() -> str:
+    """Absolute path of the currently running script or binary."""
+    executable = shutil.which(SERVICE_NAME)
+    if executable is not None:
+        return executable
+    # e.g. standalone binary which is not on PATH
+    return str(Path(sys.argv[0]).resolve())
+
+
+def systemd_service_unit(
+    executable: str,
+    args: List[str],
+    credential_path: Path,
+    interval: Optional[int] = None,
+) -> str:
+    if interval is not None:
+        args = ["--interval", str(interval), *args]
+    exec_start = " ".join(
+        shlex.quote(arg)
+        for arg in [
+            executable,
+            "--api-token-file",
+            "${CREDENTIALS_DIRECTORY}/api-token",
+            "--cache-file",
+            f"%C/{SERVICE_NAME}/ip.cache",
+            *args,
+        ]
+    )
+    # shlex.quote would prevent systemd from expanding the variable
+    exec_start = exec_start.replace(
+        "'${CREDENTIALS_DIRECTORY}/api-token'", "${CREDENTIALS_DIRECTORY}/api-token"
+    )
+    if interval is None:
+        service_options = "Type=oneshot"
+    else:
+        # the daemon sends READY=1, STATUS= and WATCHDOG=1 notifications
+        service_options = (
+            "Type=notify\nNotifyAccess=main\nWatchdogSec=5min\n"
+            "Restart=on-failure\nRestartSec=30s"
+        )
+    return f"""\
+[Unit]
+Description=CloudFlare Dynamic DNS client
+Documentation=https://github.com/kissgyorgy/cloudflare-dyndns
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+{service_options}
+ExecStart={exec_start}
+LoadCredential=api-token:{credential_path}
+CacheDirectory={SERVICE_NAME}
+DynamicUser=yes
+ProtectSystem=strict
+ProtectHome=yes
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+NoNewPrivileges=yes
+CapabilityBoundingSet=
+SystemCallArchitectures=native
+SystemCallFilter=@system-service
+UMask=0077
+
+[Install]
+WantedBy=multi-user.target
+"""
+
+
+def systemd_timer_unit(interval: str) -> str:
+    return f"""\
+[Unit]
+Description=Run CloudFlare Dynamic DNS client every {interval}
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec={interval}
+RandomizedDelaySec=15s
+
+[Install]
+WantedBy=timers.target
+"""
+
+
+def launchd_plist(
+    executable: str,
+    args: List[str],
+    token_path: Path,
+    cache_path: Path,
+    log_path: Path,
+    interval: Optional[int] = None,
+    network_change: bool = False,
+) -> bytes:
+    program_arguments = [
+        executable,
+        "--api-token-file",
+        str(token_path),
+        "--cache-file",
+        str(cache_path),
+        *args,
+    ]
+    plist = {
+        "Label": LAUNCHD_LABEL,
+        "ProgramArguments": program_arguments,
+        "RunAtLoad": True,
+        "StandardOutPath": str(log_path),
+        "StandardErrorPath": str(log_path),
+    }
+    if interval:
+        plist["StartInterval"] = interval
+    if network_change:
+        plist["WatchPaths"] = [MACOS_NETWORK_CHANGE_PATH]
+    return plistlib.dumps(plist)
+
+
+def _write_file(path: Path, content: Union[str, bytes], mode: int = 0o644):
+    printer.info(f"Writing {path}")
+    path.parent.mkdir(parents=True, exist_ok=True)
+    if isinstance(content, bytes):
+        path.write_bytes(content)
+    else:
+        path.write_text(content)
+    path.chmod(mode)
+
+
+def _remove_file(path: Path):
+    if path.exists():
+        printer.info(f"Removing {path}")
+        path.unlink()
+
+
+def _run(*args: str, check: bool = True):
+    printer.info("Running: " + " ".join(args))
+    subprocess.run(args, check=check)
+
+
+def _systemctl(*args: str):
+    _run("systemctl", *args)
+
+
+def install_systemd(
+    domains: List[str],
+    api_token: str,
+    timer: Optional[str],
+    interval: Optional[int],
+    update_args: str,
+    unit_path: Path = SYSTEMD_UNIT_DIR,
+    credential_path: Path = CREDENTIALS_DIR / "api-token",
+    enable: bool = True,
+):
+    if os.geteuid() != 0 and enable:
+        printer.warning("You probably need to run this as root.")
+
+    _write_file(credential_path, api_token + "\n", mode=0o600)
+
+    args = shlex.split(update_args) + list(domains)
+    service = systemd_service_unit(
+        find_executable(), args, credential_path, interval
+    )
+    _write_file(unit_path / f"{SERVICE_NAME}.service", service)
+    if timer:
+        _write_file(unit_path / f"{SERVICE_NAME}.timer", systemd_timer_unit(timer))
+
+    if not enable:
+        return
+
+    _systemctl("daemon-reload")
+    unit = f"{SERVICE_NAME}.timer" if timer else f"{SERVICE_NAME}.service"
+    _systemctl("enable", "--now", unit)
+    printer.success(f"Installed and started {unit}")
+
+
+def uninstall_systemd(
+    unit_path: Path = SYSTEMD_UNIT_DIR,
+    credential_path: Path = CREDENTIALS_DIR / "api-token",
+):
+    for unit in (f"{SERVICE_NAME}.timer", f"{SERVICE_NAME}.service"):
+        if (unit_path / unit).exists():
+            _systemctl("disable", "--now", unit)
+            _remove_file(unit_path / unit)
+    _remove_file(credential_path)
+    _systemctl("daemon-reload")
+    printer.success("Removed systemd units.")
+
+
+def launchd_paths(system: bool) -> Tuple[Path, Path, Path]:
+    """Directory of the plist, the data directory and the log file."""
+    if system:
+        return (
+            Path("/Library/LaunchDaemons"),
+            Path("/usr/local/var/cloudflare-dyndns"),
+            Path("/usr/local/var/log/cloudflare-dyndns.log"),
+        )
+    return (
+        Path("~/Library/LaunchAgents").expanduser(),
+        Path("~/Library/Application Support/cloudflare-dyndns").expanduser(),
+        Path("~/Library/Logs/cloudflare-dyndns.log").expanduser(),
+    )
+
+
+def install_launchd(
+    domains: List[str],
+    api_token: str,
+    interval: int,
+    network_change: bool,
+    system: bool,
+    update_args: str,
+    load: bool = True,
+):
+    plist_dir, data_dir, log_path = launchd_paths(system)
+    token_path = data_dir / "api-token"
+    _write_file(token_path, api_token + "\n", mode=0o600)
+    log_path.parent.mkdir(parents=True, exist_ok=True)
+
+    args = shlex.split(update_args) + list(domains)
+    plist = launchd_plist(
+        find_executable(),
+        args,
+        token_path,
+        data_dir / "ip.cache",
+        log_path,
+        interval,
+        network_change,
+    )
+    plist_path = plist_dir / f"{LAUNCHD_LABEL}.plist"
+    _write_file(plist_path, plist)
+
+    if not load:
+        return
+
+    # unload first, so reinstalling picks up the new configuration
+    subprocess.run(["launchctl", "unload", str(plist_path)], capture_output=True)
+    _run("launchctl", "load", "-w", str(plist_path))
+    printer.success(f"Installed and loaded {LAUNCHD_LABEL}")
+
+
+def uninstall_launchd(system: bool):
+    plist_dir, data_dir, _ = launchd_paths(system)
+    plist_path = plist_dir / f"{LAUNCHD_LABEL}.plist"
+    if plist_path.exists():
+        _run("launchctl", "unload", "-w", str(plist_path), check=False)
+        _remove_file(plist_path)
+    _remove_file(data_dir / "api-token")
+    printer.success(f"Removed {LAUNCHD_LABEL}")
+
+
+def windows_data_dir() -> Path:
+    local_app_data = os.environ.get("LOCALAPPDATA", "~/AppData/Local")
+    return Path(local_app_data).expanduser() / "cloudflare-dyndns"
+
+
+def install_windows_task(
+    domains: List[str], api_token: str, interval: int, update_args: str
+):
+    """There is no service wrapper on Windows, so a scheduled task runs the update."""
+    data_dir = windows_data_dir()
+    token_path = data_dir / "api-token"
+    _write_file(token_path, api_token + "\n", mode=0o600)
+
+    args = [
+        find_executable(),
+        "--api-token-file",
+        str(token_path),
+        *shlex.split(update_args),
+        *domains,
+    ]
+    minutes = max(1, interval // 60)
+    _run(
+        "schtasks",
+        "/Create",
+        "/F",
+        "/TN",
+        WINDOWS_TASK_NAME,
+        "/SC",
+        "MINUTE",
+        "/MO",
+        str(minutes),
+        "/TR",
+        subprocess.list2cmdline(args),
+    )
+    printer.success(f'Installed scheduled task "{WINDOWS_TASK_NAME}"')
+
+
+def uninstall_windows_task():
+    _run("schtasks", "/Delete", "/F", "/TN", WINDOWS_TASK_NAME, check=False)
+    _remove_file(windows_data_dir() / "api-token")
+    printer.success(f'Removed scheduled task "{WINDOWS_TASK_NAME}"')
+
+
+API_TOKEN_OPTION = click.option(
+    "--api-token",
+    envvar="CLOUDFLARE_API_TOKEN",
+    prompt=True,
+    hide_input=True,
+    help="Stored in a file only readable by the owner and passed to the service.",
+)
+UPDATE_ARGS_OPTION = click.option(
+    "--update-args",
+    default="",
+    help='Extra options for the update command, e.g. "-6 --proxied".',
+)
+
+
+@click.group()
+def install():
+    """Install the updater as a system service."""
+
+
+@install.command()
+@click.argument("domains", nargs=-1, required=True)
+@API_TOKEN_OPTION
+@click.option(
+    "--timer",
+    metavar="INTERVAL",
+    help='Install a timer running the update periodically, e.g. "5min" or "1h".',
+)
+@click.option(
+    "--interval",
+    type=click.IntRange(min=1),
+    metavar="SECONDS",
+    help="Install a long running daemon service checking every SECONDS instead.",
+)
+@UPDATE_ARGS_OPTION
+@click.option(
+    "--unit-dir",
+    type=click.Path(file_okay=False),
+    default=str(SYSTEMD_UNIT_DIR),
+    show_default=True,
+)
+@click.option(
+    "--credential-file",
+    type=click.Path(dir_okay=False),
+    default=str(CREDENTIALS_DIR / "api-token"),
+    show_default=True,
+)
+@click.option(
+    "--no-enable", is_flag=True, help="Only write the units, don't enable them."
+)
+def systemd(
+    domains: List[str],
+    api_token: str,
+    timer: Optional[str],
+    interval: Optional[int],
+    update_args: str,
+    unit_dir: str,
+    credential_file: str,
+    no_enable: bool,
+):
+    """Write a hardened systemd service unit and an optional timer."""
+    if timer and interval:
+        raise click.UsageError("Use either --timer or --interval, not both!")
+    install_systemd(
+        domains,
+        api_token,
+        timer,
+        interval,
+        update_args,
+        Path(unit_dir),
+        Path(credential_file),
+        enable=not no_enable,
+    )
+
+
+@install.command()
+@click.argument("domains", nargs=-1, required=True)
+@API_TOKEN_OPTION
+@click.option(
+    "--interval",
+    type=click.IntRange(min=1),
+    default=300,
+    show_default=True,
+    metavar="SECONDS",
+    help="Run the update every SECONDS.",
+)
+@click.option(
+    "--network-change/--no-network-change",
+    default=True,
+    show_default=True,
+    help="Run the update whenever the network configuration changes.",
+)
+@click.option(
+    "--system",
+    is_flag=True,
+    help=(
+        "Install a LaunchDaemon running at boot, without anybody logged in "
+        "(needs root), instead of a LaunchAgent for the current user."
+    ),
+)
+@UPDATE_ARGS_OPTION
+@click.option(
+    "--no-load", is_flag=True, help="Only write the plist, don't load it."
+)
+def launchd(
+    domains: List[str],
+    api_token: str,
+    interval: int,
+    network_change: bool,
+    system: bool,
+    update_args: str,
+    no_load: bool,
+):
+    """Write and load a macOS LaunchAgent or LaunchDaemon."""
+    install_launchd(
+        domains,
+        api_token,
+        interval,
+        network_change,
+        system,
+        update_args,
+        load=not no_load,
+    )
+
+
+@click.command("install-service")
+@click.argument("domains", nargs=-1, required=True)
+@API_TOKEN_OPTION
+@click.option(
+    "--interval",
+    type=click.IntRange(min=60),
+    default=300,
+    show_default=True,
+    metavar="SECONDS",
+    help="Run the update every SECONDS.",
+)
+@click.option(
+    "--system",
+    is_flag=True,
+    help="On macOS, install a LaunchDaemon instead of a LaunchAgent.",
+)
+@UPDATE_ARGS_OPTION
+def install_service(
+    domains: List[str], api_token: str, interval: int, system: bool, update_args: str
+):
+    """Install a service running the update periodically.
+
+    Installs a systemd timer on Linux, a launchd agent on macOS
+    and a scheduled task on Windows.
+    """
+    if sys.platform == "darwin":
+        install_launchd(domains, api_token, interval, True, system, update_args)
+    elif sys.platform == "win32":
+        install_windows_task(domains, api_token, interval, update_args)
+    elif shutil.which("systemctl"):
+        install_systemd(domains, api_token, f"{interval}s", None, update_args)
+    else:
+        raise click.ClickException(
+            f"Don't know how to install a service on this platform ({sys.platform})."
+        )
+
+
+@click.command("uninstall-service")
+@click.option(
+    "--system",
+    is_flag=True,
+    help="On macOS, remove the LaunchDaemon instead of the LaunchAgent.",
+)
+def uninstall_service(system: bool):
+    """Stop and remove the installed service."""
+    if sys.platform == "darwin":
+        uninstall_launchd(system)
+    elif sys.platform == "win32":
+        uninstall_windows_task()
+    elif shutil.which("systemctl"):
+        uninstall_systemd()
+    else:
+        raise click.ClickException(
+            f"Don't know how to remove a service on this platform ({sys.platform})."
+        )
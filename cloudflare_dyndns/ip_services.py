@@ -1,10 +1,17 @@
 from cloudflare_dyndns.types import IPAddress
+import abc
+import datetime
 import os
 import ipaddress
-from typing import Callable, List
+import shlex
+import socket
+import struct
+import subprocess
+import sys
+from typing import Callable, Dict, List, Sequence
 import attr
 import certifi
-from . import printer
+from . import binding, breaker, dns_lookup, http_proxy, printer, stats, stun, upnp
 
 
 # Workaround for certifi resource location doesn't work with PyOxidizer.
@@ -16,6 +23,22 @@ certifi.where = lambda: os.environ.get(
 import requests
 
 
+# shared by every HTTP service, so in daemon mode the keep-alive connections are
+# reused between the checks instead of new TCP and TLS handshakes every time
+session = requests.Session()
+# connections made through another WAN link can't be shared
+_link_sessions: Dict[str, requests.Session] = {}
+
+
+def _current_session() -> requests.Session:
+    link = binding.current_link()
+    if link is None:
+        return session
+    elif link not in _link_sessions:
+        _link_sessions[link] = requests.Session()
+    return _link_sessions[link]
+
+
 class IPServiceError(Exception):
     """Raised when there is a problem during determining the IP Address
     through the IP Services.
@@ -25,6 +48,10 @@ class IPServiceError(Exception):
         super().__init__(msg)
 
 
+class IPSourceUnavailable(Exception):
+    """One source couldn't tell the IP address, the next one should be tried."""
+
+
 def parse_cloudflare_trace_ip(res: str) -> str:
     """Parses the IP address line from the cloudflare trace service response.
     Example response:
@@ -52,12 +79,46 @@ def strip_whitespace(res: str) -> str:
     return res.strip()
 
 
+class IPSource(abc.ABC):
+    """Something that can tell the current IP address of the machine."""
+
+    name = "IP source"
+
+    @property
+    def description(self) -> str:
+        return self.name
+
+    @abc.abstractmethod
+    def get_ip(self, version: int) -> str:
+        """Returns the IPv4 or IPv6 address as a string,
+        or raises IPSourceUnavailable.
+        """
+
+
 @attr.s(auto_attribs=True)
-class IPService:
+class IPService(IPSource):
+    """HTTP service responding with the IP address of the client."""
+
     name: str
     url: str
     response_parser: Callable = strip_whitespace
 
+    @property
+    def description(self) -> str:
+        return f"{self.name} ({self.url})"
+
+    def get_ip(self, version: int) -> str:
+        try:
+            res = _current_session().get(self.url, timeout=10)
+        except requests.exceptions.RequestException:
+            raise IPSourceUnavailable(f"Service {self.url} unreachable, skipping.")
+
+        if not res.ok:
+            raise IPSourceUnavailable(
+                f"Service returned error status: {res.status_code}, skipping."
+            )
+        return self.response_parser(res.text)
+
 
 IPV4_SERVICES = [
     IPService(
@@ -77,29 +138,243 @@ IPV6_SERVICES = [
 ]
 
 
-def _get_ip(ip_services: List[IPService], version: str) -> IPAddress:
-    for ip_service in ip_services:
-        printer.info(
-            f"Checking current IPv{version} address with service: {ip_service.name} ({ip_service.url})"
+class DNSSource(IPSource):
+    """Google's name servers answer this TXT query with the address of the client."""
+
+    name = "DNS"
+    query_name = "o-o.myaddr.l.google.com"
+    server = "ns1.google.com"
+
+    @property
+    def description(self) -> str:
+        return f"DNS TXT {self.query_name} @{self.server}"
+
+    def get_ip(self, version: int) -> str:
+        family = socket.AF_INET if version == 4 else socket.AF_INET6
+        try:
+            answers = dns_lookup.query(
+                self.query_name, dns_lookup.TYPE_TXT, self.server, family
+            )
+        except dns_lookup.DNSLookupError as e:
+            raise IPSourceUnavailable(f"{e}, skipping.")
+        if not answers:
+            raise IPSourceUnavailable("Empty DNS response, skipping.")
+        return answers[0]
+
+
+class STUNSource(IPSource):
+    name = "STUN"
+
+    def __init__(self, server: str = stun.DEFAULT_SERVER):
+        self.server = server
+
+    @property
+    def description(self) -> str:
+        return f"STUN server {self.server}"
+
+    def get_ip(self, version: int) -> str:
+        family = socket.AF_INET if version == 4 else socket.AF_INET6
+        try:
+            return stun.get_mapped_address(self.server, family)
+        except stun.STUNError as e:
+            raise IPSourceUnavailable(f"{e}, skipping.")
+
+
+class InterfaceSource(IPSource):
+    """Address of a local network interface, e.g. the PPPoE connection
+    on a router, which has the public IP address itself. Only works on Linux.
+    """
+
+    name = "interface"
+    SIOCGIFADDR = 0x8915
+    IFA_F_TEMPORARY = 0x01
+    IFA_F_DEPRECATED = 0x20
+
+    def __init__(self, interface: str):
+        self.interface = interface
+
+    @property
+    def description(self) -> str:
+        return f"interface {self.interface}"
+
+    def get_ip(self, version: int) -> str:
+        if not sys.platform.startswith("linux"):
+            raise IPSourceUnavailable("Interface addresses only work on Linux.")
+        try:
+            return self._get_ipv4() if version == 4 else self._get_ipv6()
+        except OSError as e:
+            raise IPSourceUnavailable(f"{self.interface}: {e}, skipping.")
+
+    def _get_ipv4(self) -> str:
+        import fcntl
+
+        with socket.socket(socket.AF_INET, socket.SOCK_DGRAM) as sock:
+            request = struct.pack("256s", self.interface[:15].encode())
+            response = fcntl.ioctl(sock.fileno(), self.SIOCGIFADDR, request)
+        return socket.inet_ntoa(response[20:24])
+
+    def _get_ipv6(self) -> str:
+        with open("/proc/net/if_inet6") as f:
+            for line in f:
+                address, _, _, scope, flags, name = line.split()
+                # scope 00 is global
+                if name != self.interface or scope != "00":
+                    continue
+                if int(flags, 16) & (self.IFA_F_TEMPORARY | self.IFA_F_DEPRECATED):
+                    continue
+                return str(ipaddress.IPv6Address(bytes.fromhex(address)))
+        raise IPSourceUnavailable(
+            f"No global IPv6 address on {self.interface}, skipping."
         )
+
+
+class ExecSource(IPSource):
+    """Runs a command, which prints the IP address."""
+
+    name = "exec"
+
+    def __init__(self, command: str):
+        self.command = command
+
+    @property
+    def description(self) -> str:
+        return f"command {self.command}"
+
+    def get_ip(self, version: int) -> str:
+        env = dict(os.environ, DYN_IP_VERSION=str(version))
         try:
-            res = requests.get(ip_service.url)
-        except requests.exceptions.RequestException:
-            printer.info(f"Service {ip_service.url} unreachable, skipping.")
-            continue
+            completed = subprocess.run(
+                shlex.split(self.command),
+                capture_output=True,
+                text=True,
+                env=env,
+                timeout=30,
+            )
+        except (OSError, subprocess.TimeoutExpired) as e:
+            raise IPSourceUnavailable(f"Command failed: {e}, skipping.")
+        if completed.returncode != 0:
+            raise IPSourceUnavailable(
+                f"Command exited with {completed.returncode}, skipping."
+            )
+        lines = completed.stdout.strip().splitlines()
+        return lines[0].strip() if lines else ""
 
-        if not res.ok:
-            printer.info(f"Service returned error status: {res.status_code}, skipping.")
+
+class RouterSource(IPSource):
+    """Asks the router through UPnP IGD, without any external service."""
+
+    name = "router (UPnP)"
+
+    def get_ip(self, version: int) -> str:
+        if version != 4:
+            raise IPSourceUnavailable("UPnP only knows the IPv4 address, skipping.")
+        try:
+            return upnp.get_external_ip()
+        except upnp.UPnPError as e:
+            raise IPSourceUnavailable(f"{e}, skipping.")
+
+
+def _http_sources(argument: str, version: int) -> List[IPSource]:
+    if not argument:
+        return list(IPV4_SERVICES if version == 4 else IPV6_SERVICES)
+    elif "/cdn-cgi/trace" in argument:
+        return [IPService("HTTP", argument, parse_cloudflare_trace_ip)]
+    return [IPService("HTTP", argument)]
+
+
+def _required(source_type: Callable[[str], IPSource], type_name: str):
+    def create(argument: str, version: int) -> List[IPSource]:
+        if not argument:
+            raise ValueError(f'The "{type_name}" IP source needs an argument')
+        return [source_type(argument)]
+
+    return create
+
+
+# Factories by type name, which get the part after the colon in "type:argument"
+SOURCE_TYPES: Dict[str, Callable[[str, int], List[IPSource]]] = {
+    "http": _http_sources,
+    "dns": lambda argument, version: [DNSSource()],
+    "stun": lambda argument, version: [STUNSource(argument or stun.DEFAULT_SERVER)],
+    "interface": _required(InterfaceSource, "interface"),
+    "exec": _required(ExecSource, "exec"),
+    "router": lambda argument, version: [RouterSource()],
+}
+
+
+def register_source_type(name: str, factory: Callable[[str, int], List[IPSource]]):
+    """Makes a new IP source type available for parse_sources."""
+    SOURCE_TYPES[name] = factory
+
+
+def parse_sources(specs: List[str], version: int) -> List[IPSource]:
+    """Creates the sources from specifications like "http", "stun:host:port",
+    "interface:ppp0" or "exec:/usr/local/bin/get-ip" in the given order.
+    """
+    sources = []
+    for spec in specs:
+        type_name, _, argument = spec.partition(":")
+        try:
+            factory = SOURCE_TYPES[type_name]
+        except KeyError:
+            choices = ", ".join(SOURCE_TYPES)
+            raise ValueError(f"Unknown IP source: {type_name}, choose from: {choices}")
+        sources.extend(factory(argument, version))
+    return sources
+
+
+def _breaker_name(ip_source: IPSource) -> str:
+    # a service can be down only through one of the WAN links
+    link = binding.current_link()
+    return ip_source.description if link is None else f"{ip_source.description}@{link}"
+
+
+def _source_failed(ip_source: IPSource):
+    if breaker.record_failure(_breaker_name(ip_source)):
+        printer.warning(
+            f"{ip_source.description} keeps failing, skipping it for "
+            f"{breaker.COOLDOWN} seconds."
+        )
+
+
+def _get_ip(ip_sources: List[IPSource], version: str) -> IPAddress:
+    skipped = {}
+    for index, ip_source in enumerate(ip_sources):
+        until = breaker.open_until(_breaker_name(ip_source))
+        if until is not None:
+            skipped[index] = until
+    # when every source is failing, they are all tried anyway
+    if len(skipped) == len(ip_sources):
+        skipped = {}
+
+    for index, ip_source in enumerate(ip_sources):
+        if index in skipped:
+            until = datetime.datetime.fromtimestamp(skipped[index])
+            printer.info(
+                f"Skipping {ip_source.description}, it keeps failing "
+                f"(until {until:%H:%M})."
+            )
+            continue
+        printer.info(
+            f"Checking current IPv{version} address with: {ip_source.description}"
+        )
+        try:
+            with stats.timed("ip_services", ip_source.name), http_proxy.detection():
+                ip_str = ip_source.get_ip(int(version))
+        except IPSourceUnavailable as e:
+            printer.info(str(e))
+            _source_failed(ip_source)
             continue
 
-        ip_str = ip_service.response_parser(res.text)
         try:
             ip = ipaddress.ip_address(ip_str)
-        except ipaddress.AddressValueError:
+        except ValueError:
             printer.warning(f"Service returned invalid IP Address: {ip_str}, skipping.")
+            _source_failed(ip_source)
             continue
 
-        printer.info(f"Current IP address: {ip}")
+        breaker.record_success(_breaker_name(ip_source))
+        printer.info(f"Current IP address: {ip}", ip=ip)
         return ip
 
     else:
@@ -108,7 +383,7 @@ def _get_ip(ip_services: List[IPService], version: str) -> IPAddress:
         )
 
 
-def get_ipv4(services: List[IPService] = IPV4_SERVICES) -> ipaddress.IPv4Address:
+def get_ipv4(services: List[IPSource] = IPV4_SERVICES) -> ipaddress.IPv4Address:
     ipv4 = _get_ip(services, "4")
 
     if ipv4.version != 4:
@@ -120,7 +395,7 @@ def get_ipv4(services: List[IPService] = IPV4_SERVICES) -> ipaddress.IPv4Address
     return ipv4
 
 
-def get_ipv6(services: List[IPService] = IPV6_SERVICES) -> ipaddress.IPv6Address:
+def get_ipv6(services: List[IPSource] = IPV6_SERVICES) -> ipaddress.IPv6Address:
     ipv6 = _get_ip(services, "6")
 
     if ipv6.version != 6:
@@ -130,3 +405,38 @@ def get_ipv6(services: List[IPService] = IPV6_SERVICES) -> ipaddress.IPv6Address
         )
 
     return ipv6
+
+
+def get_wan_ipv4s(links: Sequence[List[IPSource]]) -> List[ipaddress.IPv4Address]:
+    """The addresses of multiple WAN links, each detected with its own sources.
+    Links which are down are left out.
+    """
+    addresses = []
+    for sources in links:
+        try:
+            address = get_ipv4(sources)
+        except IPServiceError as e:
+            printer.warning(f"{e} Leaving out this WAN link.")
+            continue
+        if address not in addresses:
+            addresses.append(address)
+    return sorted(addresses)
+
+
+# public DNS servers, only used for looking up the route to the internet
+CONNECTIVITY_CHECK_ADDRESSES = {4: "1.1.1.1", 6: "2606:4700:4700::1111"}
+
+
+def has_connectivity(version: int) -> bool:
+    """Whether the host has a route to the internet with the IP version.
+    Connecting a UDP socket doesn't send anything, it only fails without a route,
+    e.g. on IPv6-only hosts for IPv4.
+    """
+    family = socket.AF_INET if version == 4 else socket.AF_INET6
+    try:
+        with socket.socket(family, socket.SOCK_DGRAM) as sock:
+            binding.bind(sock)
+            sock.connect((CONNECTIVITY_CHECK_ADDRESSES[version], 53))
+    except OSError:
+        return False
+    return True
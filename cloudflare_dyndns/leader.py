@@ -0,0 +1,82 @@
+import datetime
+import time
+from typing import Optional, Tuple
+from .cloudflare import CloudFlareWrapper
+from . import printer
+
+
+# Cloudflare has no compare-and-swap for records, so after writing the lease,
+# we wait a bit and read it back to see if another instance overwrote it.
+SETTLE_TIME = 2
+
+
+def parse_lease(content: str) -> Tuple[Optional[str], float]:
+    """Parses "holder=NODE expires=TIMESTAMP", invalid content means no lease."""
+    fields = dict(
+        part.split("=", 1) for part in content.strip('"').split() if "=" in part
+    )
+    try:
+        return fields.get("holder"), float(fields.get("expires", 0))
+    except ValueError:
+        return None, 0
+
+
+def format_lease(holder: str, expires: float) -> str:
+    return f"holder={holder} expires={int(expires)}"
+
+
+class LeaseLock:
+    """Leader election between multiple instances through a lease kept in a
+    TXT record, so only the leader updates the records. When the leader stops
+    renewing the lease, another instance takes over after it expires.
+    """
+
+    def __init__(self, record_name: str, node_id: str, ttl: int):
+        self.record_name = record_name
+        self.node_id = node_id
+        self.ttl = ttl
+        self.is_leader = False
+
+    def acquire(self, cf: CloudFlareWrapper) -> bool:
+        """Takes or renews the lease, returns True if we are the leader."""
+        now = time.time()
+        record = cf.get_txt_record(self.record_name)
+        record_id, content = record if record else (None, "")
+        holder, expires = parse_lease(content)
+        if holder not in (None, self.node_id) and expires > now:
+            self._follow(holder, expires)
+            return False
+
+        lease = format_lease(self.node_id, now + self.ttl)
+        cf.set_txt_record(self.record_name, lease, record_id)
+        time.sleep(SETTLE_TIME)
+        record = cf.get_txt_record(self.record_name)
+        holder, expires = parse_lease(record[1] if record else "")
+        if holder != self.node_id:
+            self._follow(holder, expires)
+            return False
+
+        if not self.is_leader:
+            printer.success(f'"{self.node_id}" is now the leader.', leader=self.node_id)
+        self.is_leader = True
+        return True
+
+    def release(self, cf: CloudFlareWrapper):
+        """Lets another instance take over immediately."""
+        if not self.is_leader:
+            return
+        record = cf.get_txt_record(self.record_name)
+        if record and parse_lease(record[1])[0] == self.node_id:
+            expired_lease = format_lease(self.node_id, 0)
+            cf.set_txt_record(self.record_name, expired_lease, record[0])
+            printer.info("Leadership released.")
+        self.is_leader = False
+
+    def _follow(self, holder: Optional[str], expires: float):
+        if self.is_leader:
+            printer.warning(f'Lost leadership to "{holder}".', leader=holder)
+        self.is_leader = False
+        until = datetime.datetime.fromtimestamp(expires).strftime("%H:%M:%S")
+        printer.info(
+            f'"{holder}" is the leader until {until}, skipping update.', leader=holder
+        )
@@ -0,0 +1,65 @@
+"""Maintenance mode: the records point to a static address, e.g. of a maintenance
+page, instead of the detected one until it's turned off. The addresses are kept
+in a file next to the cache, so every run, the daemon and the ones from cron
+alike, keeps them, even with --force.
+"""
+import ipaddress
+import json
+from pathlib import Path
+from typing import Dict, List, Union
+from .types import IPAddress, RecordType, get_record_type
+
+
+class InvalidMaintenanceFile(Exception):
+    """The maintenance file can't be read."""
+
+
+def maintenance_file(cache_file: Union[str, Path]) -> Path:
+    cache_file = Path(cache_file).expanduser()
+    return cache_file.with_name(cache_file.name + ".maintenance")
+
+
+def read_maintenance(path: Path) -> Dict[RecordType, IPAddress]:
+    """The static address of each record type, empty when it's turned off."""
+    try:
+        data = json.loads(path.read_text())
+        addresses = {
+            record_type: ipaddress.ip_address(address)
+            for record_type, address in data.items()
+        }
+    except FileNotFoundError:
+        return {}
+    except (ValueError, AttributeError) as e:
+        raise InvalidMaintenanceFile(f"Invalid maintenance file {path}: {e}")
+    for record_type, address in addresses.items():
+        if get_record_type(address) != record_type:
+            raise InvalidMaintenanceFile(
+                f"Invalid maintenance file {path}: {address} is not for {record_type}"
+            )
+    return addresses
+
+
+def write_maintenance(path: Path, addresses: Dict[RecordType, IPAddress]):
+    if not addresses:
+        path.unlink(missing_ok=True)
+        return
+    path.parent.mkdir(exist_ok=True, parents=True)
+    data = {record_type: str(address) for record_type, address in addresses.items()}
+    # the updater must never see a half written file
+    tmp_path = path.with_name(path.name + ".tmp")
+    tmp_path.write_text(json.dumps(data, indent=2))
+    tmp_path.replace(path)
+
+
+def parse_maintenance_ips(values: List[str]) -> Dict[RecordType, IPAddress]:
+    addresses = {}
+    for value in values:
+        try:
+            address = ipaddress.ip_address(value)
+        except ValueError:
+            raise ValueError(f'"{value}" is not an IP address.')
+        record_type = get_record_type(address)
+        if record_type in addresses:
+            raise ValueError(f"Only one IPv{address.version} address is allowed.")
+        addresses[record_type] = address
+    return addresses
@@ -0,0 +1,62 @@
+import socket
+from typing import Dict, Optional
+from .hostport import split_host_port
+from . import printer
+
+
+STATSD_PORT = 8125
+
+
+class StatsdClient:
+    """Sends metrics over UDP in the DogStatsD format, which is plain StatsD
+    with optional tags, so it works with both.
+    """
+
+    def __init__(self, address: str, prefix: str = "cloudflare_dyndns"):
+        host, port = split_host_port(address, STATSD_PORT)
+        family, *_, sockaddr = socket.getaddrinfo(host, port, type=socket.SOCK_DGRAM)[0]
+        self._address = sockaddr
+        self._socket = socket.socket(family, socket.SOCK_DGRAM)
+        self._prefix = prefix.rstrip(".")
+
+    def _send(self, name: str, value, metric_type: str, tags: Dict[str, str]):
+        line = f"{self._prefix}.{name}:{value}|{metric_type}"
+        if tags:
+            line += "|#" + ",".join(f"{key}:{value}" for key, value in tags.items())
+        try:
+            self._socket.sendto(line.encode(), self._address)
+        except OSError as e:
+            # metrics should never break the update
+            printer.warning(f"Failed to send metric {name}: {e}")
+
+    def incr(self, name: str, value: int = 1, **tags):
+        self._send(name, value, "c", tags)
+
+    def timing(self, name: str, milliseconds: float, **tags):
+        self._send(name, round(milliseconds, 3), "ms", tags)
+
+    def gauge(self, name: str, value: float, **tags):
+        self._send(name, value, "g", tags)
+
+
+_client: Optional[StatsdClient] = None
+
+
+def configure(address: Optional[str], prefix: str):
+    global _client
+    _client = StatsdClient(address, prefix) if address else None
+
+
+def incr(name: str, value: int = 1, **tags):
+    if _client is not None:
+        _client.incr(name, value, **tags)
+
+
+def timing(name: str, milliseconds: float, **tags):
+    if _client is not None:
+        _client.timing(name, milliseconds, **tags)
+
+
+def gauge(name: str, value: float, **tags):
+    if _client is not None:
+        _client.gauge(name, value, **tags)
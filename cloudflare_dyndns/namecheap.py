@@ -0,0 +1,98 @@
+import os
+import xml.etree.ElementTree as ET
+from typing import Optional, Tuple
+import requests
+from .cache import ZoneRecord
+from .providers import DNSProvider, DNSProviderError
+from .types import IPAddress, RecordType, get_record_type
+from . import printer, stats
+
+
+UPDATE_URL = "https://dynamicdns.park-your-domain.com/update"
+
+
+class NamecheapError(DNSProviderError):
+    """Namecheap refused the dynamic DNS update."""
+
+
+class NamecheapProvider(DNSProvider):
+    """Updates A records with the Namecheap Dynamic DNS protocol. The Dynamic
+    DNS password of the domain is read from the NAMECHEAP_DDNS_PASSWORD
+    environment variable if not given. The protocol only supports IPv4.
+    """
+
+    name = "Namecheap"
+
+    def __init__(self, password: Optional[str] = None):
+        password = password or os.environ.get("NAMECHEAP_DDNS_PASSWORD")
+        if not password:
+            raise NamecheapError(
+                "Namecheap Dynamic DNS password is missing, set the "
+                "NAMECHEAP_DDNS_PASSWORD environment variable."
+            )
+        printer.register_secret(password)
+        self._password = password
+        self._session = requests.Session()
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        if record_type != "A":
+            raise NamecheapError("Namecheap Dynamic DNS only supports A records")
+        printer.info(
+            f'Updating "{domain}" A record at Namecheap.',
+            domain=domain,
+            record_type=record_type,
+        )
+        host, registered_domain = split_host(domain)
+        params = {
+            "host": host,
+            "domain": registered_domain,
+            "password": self._password,
+            "ip": str(ip),
+        }
+        try:
+            with stats.timed("namecheap", "GET update"):
+                response = self._session.get(UPDATE_URL, params=params, timeout=30)
+        except requests.RequestException as e:
+            raise NamecheapError(f"Namecheap request failed: {e}")
+        check_response(response.content)
+        return ZoneRecord(zone_id=registered_domain, record_id=host, proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        printer.warning(
+            f'Namecheap Dynamic DNS can\'t delete records, keeping "{domain}".',
+            domain=domain,
+            record_type=record_type,
+        )
+
+    def verify_credentials(self):
+        # the password can only be checked by updating a record
+        pass
+
+
+def split_host(domain: str) -> Tuple[str, str]:
+    """Splits "home.example.com" into the "home" host and the "example.com"
+    domain, "@" is the host of the domain itself.
+    """
+    labels = domain.split(".")
+    registered_domain = ".".join(labels[-2:])
+    host = ".".join(labels[:-2]) or "@"
+    return host, registered_domain
+
+
+def check_response(content: bytes):
+    try:
+        root = ET.fromstring(content)
+    except ET.ParseError:
+        raise NamecheapError("Invalid Namecheap response")
+    if root.findtext("ErrCount", "0") != "0":
+        errors = root.find("errors")
+        messages = [error.text for error in errors] if errors is not None else []
+        raise NamecheapError("Namecheap error: " + "; ".join(filter(None, messages)))
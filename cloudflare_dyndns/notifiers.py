@@ -0,0 +1,315 @@
+import hashlib
+import hmac
+import json
+import os
+import shutil
+import string
+import subprocess
+import sys
+import time
+import uuid
+from pathlib import Path
+from typing import List, Optional
+from urllib.parse import quote
+import requests
+from .report import Report
+from . import printer
+
+
+class Notifier:
+    """Base class for sending a report about the run somewhere."""
+
+    name = "notifier"
+
+    def should_notify(self, report: Report) -> bool:
+        return (
+            report.changed
+            or report.failed
+            or bool(report.available_update)
+            or report.token_expires_on is not None
+            or report.token_rotated
+        )
+
+    def notify(self, report: Report):
+        raise NotImplementedError
+
+
+def report_variables(report: Report) -> dict:
+    """Variables available in notification templates."""
+    ipv4 = report.get_result("A")
+    ipv6 = report.get_result("AAAA")
+    updated_domains = [d for r in report.results for d in r.updated_domains]
+    failed_domains = [d for r in report.results for d in r.failed_domains]
+    errors = [e for r in report.results for e in r.errors]
+    return {
+        "status": report.status,
+        "message": report.summary(),
+        "old_ipv4": str(ipv4.old_ip or "") if ipv4 else "",
+        "new_ipv4": str(ipv4.new_ip or "") if ipv4 else "",
+        "new_ipv4_location": ipv4.location if ipv4 else "",
+        "old_ipv6": str(ipv6.old_ip or "") if ipv6 else "",
+        "new_ipv6": str(ipv6.new_ip or "") if ipv6 else "",
+        "new_ipv6_location": ipv6.location if ipv6 else "",
+        "updated_domains": " ".join(updated_domains),
+        "failed_domains": " ".join(failed_domains),
+        "errors": "\n".join(errors),
+        "available_update": report.available_update or "",
+    }
+
+
+class WebhookNotifier(Notifier):
+    """POSTs a JSON payload to the configured URLs.
+
+    The payload can be customized with a template file, in which $variables
+    (see report_variables) are substituted with JSON escaped values.
+    When a secret is given, the body is signed with HMAC-SHA256 and sent in the
+    X-Signature-256 header as "sha256=<hexdigest>".
+    """
+
+    name = "webhook"
+
+    def __init__(
+        self,
+        urls: List[str],
+        template_path: Optional[Path] = None,
+        secret: Optional[str] = None,
+        retries: int = 3,
+        timeout: float = 10,
+    ):
+        self._urls = urls
+        self._template = (
+            string.Template(template_path.read_text()) if template_path else None
+        )
+        self._secret = secret
+        self._retries = retries
+        self._timeout = timeout
+
+    def render(self, report: Report) -> str:
+        if self._template is None:
+            return json.dumps(
+                {
+                    "status": report.status,
+                    "message": report.summary(),
+                    "results": json.loads(report.json())["results"],
+                }
+            )
+        variables = {
+            # strip the quotes, so values can be placed inside JSON strings
+            key: json.dumps(value)[1:-1]
+            for key, value in report_variables(report).items()
+        }
+        return self._template.safe_substitute(variables)
+
+    def sign(self, body: bytes) -> str:
+        digest = hmac.new(self._secret.encode(), body, hashlib.sha256).hexdigest()
+        return f"sha256={digest}"
+
+    def notify(self, report: Report):
+        body = self.render(report).encode()
+        headers = {"Content-Type": "application/json"}
+        if self._secret:
+            headers["X-Signature-256"] = self.sign(body)
+
+        for url in self._urls:
+            self._post(url, body, headers)
+
+    def _post(self, url: str, body: bytes, headers: dict):
+        for attempt in range(self._retries + 1):
+            if attempt:
+                time.sleep(2 ** (attempt - 1))
+                printer.info(f"Retrying webhook {url} (attempt {attempt + 1})")
+            try:
+                res = requests.post(
+                    url, data=body, headers=headers, timeout=self._timeout
+                )
+            except requests.exceptions.RequestException as e:
+                printer.warning(f"Webhook {url} unreachable: {e}")
+                continue
+
+            if res.ok:
+                printer.info(f"Webhook {url} notified.")
+                return
+            printer.warning(f"Webhook {url} returned error status: {res.status_code}")
+            # client errors will not get better by retrying
+            if res.status_code < 500 and res.status_code != 429:
+                break
+
+        printer.error(f"Failed to notify webhook {url}")
+
+
+class MatrixNotifier(Notifier):
+    """Sends a text message to a Matrix room through the client-server API."""
+
+    name = "Matrix"
+
+    def __init__(self, homeserver: str, access_token: str, room_id: str):
+        self._homeserver = homeserver.rstrip("/")
+        self._access_token = access_token
+        self._room_id = room_id
+
+    def notify(self, report: Report):
+        room = quote(self._room_id, safe="")
+        transaction_id = uuid.uuid4().hex
+        url = (
+            f"{self._homeserver}/_matrix/client/v3/rooms/{room}"
+            f"/send/m.room.message/{transaction_id}"
+        )
+        payload = {
+            "msgtype": "m.text",
+            "body": f"cloudflare-dyndns {report.status}: {report.summary()}",
+        }
+        headers = {"Authorization": f"Bearer {self._access_token}"}
+        res = requests.put(url, json=payload, headers=headers, timeout=10)
+        if not res.ok:
+            printer.error(
+                f"Failed to send Matrix message, status: {res.status_code} {res.text}"
+            )
+            return
+        printer.info(f"Matrix message sent to room {self._room_id}")
+
+
+class DesktopNotifier(Notifier):
+    """Shows a native desktop notification with notify-send on Linux,
+    osascript on macOS and a PowerShell toast on Windows.
+    """
+
+    name = "desktop"
+
+    TITLE = "Cloudflare DynDNS"
+
+    def message(self, report: Report) -> str:
+        if report.failed:
+            return report.summary()
+        parts = []
+        for result in report.results:
+            if result.changed:
+                count = len(result.updated_domains)
+                records = "record" if count == 1 else "records"
+                location = f" ({result.location})" if result.location else ""
+                parts.append(
+                    f"Public IP changed to {result.new_ip}{location}, "
+                    f"{count} {records} updated"
+                )
+        return "\n".join(parts)
+
+    def command(self, message: str, critical: bool) -> List[str]:
+        if sys.platform == "darwin":
+            script = (
+                f"display notification {json.dumps(message)} "
+                f"with title {json.dumps(self.TITLE)}"
+            )
+            return ["osascript", "-e", script]
+        elif sys.platform == "win32":
+            script = (
+                "[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType=WindowsRuntime] > $null;"
+                "$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent("
+                "[Windows.UI.Notifications.ToastTemplateType]::ToastText02);"
+                "$texts = $xml.GetElementsByTagName('text');"
+                "$texts.Item(0).AppendChild($xml.CreateTextNode($env:DYN_TITLE)) > $null;"
+                "$texts.Item(1).AppendChild($xml.CreateTextNode($env:DYN_MESSAGE)) > $null;"
+                "$toast = [Windows.UI.Notifications.ToastNotification]::new($xml);"
+                "[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($env:DYN_TITLE).Show($toast)"
+            )
+            return ["powershell", "-NoProfile", "-NonInteractive", "-Command", script]
+        else:
+            urgency = "critical" if critical else "normal"
+            return ["notify-send", "--urgency", urgency, self.TITLE, message]
+
+    def notify(self, report: Report):
+        message = self.message(report)
+        command = self.command(message, critical=report.failed)
+        if shutil.which(command[0]) is None:
+            printer.warning(f"Can't send desktop notification, {command[0]} not found")
+            return
+        # on Windows, the message is passed in the environment to avoid quoting issues
+        env = {**os.environ, "DYN_TITLE": self.TITLE, "DYN_MESSAGE": message}
+        subprocess.run(command, env=env, check=False)
+
+
+class PushMonitorNotifier(Notifier):
+    """Reports every run to a push (heartbeat) monitor, so it can alert
+    when the updater stops running or starts failing.
+    """
+
+    name = "push monitor"
+
+    # status parameter name, success value, failure value, message parameter name
+    STYLES = {
+        "uptime-kuma": ("status", "up", "down", "msg"),
+        "cronitor": ("state", "complete", "fail", "message"),
+    }
+
+    def __init__(self, url: str, style: str = "uptime-kuma"):
+        self._url = url
+        self._style = style
+
+    def should_notify(self, report: Report) -> bool:
+        # the monitor needs a heartbeat even when nothing changed
+        return True
+
+    def params(self, report: Report) -> dict:
+        status_param, up, down, message_param = self.STYLES[self._style]
+        return {
+            status_param: down if report.failed else up,
+            message_param: report.summary(),
+        }
+
+    def notify(self, report: Report):
+        try:
+            res = requests.get(self._url, params=self.params(report), timeout=10)
+        except requests.exceptions.RequestException as e:
+            printer.warning(f"Push monitor unreachable: {e}")
+            return
+        if not res.ok:
+            printer.warning(f"Push monitor returned error status: {res.status_code}")
+
+
+class CommandHook(Notifier):
+    """Runs a shell command with the details of the run in environment variables."""
+
+    name = "command"
+
+    def __init__(self, command: str, on_change: bool = False, on_error: bool = False):
+        self._command = command
+        self._on_change = on_change
+        self._on_error = on_error
+
+    def should_notify(self, report: Report) -> bool:
+        return (self._on_change and report.changed) or (
+            self._on_error and report.failed
+        )
+
+    def environment(self, report: Report) -> dict:
+        variables = report_variables(report)
+        return {
+            "DYN_STATUS": variables["status"],
+            "DYN_MESSAGE": variables["message"],
+            "DYN_IPV4": variables["new_ipv4"],
+            "DYN_IPV4_LOCATION": variables["new_ipv4_location"],
+            "DYN_OLD_IPV4": variables["old_ipv4"],
+            "DYN_IPV6": variables["new_ipv6"],
+            "DYN_IPV6_LOCATION": variables["new_ipv6_location"],
+            "DYN_OLD_IPV6": variables["old_ipv6"],
+            "DYN_DOMAINS": variables["updated_domains"],
+            "DYN_FAILED_DOMAINS": variables["failed_domains"],
+            "DYN_ERRORS": variables["errors"],
+        }
+
+    def notify(self, report: Report):
+        printer.info(f"Running command: {self._command}")
+        env = {**os.environ, **self.environment(report)}
+        completed = subprocess.run(self._command, shell=True, env=env)
+        if completed.returncode != 0:
+            printer.warning(
+                f"Command exited with status {completed.returncode}: {self._command}"
+            )
+
+
+def send_notifications(notifiers: List[Notifier], report: Report):
+    for notifier in notifiers:
+        if not notifier.should_notify(report):
+            continue
+        try:
+            notifier.notify(report)
+        except Exception as e:
+            printer.error(f"Failed to send {notifier.name} notification: {e}")
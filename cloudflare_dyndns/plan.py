@@ -0,0 +1,92 @@
+"""The changes an update would make, for reviewing them before applying, e.g. in
+a deployment pipeline. Nothing is written, the records are only looked up.
+"""
+import ipaddress
+from typing import List, Optional, Sequence
+import attr
+from .providers import DNSProvider, NotSupportedError
+from .types import RecordType
+
+
+ACTION_SYMBOLS = {"create": "+", "update": "~", "delete": "-"}
+UNKNOWN = "(unknown)"
+
+
+@attr.s(auto_attribs=True, frozen=True)
+class Change:
+    action: str
+    record_type: RecordType
+    domain: str
+    old: Optional[str] = None
+    new: Optional[str] = None
+    old_proxied: Optional[bool] = None
+    new_proxied: Optional[bool] = None
+
+
+def _normalize(content: str) -> str:
+    # the same IPv6 address can be written in many ways
+    try:
+        return str(ipaddress.ip_address(content))
+    except ValueError:
+        return content
+
+
+def plan_record(
+    provider: DNSProvider,
+    domain: str,
+    record_type: RecordType,
+    addresses: Sequence[str],
+    proxied: bool,
+) -> Optional[Change]:
+    """The change making the records of the domain point to the addresses,
+    or deleting them when there are none. None when they are up-to-date.
+    """
+    try:
+        records = provider.current_records(domain, record_type)
+    except NotSupportedError:
+        # providers which can't look up the records are always written
+        if not addresses:
+            return Change("delete", record_type, domain, UNKNOWN)
+        return Change("update", record_type, domain, UNKNOWN, ", ".join(addresses))
+
+    old = ", ".join(sorted(_normalize(record.content) for record in records)) or None
+    new = ", ".join(sorted(_normalize(address) for address in addresses)) or None
+    # only providers with a proxy tell whether the records are proxied
+    old_proxied = records[0].proxied if records else None
+    new_proxied = proxied if old_proxied is not None else None
+    if old is None and new is None:
+        return None
+    elif old is None:
+        return Change("create", record_type, domain, new=new)
+    elif new is None:
+        return Change("delete", record_type, domain, old=old)
+    elif old == new and old_proxied == new_proxied:
+        return None
+    return Change("update", record_type, domain, old, new, old_proxied, new_proxied)
+
+
+def format_change(change: Change) -> str:
+    symbol = ACTION_SYMBOLS[change.action]
+    line = f"{symbol} {change.action:<6}  {change.record_type:<4}  {change.domain}  "
+    if change.action == "create":
+        line += change.new
+    elif change.action == "delete":
+        line += change.old
+    else:
+        line += f"{change.old} -> {change.new}"
+    if change.old_proxied != change.new_proxied:
+        line += f"  proxied: {change.old_proxied} -> {change.new_proxied}".lower()
+    return line
+
+
+def format_plan(changes: Sequence[Change]) -> List[str]:
+    """One line per change in a stable order, then the totals."""
+    ordered = sorted(changes, key=lambda c: (c.domain, c.record_type))
+    lines = [format_change(change) for change in ordered]
+    counts = {
+        action: sum(change.action == action for change in changes)
+        for action in ACTION_SYMBOLS
+    }
+    totals = ", ".join(f"{count} to {action}" for action, count in counts.items())
+    lines.append(f"Plan: {totals}." if changes else "No changes.")
+    return lines
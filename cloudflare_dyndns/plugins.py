@@ -0,0 +1,44 @@
+"""Third party packages can ship DNS providers and IP sources as entry points:
+
+    [tool.poetry.plugins."cloudflare_dyndns.providers"]
+    myprovider = "my_package:MyProvider"
+
+    [tool.poetry.plugins."cloudflare_dyndns.ip_sources"]
+    mysource = "my_package:make_sources"
+
+Providers are called without arguments and return a DNSProvider, IP source
+factories get the argument after the colon and the IP version and return a
+list of IPSources, like the built-in ones.
+"""
+from importlib import metadata
+from .ip_services import register_source_type
+from .registry import register_provider_type
+from . import printer
+
+
+PROVIDERS_GROUP = "cloudflare_dyndns.providers"
+IP_SOURCES_GROUP = "cloudflare_dyndns.ip_sources"
+
+
+def _entry_points(group: str):
+    entry_points = metadata.entry_points()
+    # the select() API is only available from Python 3.10
+    if hasattr(entry_points, "select"):
+        return entry_points.select(group=group)
+    return entry_points.get(group, [])
+
+
+def load_plugins():
+    """Registers every installed plugin. A broken plugin is skipped with
+    a warning, so it can't make the whole program unusable.
+    """
+    groups = [
+        (PROVIDERS_GROUP, register_provider_type),
+        (IP_SOURCES_GROUP, register_source_type),
+    ]
+    for group, register in groups:
+        for entry_point in _entry_points(group):
+            try:
+                register(entry_point.name, entry_point.load())
+            except Exception as e:
+                printer.warning(f'Failed to load plugin "{entry_point.name}": {e}')
@@ -0,0 +1,137 @@
+import os
+from typing import Optional
+import requests
+from .cache import ZoneRecord
+from .providers import DNSProvider, DNSProviderError
+from .types import IPAddress, RecordType, get_record_type
+from . import printer, stats
+
+
+API_URL = "https://api.porkbun.com/api/json/v3"
+# the minimum TTL allowed by Porkbun
+MIN_TTL = 600
+
+
+class PorkbunError(DNSProviderError):
+    """The Porkbun API returned an error."""
+
+
+class PorkbunProvider(DNSProvider):
+    """Updates records of domains registered at Porkbun. The keys are read from
+    the PORKBUN_API_KEY and PORKBUN_SECRET_API_KEY environment variables if not
+    given. API access has to be enabled for every domain on the Porkbun site.
+    """
+
+    name = "Porkbun"
+
+    def __init__(
+        self,
+        api_key: Optional[str] = None,
+        secret_api_key: Optional[str] = None,
+        ttl: int = MIN_TTL,
+    ):
+        api_key = api_key or os.environ.get("PORKBUN_API_KEY")
+        secret_api_key = secret_api_key or os.environ.get("PORKBUN_SECRET_API_KEY")
+        if not api_key or not secret_api_key:
+            raise PorkbunError(
+                "Porkbun API keys are missing, set the PORKBUN_API_KEY and "
+                "PORKBUN_SECRET_API_KEY environment variables."
+            )
+        printer.register_secret(api_key)
+        printer.register_secret(secret_api_key)
+        self._credentials = {"apikey": api_key, "secretapikey": secret_api_key}
+        self._ttl = max(ttl, MIN_TTL)
+        self._zones = {}
+        self._session = requests.Session()
+
+    def _request(self, path: str, **params) -> dict:
+        # every endpoint is a POST with the keys in the body
+        operation = "/".join(path.split("/")[:3])
+        try:
+            with stats.timed("porkbun", f"POST {operation}"):
+                response = self._session.post(
+                    API_URL + path, json={**self._credentials, **params}, timeout=30
+                )
+        except requests.RequestException as e:
+            raise PorkbunError(f"Porkbun API request failed: {e}")
+        try:
+            data = response.json()
+        except ValueError:
+            raise PorkbunError(f"Invalid Porkbun API response: {response.status_code}")
+        if data.get("status") != "SUCCESS":
+            message = data.get("message", response.reason)
+            raise PorkbunError(f"Porkbun API error: {message}")
+        return data
+
+    def get_zone(self, domain: str) -> str:
+        """The longest parent domain managed by Porkbun."""
+        if domain in self._zones:
+            return self._zones[domain]
+        labels = domain.rstrip(".").split(".")
+        for index in range(len(labels) - 1):
+            candidate = ".".join(labels[index:])
+            try:
+                self._request(f"/dns/retrieve/{candidate}")
+            except PorkbunError:
+                continue
+            self._zones[domain] = candidate
+            return candidate
+        printer.error(f'Cannot find domain "{domain}" at Porkbun')
+        raise PorkbunError(f"No domain for {domain}")
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record at Porkbun.',
+            domain=domain,
+            record_type=record_type,
+        )
+        zone = self.get_zone(domain)
+        subdomain = _subdomain(zone, domain)
+        name_type = f"{zone}/{record_type}/{subdomain}"
+        # records are edited by name and type, so the cached record is not used
+        records = self._request(f"/dns/retrieveByNameType/{name_type}")["records"]
+        if records:
+            self._request(
+                f"/dns/editByNameType/{name_type}", content=str(ip), ttl=str(self._ttl)
+            )
+            record_id = records[0]["id"]
+        else:
+            data = self._request(
+                f"/dns/create/{zone}",
+                name=subdomain,
+                type=record_type,
+                content=str(ip),
+                ttl=str(self._ttl),
+            )
+            printer.success(f"Created new record: {data['id']}")
+            record_id = data["id"]
+        return ZoneRecord(zone_id=zone, record_id=str(record_id), proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        printer.warning(
+            f'Deleting {record_type} record for "{domain}" at Porkbun.',
+            domain=domain,
+            record_type=record_type,
+        )
+        zone = self.get_zone(domain)
+        subdomain = _subdomain(zone, domain)
+        self._request(f"/dns/deleteByNameType/{zone}/{record_type}/{subdomain}")
+
+    def verify_credentials(self):
+        self._request("/ping")
+
+    def check_zone(self, domain: str):
+        self.get_zone(domain)
+
+
+def _subdomain(zone: str, domain: str) -> str:
+    """The part before the domain, empty for the domain itself."""
+    return domain[: -len(zone) - 1] if domain != zone else ""
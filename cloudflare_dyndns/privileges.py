@@ -0,0 +1,39 @@
+import os
+from typing import Optional
+from . import printer
+
+
+class PrivilegeError(Exception):
+    """Switching to another user is not possible."""
+
+
+def drop_privileges(user: str, group: Optional[str] = None):
+    """Switches to an unprivileged user (and group) for good, so a compromise
+    after the initialization can't do as much damage as root.
+    """
+    # not available on Windows
+    import grp
+    import pwd
+
+    if os.getuid() != 0:
+        raise PrivilegeError("Only root can switch to another user.")
+    try:
+        passwd = pwd.getpwuid(int(user)) if user.isdigit() else pwd.getpwnam(user)
+    except KeyError:
+        raise PrivilegeError(f"Unknown user: {user}")
+    gid = passwd.pw_gid
+    if group is not None:
+        try:
+            gid = int(group) if group.isdigit() else grp.getgrnam(group).gr_gid
+        except KeyError:
+            raise PrivilegeError(f"Unknown group: {group}")
+
+    # the order is important, we can't change groups without root, and the user
+    # keeps its supplementary groups, e.g. to read a shared config file
+    os.initgroups(passwd.pw_name, gid)
+    os.setgid(gid)
+    os.setuid(passwd.pw_uid)
+    if os.getuid() == 0 or os.geteuid() == 0:
+        raise PrivilegeError("Failed to drop root privileges.")
+    os.environ["HOME"] = passwd.pw_dir
+    printer.info(f"Switched to user {passwd.pw_name} (uid={passwd.pw_uid}, gid={gid}).")
@@ -0,0 +1,106 @@
+"""Progress of updates with many domains, e.g. from --match, and a table of what
+happened with each of them at the end, so the outcome of a large run doesn't
+have to be pieced together from the messages of the single records.
+"""
+from typing import Dict, List, Optional, Sequence
+from .report import Report, UpdateResult
+from .types import RecordType
+from . import printer
+
+
+_enabled = False
+
+
+def enable(enabled: bool = True):
+    global _enabled
+    _enabled = enabled
+
+
+def is_enabled() -> bool:
+    return _enabled
+
+
+class Progress:
+    """Counts the domains done, a domain failing first and then succeeding at
+    the retry is counted once, as successful.
+    """
+
+    def __init__(self, total: int, record_type: RecordType):
+        self.total = total
+        self.record_type = record_type
+        self._succeeded: Dict[str, bool] = {}
+
+    @property
+    def done(self) -> int:
+        return len(self._succeeded)
+
+    @property
+    def failed(self) -> int:
+        return sum(not succeeded for succeeded in self._succeeded.values())
+
+    def advance(self, domain: str, succeeded: bool):
+        self._succeeded[domain] = succeeded
+        if _enabled:
+            outcome = "updated" if succeeded else "failed"
+            message = f"[{self.done}/{self.total}] {self.record_type} {domain}"
+            message += f" {outcome}"
+            if self.failed:
+                message += f" ({self.failed} failed so far)"
+            printer.info(message)
+        # the messages about the domain are written together, once it's done
+        printer.flush()
+
+
+def domain_status(result: Optional[UpdateResult], domain: str) -> str:
+    if result is None:
+        return "-"
+    elif domain in result.updated_domains:
+        return "updated"
+    elif domain in result.failed_domains:
+        return "failed"
+    elif result.skipped:
+        return "skipped"
+    elif result.paused:
+        return "paused"
+    elif result.postponed_until is not None:
+        return "postponed"
+    elif result.new_ip is None:
+        return "no address"
+    return "up-to-date"
+
+
+def summary_table(
+    report: Report,
+    domains_by_type: Dict[RecordType, Sequence[str]],
+    domain_links: Optional[Dict[str, str]] = None,
+) -> List[str]:
+    domain_links = domain_links or {}
+    record_types = [rt for rt in ("A", "AAAA") if domains_by_type.get(rt)]
+    domains = sorted({d for rt in record_types for d in domains_by_type[rt]})
+    if not domains:
+        return []
+
+    rows = []
+    for domain in domains:
+        row = [domain]
+        for record_type in record_types:
+            result = None
+            if domain in domains_by_type[record_type]:
+                link = domain_links.get(domain)
+                result = next(
+                    (
+                        r
+                        for r in report.results
+                        if r.record_type == record_type and r.link == link
+                    ),
+                    None,
+                )
+            row.append(domain_status(result, domain))
+        rows.append(row)
+
+    header = ["Domain", *record_types]
+    widths = [max(len(row[i]) for row in [header, *rows]) for i in range(len(header))]
+    return [
+        "  ".join(cell.ljust(width) for cell, width in zip(row, widths)).rstrip()
+        for row in [header, *rows]
+    ]
@@ -0,0 +1,283 @@
+import abc
+import datetime
+import functools
+import threading
+from typing import Dict, List, NamedTuple, Optional
+from .cache import ZoneRecord
+from .types import IPAddress, RecordType
+from . import printer
+
+
+class DNSProviderError(Exception):
+    """The DNS provider could not do what we asked for."""
+
+
+class NotSupportedError(DNSProviderError):
+    """The provider can't do this at all, e.g. its API has no such call."""
+
+
+class AuthenticationError(DNSProviderError):
+    """The credentials are invalid, expired or revoked, retrying won't help."""
+
+
+class RateLimitedError(DNSProviderError):
+    """Too many requests, retrying right away won't help."""
+
+
+class CurrentRecord(NamedTuple):
+    """A record as it is at the provider right now."""
+
+    content: str
+    # None when the provider has no proxy
+    proxied: Optional[bool] = None
+
+
+class DNSProvider(abc.ABC):
+    """Where the DNS records are hosted. The updater only talks to this interface,
+    so other backends can be used and tests can use fakes.
+    """
+
+    name = "DNS provider"
+
+    @abc.abstractmethod
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        """Makes the A or AAAA record of the domain point to the IP address,
+        creating it when it doesn't exist. The cached location of the record
+        from a previous run can save some lookups, but it might be outdated.
+        The TTL is the default of the provider when it's None, providers which
+        can't change it ignore it.
+        """
+
+    @abc.abstractmethod
+    def delete_record(self, domain: str, record_type: RecordType):
+        """Deletes the record, if it exists."""
+
+    @abc.abstractmethod
+    def verify_credentials(self):
+        """Raises DNSProviderError when the credentials are not usable."""
+
+    def check_zone(self, domain: str):
+        """Raises DNSProviderError when the domain is not under a zone the
+        credentials can manage. Providers which can't tell accept every domain.
+        """
+
+    def supports_ttl(self, domain: str) -> bool:
+        """Whether the TTL passed to ensure_record is set on the record of the
+        domain, so the returned ZoneRecord has it too.
+        """
+        return False
+
+    def ensure_record_set(
+        self,
+        domain: str,
+        ips: List[IPAddress],
+        proxied: bool = False,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        """Makes the domain have exactly one A or AAAA record for each of the IP
+        addresses (round-robin DNS), adding and removing records as needed.
+        """
+        raise DNSProviderError(f"{self.name} can't manage multiple records.")
+
+    def find_domains(self, pattern: str) -> List[str]:
+        """Names of the existing A and AAAA records matching the glob pattern,
+        like "home-*.example.com".
+        """
+        raise DNSProviderError(f"{self.name} can't list the records of a zone.")
+
+    def verify_record(self, domain: str, ip: IPAddress, cached: ZoneRecord) -> bool:
+        """Whether the cached record still exists and points to the IP address,
+        e.g. it wasn't edited or deleted by hand. Providers which can't tell
+        trust the cache.
+        """
+        return True
+
+    def current_records(
+        self, domain: str, record_type: RecordType
+    ) -> List[CurrentRecord]:
+        """The existing records of the domain with the type, without changing
+        anything, e.g. for --plan.
+        """
+        raise NotSupportedError(f"{self.name} can't look up the records.")
+
+    def credentials_expire_on(self) -> Optional[datetime.datetime]:
+        """When the credentials stop working, None when they don't expire or the
+        provider can't tell.
+        """
+        return None
+
+    def credentials_rotated(self) -> bool:
+        """Whether the provider switched to fallback credentials since the last
+        call, so the switch is reported only once.
+        """
+        return False
+
+
+class ProviderRouter(DNSProvider):
+    """Sends each domain to the provider hosting it, so domains can be spread
+    over multiple providers. Domains without an explicit provider go to the
+    default one.
+    """
+
+    name = "DNS providers"
+
+    def __init__(
+        self, default: DNSProvider, domain_providers: Dict[str, DNSProvider]
+    ):
+        self.default = default
+        self.domain_providers = domain_providers
+
+    def provider_for(self, domain: str) -> DNSProvider:
+        return self.domain_providers.get(domain, self.default)
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        provider = self.provider_for(domain)
+        return provider.ensure_record(domain, ip, proxied, cached, ttl)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        self.provider_for(domain).delete_record(domain, record_type)
+
+    def check_zone(self, domain: str):
+        self.provider_for(domain).check_zone(domain)
+
+    def supports_ttl(self, domain: str) -> bool:
+        return self.provider_for(domain).supports_ttl(domain)
+
+    def ensure_record_set(
+        self,
+        domain: str,
+        ips: List[IPAddress],
+        proxied: bool = False,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        provider = self.provider_for(domain)
+        return provider.ensure_record_set(domain, ips, proxied, ttl)
+
+    def find_domains(self, pattern: str) -> List[str]:
+        return self.default.find_domains(pattern)
+
+    def verify_record(self, domain: str, ip: IPAddress, cached: ZoneRecord) -> bool:
+        return self.provider_for(domain).verify_record(domain, ip, cached)
+
+    def current_records(
+        self, domain: str, record_type: RecordType
+    ) -> List[CurrentRecord]:
+        return self.provider_for(domain).current_records(domain, record_type)
+
+    def _unique_providers(self) -> List[DNSProvider]:
+        providers = [self.default, *self.domain_providers.values()]
+        return list({id(provider): provider for provider in providers}.values())
+
+    def verify_credentials(self):
+        for provider in self._unique_providers():
+            provider.verify_credentials()
+
+    def credentials_expire_on(self) -> Optional[datetime.datetime]:
+        expiries = [p.credentials_expire_on() for p in self._unique_providers()]
+        return min((expiry for expiry in expiries if expiry is not None), default=None)
+
+    def credentials_rotated(self) -> bool:
+        # every provider has to be asked, so none of them reports it again
+        return any([p.credentials_rotated() for p in self._unique_providers()])
+
+
+class FallbackProvider(DNSProvider):
+    """Switches to the secondary credentials when the primary ones are rejected,
+    so a token can be rotated without downtime: the new one is deployed as the
+    secondary everywhere first, then the old one can be revoked.
+    """
+
+    def __init__(self, primary: DNSProvider, secondary: DNSProvider):
+        self.name = primary.name
+        self.primary = primary
+        self.secondary = secondary
+        self.active = primary
+        self._rotated = False
+        self._lock = threading.Lock()
+
+    def _call(self, method: str, *args, **kwargs):
+        provider = self.active
+        try:
+            return getattr(provider, method)(*args, **kwargs)
+        except AuthenticationError as e:
+            if provider is self.secondary:
+                raise
+            with self._lock:
+                # the other flow might have switched already
+                if self.active is self.primary:
+                    printer.warning(
+                        f"The primary credentials of {self.name} were rejected "
+                        f"({e}), switching to the fallback ones."
+                    )
+                    self.active = self.secondary
+                    self._rotated = True
+        return getattr(self.secondary, method)(*args, **kwargs)
+
+    def __getattr__(self, name: str):
+        # methods of the specific provider, e.g. the TXT records of the leader lease
+        if name.startswith("_") or not callable(getattr(self.primary, name)):
+            raise AttributeError(name)
+        return functools.partial(self._call, name)
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        return self._call("ensure_record", domain, ip, proxied, cached, ttl)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        self._call("delete_record", domain, record_type)
+
+    def verify_credentials(self):
+        self._call("verify_credentials")
+
+    def check_zone(self, domain: str):
+        self._call("check_zone", domain)
+
+    def supports_ttl(self, domain: str) -> bool:
+        return self.primary.supports_ttl(domain)
+
+    def ensure_record_set(
+        self,
+        domain: str,
+        ips: List[IPAddress],
+        proxied: bool = False,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        return self._call("ensure_record_set", domain, ips, proxied, ttl)
+
+    def find_domains(self, pattern: str) -> List[str]:
+        return self._call("find_domains", pattern)
+
+    def verify_record(self, domain: str, ip: IPAddress, cached: ZoneRecord) -> bool:
+        return self._call("verify_record", domain, ip, cached)
+
+    def current_records(
+        self, domain: str, record_type: RecordType
+    ) -> List[CurrentRecord]:
+        return self._call("current_records", domain, record_type)
+
+    def credentials_expire_on(self) -> Optional[datetime.datetime]:
+        return self._call("credentials_expire_on")
+
+    def credentials_rotated(self) -> bool:
+        rotated, self._rotated = self._rotated, False
+        return rotated
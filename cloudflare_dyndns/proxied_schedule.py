@@ -0,0 +1,75 @@
+"""Turning the Cloudflare proxy of a domain on and off at certain times of the
+day, e.g. off at night for direct access to a game server. The daemon applies
+the schedules along with the address updates.
+"""
+import datetime
+import re
+from typing import Dict, Optional
+import attr
+from . import printer
+
+
+WINDOW_RE = re.compile(r"^(\d{1,2}):(\d{2})-(\d{1,2}):(\d{2})$")
+
+
+def _parse_time(hour: str, minute: str) -> datetime.time:
+    try:
+        return datetime.time(int(hour), int(minute))
+    except ValueError:
+        raise ValueError(f'"{hour}:{minute}" is not a valid time.')
+
+
+@attr.s(auto_attribs=True, frozen=True)
+class ProxiedSchedule:
+    """The proxy is on between start and end every day, in local time, and off
+    otherwise. The window can go past midnight, like 22:00-06:00.
+    """
+
+    start: datetime.time
+    end: datetime.time
+
+    @classmethod
+    def parse(cls, value: str) -> "ProxiedSchedule":
+        match = WINDOW_RE.match(value.replace(" ", ""))
+        if match is None:
+            raise ValueError(f'"{value}" has to be a time window like 08:00-22:00.')
+        start = _parse_time(*match.group(1, 2))
+        end = _parse_time(*match.group(3, 4))
+        if start == end:
+            raise ValueError(f'"{value}" starts and ends at the same time.')
+        return cls(start, end)
+
+    def proxied_at(self, moment: datetime.datetime) -> bool:
+        time = moment.time()
+        if self.start < self.end:
+            return self.start <= time < self.end
+        return time >= self.start or time < self.end
+
+    def next_change(self, moment: datetime.datetime) -> datetime.datetime:
+        changes = [
+            datetime.datetime.combine(moment.date() + datetime.timedelta(days), time)
+            for days in (0, 1)
+            for time in (self.start, self.end)
+        ]
+        return min(change for change in changes if change > moment)
+
+    def __str__(self) -> str:
+        return f"{self.start:%H:%M}-{self.end:%H:%M}"
+
+
+def apply_schedules(
+    schedules: Dict[str, ProxiedSchedule],
+    domain_proxied: Dict[str, bool],
+    moment: datetime.datetime,
+) -> Optional[float]:
+    """Sets whether the domains are proxied right now, and returns the timestamp
+    of the next change of any of them.
+    """
+    for domain, schedule in schedules.items():
+        proxied = schedule.proxied_at(moment)
+        if domain in domain_proxied and domain_proxied[domain] is not proxied:
+            state = "on" if proxied else "off"
+            printer.info(f"Turning the proxy of {domain} {state} ({schedule}).")
+        domain_proxied[domain] = proxied
+    changes = [schedule.next_change(moment) for schedule in schedules.values()]
+    return min(changes).timestamp() if changes else None
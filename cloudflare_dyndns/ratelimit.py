@@ -0,0 +1,52 @@
+"""Keeps the number of Cloudflare API requests under the limit of the API (1200
+requests in 5 minutes), even with zone-wide matching, many domains or short
+daemon intervals. Requests over the limit wait instead of failing with 429.
+"""
+import threading
+import time
+from typing import Callable
+
+
+# the limit of the API is 1200 requests in 5 minutes
+DEFAULT_RATE = 4.0
+DEFAULT_BURST = 10
+
+
+class TokenBucket:
+    """Allows bursts of requests up to the given size, then the given number of
+    requests per second.
+    """
+
+    def __init__(
+        self,
+        rate: float,
+        burst: int,
+        clock: Callable[[], float] = time.monotonic,
+        sleep: Callable[[float], None] = time.sleep,
+    ):
+        self._rate = rate
+        self._burst = burst
+        self._clock = clock
+        self._sleep = sleep
+        self._tokens = float(burst)
+        self._last_refill = clock()
+        self._lock = threading.Lock()
+
+    def _refill(self):
+        now = self._clock()
+        elapsed = now - self._last_refill
+        self._tokens = min(self._burst, self._tokens + elapsed * self._rate)
+        self._last_refill = now
+
+    def acquire(self) -> float:
+        """Waits until a request can be made. Returns the seconds waited."""
+        waited = 0.0
+        with self._lock:
+            self._refill()
+            while self._tokens < 1:
+                delay = (1 - self._tokens) / self._rate
+                self._sleep(delay)
+                waited += delay
+                self._refill()
+            self._tokens -= 1
+        return waited
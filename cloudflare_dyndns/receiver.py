@@ -0,0 +1,39 @@
+import hmac
+import ipaddress
+from typing import Dict, Optional
+from .types import IPAddress, RecordType, get_record_type
+
+
+# "myip" is what DynDNS2 clients (routers, ddclient) send
+ADDRESS_PARAMS = ("ip", "myip", "ipv4", "ipv6")
+
+SECRET_HEADER = "X-Webhook-Secret"
+
+
+class InvalidWebhook(Exception):
+    pass
+
+
+def parse_addresses(params: dict) -> Dict[RecordType, IPAddress]:
+    """Collects the IP addresses from the webhook parameters by record type.
+    Values can hold multiple addresses separated by commas.
+    """
+    addresses: Dict[RecordType, IPAddress] = {}
+    for name in ADDRESS_PARAMS:
+        value = params.get(name)
+        if not value:
+            continue
+        for address in str(value).split(","):
+            try:
+                ip = ipaddress.ip_address(address.strip())
+            except ValueError:
+                raise InvalidWebhook(f"Invalid IP address in {name}: {address}")
+            addresses[get_record_type(ip)] = ip
+    return addresses
+
+
+def check_secret(expected: str, given: Optional[str]) -> bool:
+    # e.g. a number or a list in a JSON body
+    if not given or not isinstance(given, str):
+        return False
+    return hmac.compare_digest(given.encode(), expected.encode())
@@ -0,0 +1,44 @@
+from typing import Callable, Dict
+from .desec import DeSECProvider
+from .digitalocean import DigitalOceanProvider
+from .duckdns import DuckDNSProvider
+from .dyndns2 import DynDNS2Provider
+from .exec_provider import ExecProvider
+from .gandi import GandiProvider
+from .hetzner import HetznerProvider
+from .namecheap import NamecheapProvider
+from .porkbun import PorkbunProvider
+from .providers import DNSProvider
+from .rfc2136 import RFC2136Provider
+from .route53 import Route53Provider
+
+
+# Cloudflare is not here, because its API token comes from the command line.
+# These read their credentials from environment variables.
+PROVIDER_TYPES: Dict[str, Callable[[], DNSProvider]] = {
+    "route53": Route53Provider,
+    "digitalocean": DigitalOceanProvider,
+    "hetzner": HetznerProvider,
+    "gandi": GandiProvider,
+    "porkbun": PorkbunProvider,
+    "desec": DeSECProvider,
+    "duckdns": DuckDNSProvider,
+    "namecheap": NamecheapProvider,
+    "dyndns2": DynDNS2Provider,
+    "rfc2136": RFC2136Provider,
+    "exec": ExecProvider,
+}
+
+
+def register_provider_type(name: str, factory: Callable[[], DNSProvider]):
+    """Makes a new DNS provider available for --provider and --domain-provider."""
+    PROVIDER_TYPES[name] = factory
+
+
+def create_provider(name: str) -> DNSProvider:
+    try:
+        factory = PROVIDER_TYPES[name]
+    except KeyError:
+        choices = ", ".join(PROVIDER_TYPES)
+        raise ValueError(f"Unknown DNS provider: {name}, choose from: {choices}")
+    return factory()
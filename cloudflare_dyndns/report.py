@@ -0,0 +1,134 @@
+import datetime
+import json
+from typing import Dict, List, Optional
+from pydantic import BaseModel
+from .build_info import BuildInfo
+from .cache import DomainError
+from .geoip import GeoInfo
+from .stats import RunStats
+from .types import IPAddress, RecordType
+
+
+class UpdateResult(BaseModel):
+    """What happened with one IP address family during a run."""
+
+    record_type: RecordType
+    old_ip: Optional[IPAddress] = None
+    new_ip: Optional[IPAddress] = None
+    updated_domains: List[str] = []
+    failed_domains: List[str] = []
+    errors: List[str] = []
+    # the last error of the domains which are still failing, maybe from earlier runs
+    domain_errors: Dict[str, DomainError] = {}
+    # timestamp until the update is held back by --min-update-interval
+    postponed_until: Optional[float] = None
+    # there was no connectivity with this IP version (--auto-family)
+    skipped: bool = False
+    # the address was detected, but the records were not touched
+    paused: bool = False
+    # interface or source address the address was detected through
+    link: Optional[str] = None
+    # where the new address is, with --geoip-db or --geoip-online
+    geo: Optional[GeoInfo] = None
+    # the provider rejected the credentials, the remaining domains were not tried
+    auth_failed: bool = False
+    # too many requests, the remaining domains were left for the next run
+    rate_limited: bool = False
+
+    @property
+    def location(self) -> str:
+        return str(self.geo) if self.geo else ""
+
+    @property
+    def changed(self) -> bool:
+        return bool(self.updated_domains)
+
+    @property
+    def failed(self) -> bool:
+        return bool(self.failed_domains or self.errors)
+
+
+class Report(BaseModel):
+    results: List[UpdateResult] = []
+    exit_code: int = 0
+    stats: RunStats = RunStats()
+    # newer release, when checking for updates is enabled
+    available_update: Optional[str] = None
+    # when the API token expires, if it's soon
+    token_expires_on: Optional[datetime.datetime] = None
+    # the primary API token was rejected, the fallback one is used from now on
+    token_rotated: bool = False
+    # timestamp when a --proxied-schedule turns the proxy of a domain on or off
+    proxied_change_at: Optional[float] = None
+    # the static addresses of the maintenance mode, instead of the detected ones
+    maintenance: Dict[RecordType, IPAddress] = {}
+    # the program which wrote the report, for bug reports
+    build: Optional[BuildInfo] = None
+    # the credentials are invalid, the daemon backs off until they work again
+    auth_failed: bool = False
+    # the provider limited the requests, the daemon waits before the next run
+    rate_limited: bool = False
+
+    @property
+    def changed(self) -> bool:
+        return any(result.changed for result in self.results)
+
+    @property
+    def failed(self) -> bool:
+        return any(result.failed for result in self.results)
+
+    @property
+    def status(self) -> str:
+        if self.failed:
+            return "failed"
+        elif self.changed:
+            return "changed"
+        return "unchanged"
+
+    @property
+    def postponed_until(self) -> Optional[float]:
+        timestamps = [r.postponed_until for r in self.results if r.postponed_until]
+        return min(timestamps, default=None)
+
+    def to_json(self) -> str:
+        """The report with its status, so readers of the file can tell an update
+        from a run which had nothing to do.
+        """
+        data = json.loads(self.json())
+        data["status"] = self.status
+        return json.dumps(data, indent=2)
+
+    def get_result(self, record_type: RecordType) -> Optional[UpdateResult]:
+        for result in self.results:
+            if result.record_type == record_type:
+                return result
+        return None
+
+    def summary(self) -> str:
+        """Short, human readable description of the run."""
+        parts = []
+        for result in self.results:
+            if result.changed:
+                location = f" ({result.location})" if result.location else ""
+                parts.append(
+                    f"{result.record_type} records of {', '.join(result.updated_domains)} "
+                    f"updated to {result.new_ip}{location}"
+                )
+            if result.failed_domains:
+                parts.append(
+                    f"failed to update {result.record_type} records of "
+                    + ", ".join(result.failed_domains)
+                )
+            parts.extend(result.errors)
+        summary = "; ".join(parts) or "Every domain is up-to-date."
+        if self.maintenance:
+            summary = f"Maintenance mode is on. {summary}"
+        if self.auth_failed:
+            summary = f"The API token is invalid or expired! {summary}"
+        if self.available_update:
+            summary += f" Version {self.available_update} is available."
+        if self.token_rotated:
+            summary += " The primary API token was rejected, switched to the fallback."
+        if self.token_expires_on:
+            summary += f" The API token expires on {self.token_expires_on:%Y-%m-%d}."
+        return summary
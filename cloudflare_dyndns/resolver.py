@@ -0,0 +1,186 @@
+"""Resolves the hostnames the tool connects to (the Cloudflare API, the IP
+services) with the given nameservers instead of the system resolver, for hosts
+behind a broken or filtering router. Nameservers can be plain DNS servers,
+DNS over TLS (tls://1.1.1.1) or DNS over HTTPS (https://1.1.1.1/dns-query).
+"""
+import ipaddress
+import socket
+import ssl
+import struct
+from typing import List, Set
+from urllib.parse import urlsplit
+import requests
+from .dns_lookup import TYPE_A, TYPE_AAAA, DNSLookupError
+from . import binding, dns_lookup, tls
+
+
+DOT_PORT = 853
+DNS_MESSAGE = "application/dns-message"
+TIMEOUT = 5
+
+_nameservers: List["Nameserver"] = []
+# hostnames of the nameservers themselves, resolved by the system resolver
+_bootstrap_hosts: Set[str] = set()
+
+
+def _is_ip_address(host: str) -> bool:
+    try:
+        ipaddress.ip_address(host)
+    except ValueError:
+        return False
+    return True
+
+
+def _recv_exactly(sock: socket.socket, size: int) -> bytes:
+    data = b""
+    while len(data) < size:
+        chunk = sock.recv(size - len(data))
+        if not chunk:
+            raise DNSLookupError("Connection closed in the middle of the response")
+        data += chunk
+    return data
+
+
+class Nameserver:
+    def __init__(self, address: str):
+        self.address = address
+
+    def __str__(self):
+        return self.address
+
+    def lookup(self, name: str, record_type: int) -> List[str]:
+        query_id, request = dns_lookup.build_query(name, record_type)
+        response = self._exchange(request)
+        try:
+            return dns_lookup.parse_response(response, query_id, record_type)
+        except (struct.error, IndexError, UnicodeDecodeError, ValueError) as e:
+            raise DNSLookupError(f"Invalid response from {self}: {e}")
+
+    def _exchange(self, request: bytes) -> bytes:
+        raise NotImplementedError
+
+
+class UDPNameserver(Nameserver):
+    def lookup(self, name: str, record_type: int) -> List[str]:
+        version = ipaddress.ip_address(self.address).version
+        family = socket.AF_INET if version == 4 else socket.AF_INET6
+        return dns_lookup.query(name, record_type, self.address, family, TIMEOUT)
+
+
+class TLSNameserver(Nameserver):
+    """DNS over TLS (RFC7858). The certificate is checked for the hostname after
+    the #, or for the address itself, like tls://1.1.1.1#cloudflare-dns.com.
+    """
+
+    def __init__(self, host: str, port: int, hostname: str):
+        super().__init__(f"tls://{host}:{port}")
+        self._host = host
+        self._port = port
+        self._hostname = hostname
+
+    def _exchange(self, request: bytes) -> bytes:
+        address = (self._host, self._port)
+        context = tls.ssl_context()
+        try:
+            sock = binding.create_connection(address, TIMEOUT)
+            with context.wrap_socket(sock, server_hostname=self._hostname) as tls_sock:
+                tls_sock.sendall(struct.pack("!H", len(request)) + request)
+                (length,) = struct.unpack("!H", _recv_exactly(tls_sock, 2))
+                return _recv_exactly(tls_sock, length)
+        except (OSError, ssl.SSLError) as e:
+            raise DNSLookupError(f"No response from {self}: {e}")
+
+
+class HTTPSNameserver(Nameserver):
+    """DNS over HTTPS (RFC8484) with POST requests."""
+
+    def __init__(self, url: str):
+        super().__init__(url)
+        self._session = requests.Session()
+
+    def _exchange(self, request: bytes) -> bytes:
+        headers = {"Content-Type": DNS_MESSAGE, "Accept": DNS_MESSAGE}
+        try:
+            response = self._session.post(
+                self.address, data=request, headers=headers, timeout=TIMEOUT
+            )
+            response.raise_for_status()
+        except requests.RequestException as e:
+            raise DNSLookupError(f"No response from {self}: {e}")
+        return response.content
+
+
+def parse_nameserver(value: str) -> Nameserver:
+    if value.startswith("https://"):
+        host = urlsplit(value).hostname
+        if not host:
+            raise ValueError(f"Invalid DNS over HTTPS URL: {value}")
+        if not _is_ip_address(host):
+            _bootstrap_hosts.add(host)
+        return HTTPSNameserver(value)
+
+    elif value.startswith("tls://"):
+        url = urlsplit(value)
+        try:
+            host, port = url.hostname, url.port or DOT_PORT
+        except ValueError:
+            host = None
+        if not host:
+            raise ValueError(f"Invalid DNS over TLS address: {value}")
+        if not _is_ip_address(host):
+            _bootstrap_hosts.add(host)
+        return TLSNameserver(host, port, url.fragment or host)
+
+    elif _is_ip_address(value):
+        return UDPNameserver(value)
+
+    raise ValueError(
+        f"Invalid resolver: {value}, it has to be an IP address, "
+        "a tls:// address or an https:// URL"
+    )
+
+
+def resolve(host: str, family: int = socket.AF_UNSPEC) -> List[str]:
+    """Looks up the addresses of the host, trying the nameservers in order."""
+    record_types = []
+    if family in (socket.AF_UNSPEC, socket.AF_INET):
+        record_types.append(TYPE_A)
+    if family in (socket.AF_UNSPEC, socket.AF_INET6):
+        record_types.append(TYPE_AAAA)
+
+    errors = []
+    for nameserver in _nameservers:
+        try:
+            return [
+                address
+                for record_type in record_types
+                for address in nameserver.lookup(host, record_type)
+            ]
+        except DNSLookupError as e:
+            errors.append(str(e))
+    message = f"Cannot resolve {host}: " + "; ".join(errors)
+    raise socket.gaierror(socket.EAI_AGAIN, message)
+
+
+def getaddrinfo(host: str, port, family: int = 0, type: int = 0):
+    if _is_ip_address(host) or host == "localhost" or host in _bootstrap_hosts:
+        return socket.getaddrinfo(host, port, family, type)
+
+    addresses = resolve(host, family)
+    if not addresses:
+        raise socket.gaierror(socket.EAI_NONAME, f"{host} has no addresses")
+    port = int(port or 0)
+    address_info = []
+    for address in addresses:
+        if ":" in address:
+            address_family, sockaddr = socket.AF_INET6, (address, port, 0, 0)
+        else:
+            address_family, sockaddr = socket.AF_INET, (address, port)
+        address_info.append((address_family, type, 0, "", sockaddr))
+    return address_info
+
+
+def configure(nameservers: List[str]):
+    """Resolve every hostname with these nameservers from now on."""
+    _nameservers[:] = [parse_nameserver(value) for value in nameservers]
+    binding.use_resolver(getaddrinfo)
@@ -0,0 +1,253 @@
+import base64
+import binascii
+import hashlib
+import hmac
+import os
+import random
+import socket
+import struct
+import time
+from typing import List, Optional, Tuple
+from .cache import ZoneRecord
+from .dns_lookup import CLASS_IN, DNS_PORT, TYPE_A, TYPE_AAAA, _encode_name
+from .providers import DNSProvider, DNSProviderError
+from .types import IPAddress, RecordType, get_record_type
+from . import binding, printer, stats
+
+
+TYPE_SOA = 6
+TYPE_TSIG = 250
+TYPE_ANY = 255
+CLASS_ANY = 255
+OPCODE_UPDATE = 5
+# allowed clock difference between us and the server in seconds
+TSIG_FUDGE = 300
+
+# algorithm names used in the TSIG record and the matching hash functions
+TSIG_ALGORITHMS = {
+    "hmac-md5": ("hmac-md5.sig-alg.reg.int", hashlib.md5),
+    "hmac-sha1": ("hmac-sha1", hashlib.sha1),
+    "hmac-sha256": ("hmac-sha256", hashlib.sha256),
+    "hmac-sha512": ("hmac-sha512", hashlib.sha512),
+}
+
+RCODES = {
+    1: "FORMERR",
+    2: "SERVFAIL",
+    3: "NXDOMAIN",
+    4: "NOTIMP",
+    5: "REFUSED: the server doesn't allow updates from us",
+    6: "YXDOMAIN",
+    7: "YXRRSET",
+    8: "NXRRSET",
+    9: "NOTAUTH: wrong TSIG key or not authoritative for the zone",
+    10: "NOTZONE: the name is not in the zone",
+}
+
+
+class RFC2136Error(DNSProviderError):
+    """The DNS server rejected the dynamic update."""
+
+
+def _record(name: str, rtype: int, rclass: int, ttl: int, rdata: bytes = b"") -> bytes:
+    header = struct.pack("!HHIH", rtype, rclass, ttl, len(rdata))
+    return _encode_name(name) + header + rdata
+
+
+def build_update(
+    message_id: int,
+    zone: str,
+    updates: List[bytes],
+    prerequisites: List[bytes] = (),
+) -> bytes:
+    """An UPDATE message with the zone section and the given records."""
+    header = struct.pack(
+        "!HHHHHH",
+        message_id,
+        OPCODE_UPDATE << 11,
+        1,
+        len(prerequisites),
+        len(updates),
+        0,
+    )
+    zone_section = _encode_name(zone) + struct.pack("!HH", TYPE_SOA, CLASS_IN)
+    return header + zone_section + b"".join(prerequisites) + b"".join(updates)
+
+
+def sign(
+    message: bytes,
+    key_name: str,
+    secret: bytes,
+    algorithm: str = "hmac-sha256",
+    now: Optional[float] = None,
+) -> bytes:
+    """Appends a TSIG record (RFC 8945) to the message."""
+    algorithm_name, digestmod = TSIG_ALGORITHMS[algorithm]
+    time_signed = int(time.time() if now is None else now)
+    timers = struct.pack(
+        "!HIH", time_signed >> 32, time_signed & 0xFFFFFFFF, TSIG_FUDGE
+    )
+    # no error and no other data in requests
+    error_and_other = struct.pack("!HH", 0, 0)
+    variables = (
+        _encode_name(key_name.lower())
+        + struct.pack("!HI", CLASS_ANY, 0)
+        + _encode_name(algorithm_name)
+        + timers
+        + error_and_other
+    )
+    mac = hmac.new(secret, message + variables, digestmod).digest()
+    rdata = (
+        _encode_name(algorithm_name)
+        + timers
+        + struct.pack("!H", len(mac))
+        + mac
+        + message[:2]
+        + error_and_other
+    )
+    (additional_count,) = struct.unpack("!H", message[10:12])
+    return (
+        message[:10]
+        + struct.pack("!H", additional_count + 1)
+        + message[12:]
+        + _record(key_name, TYPE_TSIG, CLASS_ANY, 0, rdata)
+    )
+
+
+def check_response(message: bytes, message_id: int):
+    response_id, flags = struct.unpack("!HH", message[:4])
+    if response_id != message_id:
+        raise RFC2136Error("Response for a different update")
+    rcode = flags & 0xF
+    if rcode != 0:
+        raise RFC2136Error(f"DNS update failed: {RCODES.get(rcode, rcode)}")
+
+
+def parse_server_address(address: str) -> Tuple[str, int]:
+    """host, host:port, IPv6 or [IPv6]:port"""
+    host, _, port = address.rpartition(":")
+    # a bare IPv6 address has colons, but no brackets
+    if not host or not port.isdigit() or (":" in host and "]" not in host):
+        return address.strip("[]"), DNS_PORT
+    return host.strip("[]"), int(port)
+
+
+class RFC2136Provider(DNSProvider):
+    """Updates records on self-hosted DNS servers (BIND, Knot, PowerDNS, ...)
+    with RFC 2136 dynamic updates, like nsupdate does. The settings are read
+    from the RFC2136_SERVER, RFC2136_ZONE, RFC2136_KEY_NAME, RFC2136_KEY_SECRET
+    and RFC2136_KEY_ALGORITHM environment variables if not given. Without a key,
+    the updates are not signed.
+    """
+
+    name = "RFC 2136"
+
+    def __init__(
+        self,
+        server: Optional[str] = None,
+        zone: Optional[str] = None,
+        key_name: Optional[str] = None,
+        key_secret: Optional[str] = None,
+        key_algorithm: Optional[str] = None,
+        ttl: int = 60,
+    ):
+        server = server or os.environ.get("RFC2136_SERVER")
+        zone = zone or os.environ.get("RFC2136_ZONE")
+        if not server or not zone:
+            raise RFC2136Error(
+                "DNS server or zone is missing, set the RFC2136_SERVER and "
+                "RFC2136_ZONE environment variables."
+            )
+        self._server, self._port = parse_server_address(server)
+        self._zone = zone.rstrip(".")
+        self._key_name = key_name or os.environ.get("RFC2136_KEY_NAME")
+        key_secret = key_secret or os.environ.get("RFC2136_KEY_SECRET")
+        self._key_algorithm = (
+            key_algorithm or os.environ.get("RFC2136_KEY_ALGORITHM") or "hmac-sha256"
+        )
+        if self._key_algorithm not in TSIG_ALGORITHMS:
+            choices = ", ".join(TSIG_ALGORITHMS)
+            raise RFC2136Error(f"Unknown TSIG algorithm, choose from: {choices}")
+        if bool(self._key_name) != bool(key_secret):
+            raise RFC2136Error("TSIG key needs both a name and a secret")
+        printer.register_secret(key_secret)
+        try:
+            self._key_secret = base64.b64decode(key_secret) if key_secret else None
+        except binascii.Error:
+            raise RFC2136Error("TSIG key secret has to be base64 encoded")
+        self._ttl = ttl
+
+    def _send(self, updates: List[bytes], prerequisites: List[bytes] = ()):
+        message_id = random.randrange(0x10000)
+        message = build_update(message_id, self._zone, updates, prerequisites)
+        if self._key_secret is not None:
+            message = sign(
+                message, self._key_name, self._key_secret, self._key_algorithm
+            )
+        try:
+            address_info = binding.getaddrinfo(
+                self._server, self._port, type=socket.SOCK_DGRAM
+            )[0]
+        except socket.gaierror as e:
+            raise RFC2136Error(f"Cannot resolve {self._server}: {e}")
+        family, _, _, _, server_address = address_info
+        with socket.socket(family, socket.SOCK_DGRAM) as sock:
+            sock.settimeout(10)
+            try:
+                binding.bind(sock)
+                with stats.timed("rfc2136", "UPDATE"):
+                    sock.sendto(message, server_address)
+                    response, _ = sock.recvfrom(4096)
+            except OSError as e:
+                raise RFC2136Error(f"No response from {self._server}: {e}")
+        try:
+            check_response(response, message_id)
+        except struct.error:
+            raise RFC2136Error(f"Invalid response from {self._server}")
+
+    def _check_domain(self, domain: str):
+        if domain != self._zone and not domain.endswith("." + self._zone):
+            raise RFC2136Error(f'"{domain}" is not in the {self._zone} zone')
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        self._check_domain(domain)
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record on {self._server}.',
+            domain=domain,
+            record_type=record_type,
+        )
+        rtype = TYPE_A if record_type == "A" else TYPE_AAAA
+        # replaces every address of the name with the current one
+        self._send(
+            [
+                _record(domain, rtype, CLASS_ANY, 0),
+                _record(domain, rtype, CLASS_IN, self._ttl, ip.packed),
+            ]
+        )
+        return ZoneRecord(zone_id=self._zone, record_id=domain, proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        self._check_domain(domain)
+        printer.warning(
+            f'Deleting {record_type} record for "{domain}" on {self._server}.',
+            domain=domain,
+            record_type=record_type,
+        )
+        rtype = TYPE_A if record_type == "A" else TYPE_AAAA
+        self._send([_record(domain, rtype, CLASS_ANY, 0)])
+
+    def verify_credentials(self):
+        # an update without changes, only with the "zone apex exists"
+        # prerequisite, which still needs a valid key and permission
+        self._send([], [_record(self._zone, TYPE_ANY, CLASS_ANY, 0)])
+
+    def check_zone(self, domain: str):
+        self._check_domain(domain)
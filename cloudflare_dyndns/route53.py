@@ -0,0 +1,211 @@
+import os
+import xml.etree.ElementTree as ET
+from typing import List, Optional
+from urllib.parse import urlencode
+import requests
+from .aws_sigv4 import sign_request
+from .cache import ZoneRecord
+from .providers import DNSProvider, DNSProviderError
+from .types import IPAddress, RecordType, get_record_type
+from . import printer, stats
+
+
+API_URL = "https://route53.amazonaws.com/2013-04-01"
+# Route53 is a global service, but requests have to be signed for this region
+SIGNING_REGION = "us-east-1"
+XMLNS = "https://route53.amazonaws.com/doc/2013-04-01/"
+
+CHANGE_TEMPLATE = """<?xml version="1.0" encoding="UTF-8"?>
+<ChangeResourceRecordSetsRequest xmlns="{xmlns}">
+  <ChangeBatch>
+    <Comment>cloudflare-dyndns</Comment>
+    <Changes>
+      <Change>
+        <Action>{action}</Action>
+        <ResourceRecordSet>
+          <Name>{name}</Name>
+          <Type>{record_type}</Type>
+          <TTL>{ttl}</TTL>
+          <ResourceRecords>{records}</ResourceRecords>
+        </ResourceRecordSet>
+      </Change>
+    </Changes>
+  </ChangeBatch>
+</ChangeResourceRecordSetsRequest>"""
+
+
+class Route53Error(DNSProviderError):
+    """The AWS Route53 API returned an error."""
+
+
+def _fqdn(domain: str) -> str:
+    return domain.rstrip(".") + "."
+
+
+def _find_all(element: ET.Element, name: str) -> List[ET.Element]:
+    return element.findall(f".//{{{XMLNS}}}{name}")
+
+
+def _find_text(element: ET.Element, name: str) -> str:
+    found = element.find(f".//{{{XMLNS}}}{name}")
+    return "" if found is None else (found.text or "")
+
+
+class Route53Provider(DNSProvider):
+    """Updates records in AWS Route53 hosted zones. The credentials are read
+    from the usual AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+    AWS_SESSION_TOKEN environment variables.
+    """
+
+    name = "Route53"
+
+    def __init__(
+        self,
+        access_key: Optional[str] = None,
+        secret_key: Optional[str] = None,
+        session_token: Optional[str] = None,
+        ttl: int = 60,
+    ):
+        self._access_key = access_key or os.environ.get("AWS_ACCESS_KEY_ID")
+        self._secret_key = secret_key or os.environ.get("AWS_SECRET_ACCESS_KEY")
+        self._session_token = session_token or os.environ.get("AWS_SESSION_TOKEN")
+        if not self._access_key or not self._secret_key:
+            raise Route53Error(
+                "AWS credentials are missing, set AWS_ACCESS_KEY_ID and "
+                "AWS_SECRET_ACCESS_KEY environment variables."
+            )
+        printer.register_secret(self._secret_key)
+        printer.register_secret(self._session_token)
+        self._ttl = ttl
+        self._zone_ids = {}
+        self._session = requests.Session()
+
+    def _request(self, method: str, path: str, body: bytes = b"") -> ET.Element:
+        url = API_URL + path
+        headers = sign_request(
+            method,
+            url,
+            body,
+            self._access_key,
+            self._secret_key,
+            SIGNING_REGION,
+            "route53",
+            self._session_token,
+        )
+        if body:
+            headers["Content-Type"] = "application/xml"
+        try:
+            with stats.timed("route53", f"{method} {path.split('?')[0]}"):
+                response = self._session.request(
+                    method, url, data=body, headers=headers, timeout=30
+                )
+        except requests.RequestException as e:
+            raise Route53Error(f"Route53 API request failed: {e}")
+        try:
+            root = ET.fromstring(response.content)
+        except ET.ParseError:
+            raise Route53Error(f"Invalid Route53 API response: {response.status_code}")
+        if not response.ok:
+            message = _find_text(root, "Message") or response.reason
+            raise Route53Error(f"Route53 API error: {message}")
+        return root
+
+    def get_zone_id(self, domain: str) -> str:
+        """The hosted zone of the longest matching parent domain."""
+        if domain in self._zone_ids:
+            return self._zone_ids[domain]
+        labels = domain.rstrip(".").split(".")
+        for index in range(len(labels) - 1):
+            candidate = _fqdn(".".join(labels[index:]))
+            query = urlencode({"dnsname": candidate, "maxitems": "1"})
+            root = self._request("GET", f"/hostedzonesbyname?{query}")
+            for zone in _find_all(root, "HostedZone"):
+                if _find_text(zone, "Name") == candidate:
+                    zone_id = _find_text(zone, "Id").rpartition("/")[2]
+                    self._zone_ids[domain] = zone_id
+                    return zone_id
+        printer.error(f'Cannot find hosted zone for "{domain}" in Route53')
+        raise Route53Error(f"No hosted zone for {domain}")
+
+    def _change(
+        self,
+        zone_id: str,
+        action: str,
+        domain: str,
+        record_type: str,
+        values: list,
+        ttl: Optional[int] = None,
+    ):
+        records = "".join(
+            f"<ResourceRecord><Value>{value}</Value></ResourceRecord>"
+            for value in values
+        )
+        body = CHANGE_TEMPLATE.format(
+            xmlns=XMLNS,
+            action=action,
+            name=_fqdn(domain),
+            record_type=record_type,
+            ttl=ttl or self._ttl,
+            records=records,
+        )
+        self._request("POST", f"/hostedzone/{zone_id}/rrset", body.encode())
+
+    def ensure_record(
+        self,
+        domain: str,
+        ip: IPAddress,
+        proxied: bool = False,
+        cached: Optional[ZoneRecord] = None,
+        ttl: Optional[int] = None,
+    ) -> ZoneRecord:
+        record_type = get_record_type(ip)
+        printer.info(
+            f'Updating "{domain}" {record_type} record in Route53.',
+            domain=domain,
+            record_type=record_type,
+        )
+        if cached is not None:
+            try:
+                self._change(cached.zone_id, "UPSERT", domain, record_type, [ip])
+            except Route53Error:
+                printer.error("Invalid cache, looking up the hosted zone again.")
+            else:
+                return cached.copy(update={"proxied": proxied})
+
+        zone_id = self.get_zone_id(domain)
+        try:
+            self._change(zone_id, "UPSERT", domain, record_type, [ip])
+        except Route53Error as e:
+            printer.error(f'Failed to update domain "{domain}": {e}', domain=domain)
+            raise
+        # there are no record ids in Route53, the name identifies the record
+        return ZoneRecord(zone_id=zone_id, record_id=_fqdn(domain), proxied=proxied)
+
+    def delete_record(self, domain: str, record_type: RecordType):
+        printer.warning(
+            f'Deleting {record_type} record for "{domain}" in Route53.',
+            domain=domain,
+            record_type=record_type,
+        )
+        zone_id = self.get_zone_id(domain)
+        # deleting needs the exact current values and TTL
+        query = urlencode({"name": _fqdn(domain), "type": record_type, "maxitems": "1"})
+        root = self._request("GET", f"/hostedzone/{zone_id}/rrset?{query}")
+        for record_set in _find_all(root, "ResourceRecordSet"):
+            if (
+                _find_text(record_set, "Name") == _fqdn(domain)
+                and _find_text(record_set, "Type") == record_type
+            ):
+                values = [
+                    value.text for value in _find_all(record_set, "Value") if value.text
+                ]
+                ttl = int(_find_text(record_set, "TTL") or self._ttl)
+                self._change(zone_id, "DELETE", domain, record_type, values, ttl)
+                return
+        printer.info(f'{record_type} record for "{domain}" doesn\'t exist.')
+
+    def verify_credentials(self):
+        self._request("GET", "/hostedzonecount")
+
+    def check_zone(self, domain: str):
+        self.get_zone_id(domain)
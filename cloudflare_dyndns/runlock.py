@@ -0,0 +1,98 @@
+"""Keeps overlapping runs, e.g. a slow cron job and the next one, from updating
+the records and writing the cache at the same time. The lock is held by the
+operating system, which releases it when the process dies, so crashed or killed
+runs never leave a lock behind, even when their PID is reused.
+"""
+import contextlib
+import json
+import os
+import socket
+import sys
+import time
+from pathlib import Path
+from typing import Optional, Union
+
+
+class LockedError(Exception):
+    """Another process is running an update with the same cache."""
+
+
+def lock_file(cache_file: Union[str, Path]) -> Path:
+    cache_file = Path(cache_file).expanduser()
+    return cache_file.with_name(cache_file.name + ".lock")
+
+
+def try_lock(fd: int) -> bool:
+    """Locks the open file without waiting, False when another process has it."""
+    if sys.platform == "win32":
+        import msvcrt
+
+        try:
+            msvcrt.locking(fd, msvcrt.LK_NBLCK, 1)
+        except OSError:
+            return False
+    else:
+        import fcntl
+
+        try:
+            fcntl.flock(fd, fcntl.LOCK_EX | fcntl.LOCK_NB)
+        except BlockingIOError:
+            return False
+    return True
+
+
+def same_file(fd: int, path: Path) -> bool:
+    try:
+        return os.path.samestat(os.fstat(fd), path.stat())
+    except FileNotFoundError:
+        return False
+
+
+class RunLock:
+    def __init__(self, path: Union[str, Path]):
+        self._path = Path(path)
+        self._fd: Optional[int] = None
+
+    def acquire(self):
+        self._path.parent.mkdir(exist_ok=True, parents=True)
+        # the second attempt is after the holder removed the file we opened
+        for _ in range(2):
+            fd = os.open(self._path, os.O_CREAT | os.O_RDWR, 0o644)
+            if not try_lock(fd):
+                os.close(fd)
+                raise LockedError(f"Another run is in progress ({self._path})")
+            if not same_file(fd, self._path):
+                os.close(fd)
+                continue
+            # only for humans, the lock itself is the one of the operating system
+            owner = {
+                "pid": os.getpid(),
+                "hostname": socket.gethostname(),
+                "started": time.time(),
+            }
+            os.ftruncate(fd, 0)
+            os.write(fd, json.dumps(owner).encode())
+            self._fd = fd
+            return
+        raise LockedError(f"Another run took the lock first ({self._path})")
+
+    def release(self):
+        if self._fd is None:
+            return
+        if sys.platform != "win32":
+            # still locked, so a process which opened it already tries again
+            self._path.unlink(missing_ok=True)
+        os.close(self._fd)
+        self._fd = None
+        if sys.platform == "win32":
+            # open files can't be removed there, so it fails while another
+            # process is taking the lock
+            with contextlib.suppress(PermissionError):
+                self._path.unlink(missing_ok=True)
+
+    def __enter__(self) -> "RunLock":
+        self.acquire()
+        return self
+
+    def __exit__(self, *exc_info):
+        self.release()
@@ -0,0 +1,37 @@
+"""The sd_notify protocol, so systemd can supervise the daemon.
+See: https://www.freedesktop.org/software/systemd/man/sd_notify.html
+"""
+import os
+import socket
+from typing import Optional
+
+
+def notify(*states: str) -> bool:
+    """Sends state changes like READY=1 or STATUS=... to systemd.
+    Returns False when not running under systemd with Type=notify.
+    """
+    address = os.environ.get("NOTIFY_SOCKET")
+    if not address:
+        return False
+    if address.startswith("@"):
+        # abstract namespace socket
+        address = "\0" + address[1:]
+
+    with socket.socket(socket.AF_UNIX, socket.SOCK_DGRAM) as sock:
+        # never block the update loop, even when systemd is not reading
+        sock.setblocking(False)
+        try:
+            sock.sendto("\n".join(states).encode(), address)
+        except OSError:
+            return False
+    return True
+
+
+def watchdog_interval() -> Optional[float]:
+    """How often WATCHDOG=1 has to be sent in seconds, when WatchdogSec= is set."""
+    usec = os.environ.get("WATCHDOG_USEC")
+    pid = os.environ.get("WATCHDOG_PID")
+    if not usec or (pid and int(pid) != os.getpid()):
+        return None
+    # ping twice as often as required, as recommended by sd_watchdog_enabled(3)
+    return int(usec) / 1_000_000 / 2
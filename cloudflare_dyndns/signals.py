@@ -0,0 +1,57 @@
+import contextlib
+import signal
+
+
+class ShutdownRequested(BaseException):
+    """Raised from the signal handler to interrupt whatever is running,
+    even in-flight HTTP requests. It's not an Exception subclass, so generic
+    error handling doesn't swallow it.
+    """
+
+    def __init__(self, signum: int):
+        super().__init__(f"Received {signal.Signals(signum).name}")
+        self.signum = signum
+
+    @property
+    def exit_code(self) -> int:
+        # same as shells report processes killed by a signal
+        return 128 + self.signum
+
+
+class DeadlineExceeded(BaseException):
+    """Raised from the SIGALRM handler when a run takes longer than allowed,
+    interrupting whatever hangs, just like ShutdownRequested.
+    """
+
+    # same as the timeout command
+    exit_code = 124
+
+
+@contextlib.contextmanager
+def deadline(seconds: float):
+    """Interrupts the block with DeadlineExceeded after the given seconds.
+    Only works in the main thread.
+    """
+
+    def handle_alarm(signum: int, frame):
+        raise DeadlineExceeded(f"The run didn't finish in time ({seconds:g}s)")
+
+    previous_handler = signal.signal(signal.SIGALRM, handle_alarm)
+    signal.setitimer(signal.ITIMER_REAL, seconds)
+    try:
+        yield
+    finally:
+        signal.setitimer(signal.ITIMER_REAL, 0)
+        signal.signal(signal.SIGALRM, previous_handler)
+
+
+def _handle_signal(signum: int, frame):
+    # a second signal should not interrupt the cleanup (e.g. saving the cache)
+    signal.signal(signal.SIGINT, signal.SIG_IGN)
+    signal.signal(signal.SIGTERM, signal.SIG_IGN)
+    raise ShutdownRequested(signum)
+
+
+def install_handlers():
+    signal.signal(signal.SIGINT, _handle_signal)
+    signal.signal(signal.SIGTERM, _handle_signal)
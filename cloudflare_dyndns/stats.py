@@ -0,0 +1,98 @@
+import contextlib
+import threading
+import time
+from typing import Dict
+from pydantic import BaseModel
+from . import printer
+
+
+class CallStats(BaseModel):
+    count: int = 0
+    errors: int = 0
+    total_seconds: float = 0.0
+
+    def add(self, other: "CallStats"):
+        self.count += other.count
+        self.errors += other.errors
+        self.total_seconds += other.total_seconds
+
+
+class RunStats(BaseModel):
+    """Number and duration of outgoing calls during a run, grouped by
+    category (e.g. "cloudflare") and operation (e.g. "GET zones").
+    """
+
+    calls: Dict[str, Dict[str, CallStats]] = {}
+    # domains which didn't need an API call, because they were up-to-date in the cache
+    cache_hits: int = 0
+
+    def record(self, category: str, operation: str, seconds: float, failed: bool):
+        operations = self.calls.setdefault(category, {})
+        call_stats = operations.setdefault(operation, CallStats())
+        call_stats.count += 1
+        call_stats.errors += int(failed)
+        call_stats.total_seconds += seconds
+
+    def total(self, category: str) -> CallStats:
+        total = CallStats()
+        for call_stats in self.calls.get(category, {}).values():
+            total.add(call_stats)
+        return total
+
+
+_stats = RunStats()
+_lock = threading.Lock()
+
+
+def get() -> RunStats:
+    return _stats
+
+
+def reset():
+    global _stats
+    _stats = RunStats()
+
+
+@contextlib.contextmanager
+def timed(category: str, operation: str):
+    start = time.monotonic()
+    failed = False
+    try:
+        yield
+    except BaseException:
+        failed = True
+        raise
+    finally:
+        with _lock:
+            _stats.record(category, operation, time.monotonic() - start, failed)
+
+
+def cache_hit(count: int = 1):
+    with _lock:
+        _stats.cache_hits += count
+
+
+CATEGORY_NAMES = {"cloudflare": "Cloudflare API", "ip_services": "IP services"}
+
+
+def print_summary(debug: bool = False):
+    parts = []
+    for category in _stats.calls:
+        total = _stats.total(category)
+        name = CATEGORY_NAMES.get(category, category)
+        part = f"{name}: {total.count} calls in {total.total_seconds:.2f}s"
+        if total.errors:
+            part += f" ({total.errors} failed)"
+        parts.append(part)
+    if not parts:
+        parts.append("no API calls")
+    parts.append(f"{_stats.cache_hits} domains up-to-date in cache")
+    printer.info("Request statistics: " + ", ".join(parts))
+
+    if debug:
+        for category, operations in _stats.calls.items():
+            for operation, call_stats in operations.items():
+                printer.info(
+                    f"  {category} {operation}: {call_stats.count} calls, "
+                    f"{call_stats.errors} failed, {call_stats.total_seconds:.3f}s"
+                )
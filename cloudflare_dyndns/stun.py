@@ -0,0 +1,91 @@
+import ipaddress
+import os
+import socket
+import struct
+from . import binding
+
+
+BINDING_REQUEST = 0x0001
+BINDING_SUCCESS = 0x0101
+MAGIC_COOKIE = 0x2112A442
+
+MAPPED_ADDRESS = 0x0001
+XOR_MAPPED_ADDRESS = 0x0020
+
+DEFAULT_SERVER = "stun.l.google.com:19302"
+STUN_PORT = 3478
+
+
+class STUNError(Exception):
+    pass
+
+
+def parse_server(server: str):
+    host, _, port = server.rpartition(":")
+    if not host or not port.isdigit():
+        return server.strip("[]"), STUN_PORT
+    return host.strip("[]"), int(port)
+
+
+def _parse_address(value: bytes, xor: bool, transaction_id: bytes) -> str:
+    family = value[1]
+    address = value[4:]
+    if xor:
+        key = struct.pack("!I", MAGIC_COOKIE) + transaction_id
+        address = bytes(a ^ k for a, k in zip(address, key))
+    if family == 0x01:
+        return str(ipaddress.IPv4Address(address[:4]))
+    return str(ipaddress.IPv6Address(address[:16]))
+
+
+def parse_response(message: bytes, transaction_id: bytes) -> str:
+    message_type, length, cookie = struct.unpack("!HHI", message[:8])
+    if message_type != BINDING_SUCCESS or cookie != MAGIC_COOKIE:
+        raise STUNError("Not a STUN binding success response")
+    if message[8:20] != transaction_id:
+        raise STUNError("Response for a different request")
+
+    mapped_address = None
+    offset = 20
+    while offset + 4 <= 20 + length:
+        attribute_type, attribute_length = struct.unpack(
+            "!HH", message[offset : offset + 4]
+        )
+        value = message[offset + 4 : offset + 4 + attribute_length]
+        if attribute_type == XOR_MAPPED_ADDRESS:
+            return _parse_address(value, True, transaction_id)
+        elif attribute_type == MAPPED_ADDRESS:
+            mapped_address = _parse_address(value, False, transaction_id)
+        # attributes are padded to 4 bytes
+        offset += 4 + (attribute_length + 3) // 4 * 4
+
+    if mapped_address is None:
+        raise STUNError("No mapped address in the STUN response")
+    return mapped_address
+
+
+def get_mapped_address(
+    server: str = DEFAULT_SERVER, family: int = socket.AF_INET, timeout: float = 5
+) -> str:
+    """Asks a STUN server (RFC5389) which address our requests come from."""
+    host, port = parse_server(server)
+    try:
+        address_info = binding.getaddrinfo(host, port, family, socket.SOCK_DGRAM)
+        server_address = address_info[0][4]
+    except socket.gaierror as e:
+        raise STUNError(f"Cannot resolve {host}: {e}")
+
+    transaction_id = os.urandom(12)
+    request = struct.pack("!HHI", BINDING_REQUEST, 0, MAGIC_COOKIE) + transaction_id
+    with socket.socket(family, socket.SOCK_DGRAM) as sock:
+        sock.settimeout(timeout)
+        try:
+            binding.bind(sock)
+            sock.sendto(request, server_address)
+            message, _ = sock.recvfrom(2048)
+        except OSError as e:
+            raise STUNError(f"No response from {server}: {e}")
+    try:
+        return parse_response(message, transaction_id)
+    except (struct.error, ValueError) as e:
+        raise STUNError(f"Invalid response from {server}: {e}")
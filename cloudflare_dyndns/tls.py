@@ -0,0 +1,64 @@
+import functools
+import ssl
+from pathlib import Path
+from typing import Union
+import requests
+import urllib3
+from . import printer
+
+
+# a CA bundle path, or False for not verifying certificates at all
+_verify: Union[str, bool] = True
+
+
+def _verified_send(send):
+    @functools.wraps(send)
+    def verified_send(session, request: requests.PreparedRequest, **kwargs):
+        kwargs["verify"] = _verify
+        return send(session, request, **kwargs)
+
+    verified_send.is_verified = True
+    return verified_send
+
+
+def _patch_send():
+    send = requests.Session.send
+    if getattr(send, "is_verified", False):
+        return
+    requests.Session.send = _verified_send(send)
+
+
+def ssl_context() -> ssl.SSLContext:
+    """For connections not made with requests, with the same settings."""
+    if _verify is False:
+        context = ssl.create_default_context()
+        context.check_hostname = False
+        context.verify_mode = ssl.CERT_NONE
+        return context
+    ca_cert = _verify if isinstance(_verify, str) else None
+    return ssl.create_default_context(cafile=ca_cert)
+
+
+def use_ca_cert(ca_cert: str):
+    """Verify the certificates of every HTTPS request made through the requests
+    library with this CA bundle instead of the system one, e.g. for networks
+    with TLS intercepting middleboxes.
+    """
+    global _verify
+    if not Path(ca_cert).is_file():
+        raise ValueError(f"CA certificate file {ca_cert} doesn't exist")
+    _verify = ca_cert
+    _patch_send()
+
+
+def skip_verify():
+    global _verify
+    printer.warning(
+        "TLS CERTIFICATE VERIFICATION IS DISABLED! Anybody on the network path can "
+        "impersonate the Cloudflare API and the IP services, steal the API token "
+        "and point your domains anywhere. Use --ca-cert instead if you can."
+    )
+    # the warning above is enough, not for every request again
+    urllib3.disable_warnings(urllib3.exceptions.InsecureRequestWarning)
+    _verify = False
+    _patch_send()
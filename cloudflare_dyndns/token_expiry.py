@@ -0,0 +1,44 @@
+import datetime
+import time
+from typing import Optional
+from .cache import Cache
+from .providers import DNSProvider
+from . import printer
+
+
+# the expiry date rarely changes, no need to ask the provider on every run
+CHECK_INTERVAL = 24 * 60 * 60
+
+
+def check_token_expiry(
+    provider: DNSProvider, cache: Cache, warning_days: int
+) -> Optional[datetime.datetime]:
+    """Returns when the credentials expire, if it's in less than warning_days.
+    Checks at most once a day, failures are not considered errors, the update
+    itself will tell when the credentials don't work.
+    """
+    now = time.time()
+    if cache.last_token_check and now - cache.last_token_check < CHECK_INTERVAL:
+        return None
+
+    cache.last_token_check = now
+    try:
+        expires_on = provider.credentials_expire_on()
+    except Exception as e:
+        printer.info(f"Failed to check the expiry of the API token: {e}")
+        return None
+    if expires_on is None:
+        return None
+
+    remaining = expires_on.timestamp() - now
+    if remaining > warning_days * 24 * 60 * 60:
+        return None
+    elif remaining <= 0:
+        printer.error(f"The API token expired on {expires_on:%Y-%m-%d %H:%M %Z}!")
+    else:
+        printer.warning(
+            f"The API token expires on {expires_on:%Y-%m-%d %H:%M %Z}, in "
+            f"{remaining / (24 * 60 * 60):.0f} days, replace it before that!",
+            expires_on=expires_on.isoformat(),
+        )
+    return expires_on
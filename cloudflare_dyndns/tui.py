@@ -0,0 +1,106 @@
+"""Full screen live view of the daemon for an attended terminal: the current
+addresses, the status of every domain, the time until the next check and the
+latest messages, redrawn every second.
+"""
+import datetime
+import shutil
+import threading
+import time
+from typing import List, Optional
+import click
+from .progress import summary_table
+from . import printer
+
+
+REFRESH_INTERVAL = 1.0
+# switch to the alternate screen of the terminal, like full screen programs do,
+# so the shell history is intact after exiting
+ENTER_SCREEN = "\x1b[?1049h\x1b[?25l"
+EXIT_SCREEN = "\x1b[?25h\x1b[?1049l"
+HOME_AND_CLEAR = "\x1b[H\x1b[2J"
+
+
+def format_countdown(seconds: float) -> str:
+    seconds = max(int(seconds), 0)
+    minutes, seconds = divmod(seconds, 60)
+    hours, minutes = divmod(minutes, 60)
+    if hours:
+        return f"{hours}h {minutes:02}m {seconds:02}s"
+    return f"{minutes}m {seconds:02}s"
+
+
+def render(daemon, updater, theme: printer.Theme, height: int, width: int) -> List[str]:
+    title = f"cloudflare-dyndns - checking every {daemon.interval} seconds"
+    if daemon.paused:
+        title += " (paused)"
+    lines = [click.style(title, bold=True), ""]
+
+    report = daemon.last_report
+    for record_type, family in (("A", "IPv4"), ("AAAA", "IPv6")):
+        result = report.get_result(record_type) if report else None
+        if result is not None and result.new_ip is not None:
+            lines.append(f"{family}:  {result.new_ip} {result.location}".rstrip())
+        elif updater.domains_for(record_type):
+            lines.append(f"{family}:  -")
+
+    if daemon.last_run is not None:
+        last_run = datetime.datetime.fromtimestamp(daemon.last_run)
+        lines.append(f"Last check:  {last_run:%H:%M:%S}, {report.status}")
+    if daemon.next_run is None:
+        lines.append("Next check:  now")
+    elif daemon.paused:
+        lines.append("Next check:  paused")
+    else:
+        countdown = format_countdown(daemon.next_run - time.time())
+        lines.append(f"Next check:  in {countdown}")
+    lines.append("")
+
+    if report is not None:
+        domains_by_type = {rt: updater.domains_for(rt) for rt in ("A", "AAAA")}
+        table = summary_table(report, domains_by_type, updater.domain_links)
+        if table:
+            lines.append(click.style(table[0], bold=True))
+            lines.extend(table[1:])
+            lines.append("")
+
+    lines.append(click.style("Events", bold=True))
+    # the newest messages which fit on the screen
+    room = max(height - len(lines), 0)
+    events = [event for event in printer.recent_messages() if event[2]]
+    for timestamp, level, message in events[-room:] if room else []:
+        line = f"{timestamp:%H:%M:%S} {message}"[:width]
+        lines.append(click.style(line, fg=theme.color(level)))
+    return lines
+
+
+class LiveView:
+    def __init__(self, daemon, updater, theme: Optional[printer.Theme] = None):
+        self._daemon = daemon
+        self._updater = updater
+        self._theme = theme or printer.Theme()
+        self._stopped = threading.Event()
+        self._thread: Optional[threading.Thread] = None
+
+    def _draw(self):
+        width, height = shutil.get_terminal_size()
+        lines = render(self._daemon, self._updater, self._theme, height - 1, width)
+        click.echo(HOME_AND_CLEAR + "\n".join(lines), nl=False)
+
+    def _loop(self):
+        while not self._stopped.is_set():
+            self._draw()
+            self._stopped.wait(REFRESH_INTERVAL)
+
+    def start(self):
+        # messages only go to the event log, they would scroll the view away
+        printer.set_target("none")
+        click.echo(ENTER_SCREEN, nl=False)
+        self._thread = threading.Thread(target=self._loop, daemon=True)
+        self._thread.start()
+
+    def stop(self):
+        self._stopped.set()
+        if self._thread is not None:
+            self._thread.join()
+        click.echo(EXIT_SCREEN, nl=False)
+        printer.set_target("console", theme=self._theme)
@@ -0,0 +1,78 @@
+import re
+import time
+from importlib import metadata
+from typing import Optional, Tuple
+import requests
+from .cache import Cache
+from .printer import APP_NAME
+from . import printer
+
+
+RELEASES_URL = "https://api.github.com/repos/kissgyorgy/cloudflare-dyndns/releases/latest"
+RELEASES_PAGE = "https://github.com/kissgyorgy/cloudflare-dyndns/releases"
+
+# the unauthenticated GitHub API allows only 60 requests per hour
+CHECK_INTERVAL = 24 * 60 * 60
+
+VERSION_RE = re.compile(r"v?(?P<release>\d+(?:\.\d+)*)[-.]?(?P<pre>[a-z]*)(?P<num>\d*)")
+
+
+def parse_version(version: str) -> Tuple:
+    """Comparable form of versions like 4.0, v4.1.2 or 4.0-beta3,
+    where pre-releases come before the final release.
+    """
+    match = VERSION_RE.match(version.strip().lower())
+    if match is None:
+        raise ValueError(f"Invalid version: {version}")
+    release = [int(part) for part in match["release"].split(".")]
+    # 4.0 == 4.0.0
+    while len(release) > 1 and release[-1] == 0:
+        release.pop()
+    pre_release = (0, match["pre"], int(match["num"] or 0)) if match["pre"] else (1,)
+    return tuple(release), pre_release
+
+
+def installed_version() -> Optional[str]:
+    try:
+        return metadata.version(APP_NAME)
+    except metadata.PackageNotFoundError:
+        return None
+
+
+def get_latest_version() -> str:
+    response = requests.get(
+        RELEASES_URL, headers={"Accept": "application/vnd.github+json"}, timeout=10
+    )
+    response.raise_for_status()
+    return response.json()["tag_name"]
+
+
+def check_for_update(cache: Cache) -> Optional[str]:
+    """Returns the latest version when it's newer than the installed one.
+    Checks at most once a day, failures are not considered errors.
+    """
+    now = time.time()
+    if cache.last_update_check and now - cache.last_update_check < CHECK_INTERVAL:
+        return None
+    current = installed_version()
+    if current is None:
+        return None
+
+    cache.last_update_check = now
+    try:
+        latest = get_latest_version()
+        is_newer = parse_version(latest) > parse_version(current)
+    except Exception as e:
+        printer.info(f"Failed to check for new releases: {e}")
+        return None
+    if not is_newer:
+        return None
+
+    latest = latest.lstrip("v")
+    printer.warning(
+        f"A new version of {APP_NAME} is available: {latest} (installed: {current}), "
+        f"see {RELEASES_PAGE}",
+        latest_version=latest,
+        installed_version=current,
+    )
+    return latest
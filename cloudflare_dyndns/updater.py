@@ -0,0 +1,1122 @@
+import datetime
+import functools
+import threading
+import time
+from pathlib import Path
+from typing import (
+    Callable,
+    Collection,
+    Dict,
+    Iterable,
+    List,
+    Optional,
+    Sequence,
+    Tuple,
+    Union,
+)
+from .canary import Canary, CanaryError
+from .cache import CacheManager, Cache, DomainError, IPCache, InvalidCache
+from .domains import is_excluded, syntax_error
+from .ip_services import (
+    IPServiceError,
+    IPSource,
+    get_ipv4,
+    get_ipv6,
+    get_wan_ipv4s,
+    has_connectivity,
+)
+from .maintenance import InvalidMaintenanceFile, maintenance_file, read_maintenance
+from .notifiers import Notifier, send_notifications
+from .plan import Change, plan_record
+from .proxied_schedule import ProxiedSchedule, apply_schedules
+from .providers import (
+    AuthenticationError,
+    DNSProvider,
+    DNSProviderError,
+    RateLimitedError,
+)
+from .report import Report, UpdateResult
+from .runlock import LockedError, RunLock, lock_file
+from .token_expiry import check_token_expiry
+from .types import IPAddress, RecordType, get_record_type
+from .update_check import check_for_update
+from .geoip import GeoIPLookup, annotate
+from .history import adaptive_ttl, record_change
+from . import binding, breaker, build_info, metrics, printer, progress, stats
+
+
+# The smaller the exit code, the more specific the issue is
+EXIT_IP_SERVICE_ERROR = 1
+EXIT_CLOUDFLARE_ERROR = 2
+EXIT_UNKNOWN_ERROR = 3
+# some domains have been updated, but not all of them
+EXIT_PARTIAL_SUCCESS = 4
+# some domains are invalid or not in any zone, nothing has been updated
+EXIT_INVALID_DOMAINS = 5
+# another run with the same cache file is in progress
+EXIT_LOCKED = 6
+# every domain was up-to-date already, only with --exit-code-on-noop
+EXIT_NO_CHANGE = 7
+
+# seconds before the failed domains are tried again
+RETRY_DELAY = 5
+# seconds to wait for the parallel updates to stop when the run is interrupted
+STOP_TIMEOUT = 5
+
+
+class Updater:
+    """Updates the DNS records of the domains with the current IP address(es).
+    This is what the update command runs, and it can be embedded in other
+    programs the same way:
+
+        provider = CloudFlareWrapper(api_token)
+        updater = Updater(provider, ["example.com"], "/var/cache/dyndns/ip.cache")
+        report = updater.run()
+    """
+
+    def __init__(
+        self,
+        provider: DNSProvider,
+        domains: List[str],
+        cache_file: Union[str, Path],
+        *,
+        ipv4: bool = True,
+        ipv6: bool = False,
+        match_patterns: Sequence[str] = (),
+        exclude_patterns: Sequence[str] = (),
+        domain_record_types: Optional[Dict[str, Collection[RecordType]]] = None,
+        wan_sources: Sequence[List[IPSource]] = (),
+        domain_links: Optional[Dict[str, str]] = None,
+        fallback_ips: Optional[Dict[RecordType, IPAddress]] = None,
+        auto_family: bool = False,
+        ipv4_sources: Optional[List[IPSource]] = None,
+        ipv6_sources: Optional[List[IPSource]] = None,
+        proxied: bool = False,
+        domain_proxied: Optional[Dict[str, bool]] = None,
+        proxied_schedules: Optional[Dict[str, ProxiedSchedule]] = None,
+        canary: Optional[Canary] = None,
+        delete_missing: bool = False,
+        fail_fast: bool = False,
+        min_update_interval: Optional[int] = None,
+        verify_every_runs: Optional[int] = None,
+        verify_every_seconds: Optional[int] = None,
+        adaptive_ttl: Optional[Tuple[int, int]] = None,
+        report_file: Optional[str] = None,
+        check_for_updates: bool = False,
+        token_expiry_warning: Optional[int] = None,
+        notifiers: Sequence[Notifier] = (),
+        geoip: Optional[GeoIPLookup] = None,
+        show_progress: bool = False,
+        debug: bool = False,
+    ):
+        self.provider = provider
+        # these are never touched, even when they are listed or matched
+        self.exclude_patterns = exclude_patterns
+        domains = [d for d in domains if not is_excluded(d, exclude_patterns)]
+        self.domains = domains
+        self.match_patterns = match_patterns
+        # overrides ipv4 and ipv6 for these domains
+        self.domain_record_types = domain_record_types or {}
+        # one list of sources for each WAN link, for round-robin A records
+        self.wan_sources = wan_sources
+        # domains detected and updated through another interface or source address
+        self.domain_links = domain_links or {}
+        # published when the address can't be detected
+        self.fallback_ips = fallback_ips or {}
+        self._listed_domains = list(domains)
+        self.cache_file = Path(cache_file)
+        self.ipv4 = ipv4
+        self.ipv6 = ipv6
+        self.auto_family = auto_family
+        self.ipv4_sources = ipv4_sources
+        self.ipv6_sources = ipv6_sources
+        self.proxied = proxied
+        # overrides proxied for these domains
+        self.domain_proxied = domain_proxied or {}
+        # overrides domain_proxied depending on the time of the day
+        self.proxied_schedules = proxied_schedules or {}
+        # updated and checked before the other domains
+        self.canary = canary
+        self.delete_missing = delete_missing
+        self.fail_fast = fail_fast
+        self.min_update_interval = min_update_interval
+        # compare the cached records with the ones at the provider this often
+        self.verify_every_runs = verify_every_runs
+        self.verify_every_seconds = verify_every_seconds
+        # the lowest and highest TTL, which follows how often the address changes
+        self.adaptive_ttl = adaptive_ttl
+        self.report_file = report_file
+        self.check_for_updates = check_for_updates
+        # days before the expiry of the API token to start warning, None to never
+        self.token_expiry_warning = token_expiry_warning
+        self.notifiers = notifiers
+        self.geoip = geoip
+        # n/m progress and a table of the domains at the end
+        self.show_progress = show_progress
+        self.debug = debug
+        # only detect the addresses, the records are not touched while paused,
+        # e.g. during a DNS maintenance
+        self.paused = False
+        self._preflight_passed = False
+
+    def run(
+        self,
+        force: bool = False,
+        addresses: Optional[Dict[RecordType, IPAddress]] = None,
+    ) -> Report:
+        """Runs one update. Given addresses are used instead of detecting them."""
+        stats.reset()
+        if self.match_patterns:
+            try:
+                self.domains = self._listed_domains + self.find_matching_domains()
+            except DNSProviderError as e:
+                printer.error(f"Failed to list the records matching --match: {e}")
+                auth_failed = isinstance(e, AuthenticationError)
+                return Report(exit_code=EXIT_CLOUDFLARE_ERROR, auth_failed=auth_failed)
+        if not self._preflight_passed:
+            try:
+                problems = self.preflight()
+            except AuthenticationError as e:
+                printer.error(f"Nothing is updated: {e}")
+                return Report(exit_code=EXIT_CLOUDFLARE_ERROR, auth_failed=True)
+            if problems:
+                printer.error("Nothing is updated, because of these domains:")
+                for problem in problems:
+                    printer.error(f"  {problem}")
+                return Report(exit_code=EXIT_INVALID_DOMAINS)
+            self._preflight_passed = True
+
+        lock = RunLock(lock_file(self.cache_file))
+        try:
+            lock.acquire()
+        except LockedError as e:
+            printer.error(str(e))
+            return Report(exit_code=EXIT_LOCKED)
+        try:
+            return self._update(force, addresses)
+        finally:
+            lock.release()
+
+    def _update(
+        self, force: bool, addresses: Optional[Dict[RecordType, IPAddress]]
+    ) -> Report:
+        cache_manager, cache = load_cache(self.cache_file, force)
+        breaker.use(cache.ip_sources)
+        progress.enable(self.show_progress)
+
+        report = Report(build=build_info.get())
+        report.proxied_change_at = apply_schedules(
+            self.proxied_schedules, self.domain_proxied, datetime.datetime.now()
+        )
+        if self.check_for_updates:
+            report.available_update = check_for_update(cache)
+        if self.token_expiry_warning is not None:
+            report.token_expires_on = check_token_expiry(
+                self.provider, cache, self.token_expiry_warning
+            )
+        if self._verification_due(cache):
+            self.verify_cache(cache)
+        exit_codes = set()
+        report.maintenance = self._maintenance_addresses()
+        get_ipv4_func, get_ipv6_func = self._ip_funcs(report.maintenance)
+        if report.maintenance:
+            # the static addresses win over the detected and the received ones
+            addresses = {**(addresses or {}), **report.maintenance}
+        domains_by_type = {
+            record_type: [
+                domain
+                for domain in self.domains_for(record_type)
+                if domain not in self.domain_links
+            ]
+            for record_type in ("A", "AAAA")
+        }
+        ip_methods = [(get_ipv4_func, cache.ipv4, "A")] if domains_by_type["A"] else []
+        if domains_by_type["AAAA"]:
+            ip_methods.append((get_ipv6_func, cache.ipv6, "AAAA"))
+
+        stop = threading.Event()
+        unfinished: List[str] = []
+        try:
+            flows = {}
+            for ip_func, ip_cache, record_type in ip_methods:
+                received_ip = (addresses or {}).get(record_type)
+                if received_ip is not None:
+                    if record_type not in report.maintenance:
+                        printer.info(f"Using the received IP address: {received_ip}")
+                    ip_func = functools.partial(_static, received_ip)
+                result = UpdateResult(record_type=record_type, old_ip=ip_cache.address)
+                report.results.append(result)
+                flow = functools.partial(
+                    self._update_family,
+                    ip_func,
+                    ip_cache,
+                    result,
+                    domains_by_type[record_type],
+                    force,
+                    received_ip is not None,
+                    stop,
+                )
+                flows["IPv4" if record_type == "A" else "IPv6"] = flow
+            flow_exit_codes = self._run_flows(flows, stop, unfinished)
+            exit_codes.update(flow_exit_codes)
+            if not self.fail_fast or not any(flow_exit_codes):
+                exit_codes.update(
+                    self._update_links(
+                        get_ipv4_func, get_ipv6_func, cache, report, force
+                    )
+                )
+        finally:
+            printer.info()
+            if unfinished:
+                # they are still changing it
+                printer.warning(
+                    f"Not saving the cache, the {' and '.join(unfinished)} update "
+                    "did not stop in time."
+                )
+            else:
+                # save the state of already updated domains even when interrupted
+                cache_manager.save(cache)
+            printer.info()
+
+        stats.print_summary(self.debug)
+        printer.info()
+        if self.show_progress:
+            all_domains_by_type = {rt: self.domains_for(rt) for rt in ("A", "AAAA")}
+            table = progress.summary_table(
+                report, all_domains_by_type, self.domain_links
+            )
+            for line in table:
+                printer.info(line)
+            if table:
+                printer.info()
+
+        metrics.incr("runs")
+        exit_codes.discard(0)
+        report.exit_code = min(exit_codes, default=0)
+        report.auth_failed = any(result.auth_failed for result in report.results)
+        report.rate_limited = any(result.rate_limited for result in report.results)
+        report.token_rotated = self.provider.credentials_rotated()
+        report.stats = stats.get()
+        if self.geoip is not None:
+            for result in report.results:
+                if result.changed:
+                    result.geo = annotate(self.geoip, result.new_ip)
+        if self.report_file:
+            Path(self.report_file).write_text(report.to_json())
+        send_notifications(self.notifiers, report)
+
+        if not exit_codes:
+            printer.success("Done.")
+        elif report.exit_code == EXIT_PARTIAL_SUCCESS:
+            printer.warning("Some of the domains could not be updated.")
+        else:
+            printer.warning("There were some errors during update.")
+
+        return report
+
+    def _run_flows(
+        self,
+        flows: Dict[str, Callable[[], int]],
+        stop: threading.Event,
+        unfinished: List[str],
+    ) -> List[int]:
+        """The IPv4 and IPv6 records are updated at the same time, so slow IP
+        services of one family don't hold up the other. With --fail-fast they
+        are updated one after the other, so the first error stops the run.
+
+        Each flow changes only its own part of the cache, even the circuit
+        breaker states are per IP service URL, but they share the provider, the
+        zone cache, the backups, the stats and the printer, which lock their own
+        state. The signals are only raised in the main thread, so
+        on SIGTERM or --deadline the flows are told to stop after the current
+        domain, and the ones which don't stop in time are put in unfinished,
+        because they might still change the cache.
+        """
+        if self.fail_fast or len(flows) < 2:
+            exit_codes = []
+            for flow in flows.values():
+                exit_codes.append(flow())
+                if self.fail_fast and exit_codes[-1] != 0:
+                    break
+            return exit_codes
+
+        exit_codes: Dict[str, int] = {}
+        errors: List[BaseException] = []
+
+        def run(family: str, flow: Callable[[], int]):
+            try:
+                with printer.task(family):
+                    exit_codes[family] = flow()
+            except BaseException as e:
+                errors.append(e)
+
+        # daemon threads don't keep the process alive, when one hangs until the
+        # deadline
+        threads = [
+            threading.Thread(target=run, args=item, name=item[0], daemon=True)
+            for item in flows.items()
+        ]
+        for thread in threads:
+            thread.start()
+        try:
+            for thread in threads:
+                thread.join()
+        except BaseException:
+            stop.set()
+            for thread in threads:
+                thread.join(STOP_TIMEOUT)
+            unfinished.extend(thread.name for thread in threads if thread.is_alive())
+            raise
+        # an unexpected error of either one is raised after both are done
+        if errors:
+            raise errors[0]
+        return [exit_codes[family] for family in flows]
+
+    def _update_family(
+        self,
+        ip_func: Callable,
+        ip_cache: IPCache,
+        result: UpdateResult,
+        domains: List[str],
+        force: bool,
+        received: bool,
+        stop: Optional[threading.Event] = None,
+    ) -> int:
+        record_type = result.record_type
+        if not received and self._no_connectivity(record_type):
+            result.skipped = True
+            return 0
+        if record_type == "A" and self.wan_sources and not received:
+            exit_code = handle_multi_wan_update(
+                self.wan_sources,
+                self.provider,
+                domains,
+                force,
+                ip_cache,
+                self.proxied,
+                result,
+                self.domain_proxied,
+                self.paused,
+                stop,
+            )
+        else:
+            exit_code = handle_update(
+                ip_func,
+                self.delete_missing,
+                record_type,
+                self.provider,
+                domains,
+                force,
+                ip_cache,
+                self.debug,
+                self.proxied,
+                result,
+                self.fail_fast,
+                self.min_update_interval,
+                self.fallback_ips.get(record_type),
+                self.domain_proxied,
+                self.adaptive_ttl,
+                self.paused,
+                self.canary,
+                stop,
+            )
+        collect_errors(result, ip_cache, domains)
+        return exit_code
+
+    def _update_links(self, get_ipv4_func, get_ipv6_func, cache, report, force):
+        """Detects the address through each link separately, for the domains
+        mapped to it, so they point to that WAN connection.
+        """
+        exit_codes = set()
+        ip_methods = [
+            (get_ipv4_func, cache.link_ipv4, "A"),
+            (get_ipv6_func, cache.link_ipv6, "AAAA"),
+        ]
+        for link in sorted(set(self.domain_links.values())):
+            for ip_func, link_caches, record_type in ip_methods:
+                domains = [
+                    domain
+                    for domain in self.domains_for(record_type)
+                    if self.domain_links.get(domain) == link
+                ]
+                if not domains:
+                    continue
+                ip_cache = link_caches.setdefault(link, IPCache())
+                result = UpdateResult(
+                    record_type=record_type, old_ip=ip_cache.address, link=link
+                )
+                report.results.append(result)
+                printer.info()
+                printer.info(f"Detecting the address through {link}")
+                exit_code = handle_update(
+                    _detect_through(link, ip_func),
+                    self.delete_missing,
+                    record_type,
+                    self.provider,
+                    domains,
+                    force,
+                    ip_cache,
+                    self.debug,
+                    self.proxied,
+                    result,
+                    self.fail_fast,
+                    self.min_update_interval,
+                    self.fallback_ips.get(record_type),
+                    self.domain_proxied,
+                    self.adaptive_ttl,
+                    self.paused,
+                    self.canary,
+                )
+                collect_errors(result, ip_cache, domains)
+                exit_codes.add(exit_code)
+                if self.fail_fast and exit_code != 0:
+                    return exit_codes
+        return exit_codes
+
+    def _verification_due(self, cache: Cache) -> bool:
+        if self.verify_every_runs is not None:
+            cache.runs_since_verification += 1
+            return cache.runs_since_verification >= self.verify_every_runs
+        elif self.verify_every_seconds is not None:
+            if cache.last_verification is None:
+                return True
+            return time.time() - cache.last_verification >= self.verify_every_seconds
+        return False
+
+    def verify_cache(self, cache: Cache):
+        """Forgets the cached records which were edited or deleted at the provider
+        since they were updated, so they are updated again in this run.
+        """
+        printer.info("Verifying the cached records.")
+        ip_caches = [cache.ipv4, cache.ipv6]
+        ip_caches += [*cache.link_ipv4.values(), *cache.link_ipv6.values()]
+        for ip_cache in ip_caches:
+            if ip_cache.address is None:
+                continue
+            for domain, zone_record in list(ip_cache.updated_domains.items()):
+                # the round-robin record sets have no single record
+                if not zone_record.record_id:
+                    continue
+                try:
+                    valid = self.provider.verify_record(
+                        domain, ip_cache.address, zone_record
+                    )
+                except AuthenticationError as e:
+                    printer.warning(f"Failed to verify the cached records: {e}")
+                    return
+                except DNSProviderError as e:
+                    printer.warning(f'Failed to verify "{domain}": {e}')
+                    continue
+                if not valid:
+                    printer.warning(
+                        f'The record of "{domain}" was changed, updating it again.',
+                        domain=domain,
+                    )
+                    del ip_cache.updated_domains[domain]
+        cache.last_verification = time.time()
+        cache.runs_since_verification = 0
+
+    def domains_for(self, record_type: RecordType) -> List[str]:
+        """The domains which should have a record of this type."""
+        enabled = self.ipv4 if record_type == "A" else self.ipv6
+        domains = []
+        for domain in self.domains:
+            if domain in self.domain_record_types:
+                if record_type in self.domain_record_types[domain]:
+                    domains.append(domain)
+            elif enabled:
+                domains.append(domain)
+        return domains
+
+    def find_matching_domains(self) -> List[str]:
+        """Existing records matching the patterns, looked up in every run,
+        so new records are picked up without changing the configuration.
+        """
+        domains = []
+        for pattern in self.match_patterns:
+            matching = self.provider.find_domains(pattern)
+            if not matching:
+                printer.warning(f'No records match "{pattern}".')
+            for domain in matching:
+                if is_excluded(domain, self.exclude_patterns):
+                    continue
+                if domain not in domains and domain not in self._listed_domains:
+                    domains.append(domain)
+        printer.info("Domains matching the patterns: " + (", ".join(domains) or "none"))
+        return domains
+
+    def preflight(self) -> List[str]:
+        """Checks the syntax of every domain first, then whether the provider has
+        a zone for them, and returns all the problems at once.
+        """
+        problems = []
+        for domain in self.domains:
+            error = syntax_error(domain)
+            if error:
+                problems.append(f'"{domain}": {error}')
+        # no requests with names which are invalid anyway
+        if problems:
+            return problems
+
+        for domain in self.domains:
+            try:
+                self.provider.check_zone(domain)
+            except AuthenticationError:
+                # not a problem of the domain, every check would fail the same way
+                raise
+            except DNSProviderError as e:
+                problems.append(f'"{domain}": {e}')
+        return problems
+
+    def _ip_funcs(
+        self, maintenance: Dict[RecordType, IPAddress]
+    ) -> Tuple[Callable, Callable]:
+        get_ipv4_func, get_ipv6_func = get_ipv4, get_ipv6
+        if self.ipv4_sources:
+            get_ipv4_func = functools.partial(get_ipv4, self.ipv4_sources)
+        if self.ipv6_sources:
+            get_ipv6_func = functools.partial(get_ipv6, self.ipv6_sources)
+        if "A" in maintenance:
+            get_ipv4_func = functools.partial(_static, maintenance["A"])
+        if "AAAA" in maintenance:
+            get_ipv6_func = functools.partial(_static, maintenance["AAAA"])
+        return get_ipv4_func, get_ipv6_func
+
+    def plan(self) -> List[Change]:
+        """What a forced run would change, without writing anything."""
+        if self.match_patterns:
+            self.domains = self._listed_domains + self.find_matching_domains()
+        apply_schedules(
+            self.proxied_schedules, self.domain_proxied, datetime.datetime.now()
+        )
+        maintenance = self._maintenance_addresses()
+        get_ipv4_func, get_ipv6_func = self._ip_funcs(maintenance)
+        changes = []
+        for record_type, ip_func in (("A", get_ipv4_func), ("AAAA", get_ipv6_func)):
+            domains = self.domains_for(record_type)
+            if not domains or self._no_connectivity(record_type):
+                continue
+            for link in sorted({self.domain_links.get(d, "") for d in domains}):
+                linked = [d for d in domains if self.domain_links.get(d, "") == link]
+                detect = _detect_through(link, ip_func) if link else ip_func
+                addresses = self._planned_addresses(record_type, detect, link)
+                if addresses is None:
+                    continue
+                for domain in linked:
+                    proxied = self.domain_proxied.get(domain, self.proxied)
+                    change = plan_record(
+                        self.provider, domain, record_type, addresses, proxied
+                    )
+                    if change is not None:
+                        changes.append(change)
+        return changes
+
+    def _planned_addresses(
+        self, record_type: RecordType, ip_func: Callable, link: str
+    ) -> Optional[List[str]]:
+        """None when the records would be left alone."""
+        if record_type == "A" and self.wan_sources and not link:
+            wan_addresses = get_wan_ipv4s(self.wan_sources)
+            if not wan_addresses:
+                printer.error("Couldn't determine the address of any WAN link.")
+                return None
+            return [str(address) for address in wan_addresses]
+        try:
+            return [str(ip_func())]
+        except IPServiceError as e:
+            printer.error(str(e))
+            if record_type in self.fallback_ips:
+                return [str(self.fallback_ips[record_type])]
+            return [] if self.delete_missing else None
+
+    def _maintenance_addresses(self) -> Dict[RecordType, IPAddress]:
+        try:
+            addresses = read_maintenance(maintenance_file(self.cache_file))
+        except InvalidMaintenanceFile as e:
+            printer.error(f"{e}, ignoring it.")
+            return {}
+        for record_type, address in addresses.items():
+            printer.warning(
+                f"Maintenance mode is on, the records point to {address}.",
+                record_type=record_type,
+            )
+        return addresses
+
+    def _no_connectivity(self, record_type: RecordType) -> bool:
+        version = 4 if record_type == "A" else 6
+        if not self.auto_family or has_connectivity(version):
+            return False
+        printer.info()
+        printer.info(
+            f"No IPv{version} connectivity, skipping the {record_type} records.",
+            record_type=record_type,
+        )
+        return True
+
+
+def get_domains(
+    provider: DNSProvider,
+    domains: List[str],
+    force: bool,
+    current_ip: IPAddress,
+    ip_cache: IPCache,
+    proxied: bool,
+    domain_proxied: Optional[Dict[str, bool]] = None,
+    ttl: Optional[int] = None,
+):
+    domain_proxied = domain_proxied or {}
+    if force:
+        printer.warning("Forced update, ignoring cache")
+
+    elif current_ip == ip_cache.address:
+        updated_domains = {
+            d
+            for d, zone_record in ip_cache.updated_domains.items()
+            if zone_record.proxied is domain_proxied.get(d, proxied)
+            # the others don't store the TTL, their records would never match
+            and (ttl is None or not provider.supports_ttl(d) or zone_record.ttl == ttl)
+        }
+
+        updated_domains_list = ", ".join(updated_domains)
+        if updated_domains:
+            printer.success(
+                f"Domains with this IP address in cache: {updated_domains_list}"
+            )
+        else:
+            printer.info("There are no domains with this IP address in cache.")
+
+        stats.cache_hit(len(set(domains) & updated_domains))
+        missing_domains = set(domains) - updated_domains
+        if not missing_domains:
+            printer.success(f"Every domain is up-to-date for {current_ip}.")
+            return None
+        else:
+            return missing_domains
+
+    ip_cache.address = current_ip
+    ip_cache.addresses = []
+    return domains
+
+
+def update_domain(
+    provider: DNSProvider,
+    domain: str,
+    ip_cache: IPCache,
+    current_ip: IPAddress,
+    proxied: bool,
+    ttl: Optional[int] = None,
+) -> bool:
+    cached = ip_cache.updated_domains.get(domain)
+    record_type = get_record_type(current_ip)
+    try:
+        zone_record = provider.ensure_record(
+            domain, current_ip, proxied, cached, ttl=ttl
+        )
+    except DNSProviderError as e:
+        record_error(ip_cache, domain, record_type, str(e))
+        if isinstance(e, (AuthenticationError, RateLimitedError)):
+            raise
+        return False
+
+    ip_cache.updated_domains[domain] = zone_record
+    clear_error(ip_cache, domain, record_type)
+    return True
+
+
+def record_error(ip_cache: IPCache, domain: str, record_type: RecordType, error: str):
+    ip_cache.last_errors[domain] = DomainError(message=error, time=time.time())
+    metrics.gauge("records.failing", 1, record_type=record_type, domain=domain)
+
+
+def clear_error(ip_cache: IPCache, domain: str, record_type: RecordType):
+    if ip_cache.last_errors.pop(domain, None) is not None:
+        metrics.gauge("records.failing", 0, record_type=record_type, domain=domain)
+
+
+def collect_errors(result: UpdateResult, ip_cache: IPCache, domains: Iterable[str]):
+    """The errors of the domains which are still failing, for the report."""
+    for domain in domains:
+        if domain in ip_cache.last_errors:
+            result.domain_errors[domain] = ip_cache.last_errors[domain]
+
+
+def handle_multi_wan_update(
+    wan_sources: Sequence[List[IPSource]],
+    provider: DNSProvider,
+    domains: List[str],
+    force: bool,
+    ip_cache: IPCache,
+    proxied: bool,
+    result: UpdateResult,
+    domain_proxied: Optional[Dict[str, bool]] = None,
+    paused: bool = False,
+    stop: Optional[threading.Event] = None,
+) -> int:
+    """Publishes one A record for every WAN link which is up (round-robin DNS),
+    adding and removing the records as the links come and go.
+    """
+    printer.info()
+    addresses = get_wan_ipv4s(wan_sources)
+    if not addresses:
+        message = "Couldn't determine the address of any WAN link."
+        metrics.incr("detection.failures", family="ipv4")
+        printer.error(message)
+        result.errors.append(message)
+        return EXIT_IP_SERVICE_ERROR
+
+    result.new_ip = addresses[0]
+    if paused:
+        report_paused(result, ", ".join(str(address) for address in addresses))
+        forget_records(ip_cache, domains)
+        return 0
+    domain_proxied = domain_proxied or {}
+    domains_to_update = domains
+    if force:
+        printer.warning("Forced update, ignoring cache")
+    elif addresses == ip_cache.addresses:
+        domains_to_update = [
+            domain
+            for domain in domains
+            if domain not in ip_cache.updated_domains
+            or ip_cache.updated_domains[domain].proxied
+            is not domain_proxied.get(domain, proxied)
+        ]
+        stats.cache_hit(len(domains) - len(domains_to_update))
+        if not domains_to_update:
+            addresses_list = ", ".join(str(address) for address in addresses)
+            printer.success(f"Every domain is up-to-date for {addresses_list}.")
+            return 0
+    ip_cache.address, ip_cache.addresses = addresses[0], addresses
+
+    domains_progress = progress.Progress(len(domains_to_update), "A")
+    for index, domain in enumerate(domains_to_update):
+        if stop is not None and stop.is_set():
+            # they must not be considered up-to-date with the new addresses
+            forget_records(ip_cache, domains_to_update[index:])
+            break
+        try:
+            if result.auth_failed:
+                # the credentials won't work for the other domains either
+                raise AuthenticationError("Not updated, because of the invalid token")
+            elif result.rate_limited:
+                raise RateLimitedError("Not updated, because of the rate limiting")
+            zone_record = provider.ensure_record_set(
+                domain, addresses, domain_proxied.get(domain, proxied)
+            )
+        except DNSProviderError as e:
+            if not result.auth_failed and not result.rate_limited:
+                printer.error(str(e))
+                result.errors.append(str(e))
+            result.auth_failed = result.auth_failed or isinstance(
+                e, AuthenticationError
+            )
+            result.rate_limited = result.rate_limited or isinstance(
+                e, RateLimitedError
+            )
+            result.failed_domains.append(domain)
+            ip_cache.updated_domains.pop(domain, None)
+            record_error(ip_cache, domain, "A", str(e))
+            metrics.incr("records.failed", record_type="A", domain=domain)
+            domains_progress.advance(domain, False)
+            continue
+        ip_cache.updated_domains[domain] = zone_record
+        clear_error(ip_cache, domain, "A")
+        result.updated_domains.append(domain)
+        metrics.incr("records.updated", record_type="A", domain=domain)
+        domains_progress.advance(domain, True)
+
+    if result.updated_domains:
+        ip_cache.last_update = time.time()
+    if not result.failed_domains:
+        return 0
+    elif result.updated_domains or len(domains_to_update) < len(domains):
+        return EXIT_PARTIAL_SUCCESS
+    return EXIT_CLOUDFLARE_ERROR
+
+
+def update_domains(
+    provider: DNSProvider,
+    domains: Iterable[str],
+    ip_cache: IPCache,
+    current_ip: IPAddress,
+    proxied: bool,
+    result: UpdateResult,
+    fail_fast: bool = False,
+    domain_proxied: Optional[Dict[str, bool]] = None,
+    canary: Optional[Canary] = None,
+    ttl: Optional[int] = None,
+    stop: Optional[threading.Event] = None,
+):
+    record_type = get_record_type(current_ip)
+    domain_proxied = domain_proxied or {}
+    domains = list(domains)
+    domains_progress = progress.Progress(len(domains), record_type)
+
+    def try_update(domain: str) -> bool:
+        domain_is_proxied = domain_proxied.get(domain, proxied)
+        try:
+            # the credentials won't work for the other domains either, and more
+            # requests only make the rate limiting last longer
+            stopped = result.auth_failed or result.rate_limited
+            updated = not stopped and update_domain(
+                provider, domain, ip_cache, current_ip, domain_is_proxied, ttl
+            )
+        except AuthenticationError as e:
+            printer.error(f"{e}, not updating the other domains.")
+            result.auth_failed = True
+            result.errors.append(str(e))
+            updated = False
+        except RateLimitedError as e:
+            printer.error(f"{e}, updating the other domains in the next run.")
+            result.rate_limited = True
+            result.errors.append(str(e))
+            updated = False
+        if not updated:
+            domains_progress.advance(domain, False)
+            return False
+        result.updated_domains.append(domain)
+        metrics.incr("records.updated", record_type=record_type, domain=domain)
+        domains_progress.advance(domain, True)
+        return True
+
+    failed_domains = []
+    canary_failed = False
+    if canary is not None and canary.domain in domains:
+        # the others only follow when the new address proved to be right
+        domains.remove(canary.domain)
+        canary_proxied = domain_proxied.get(canary.domain, proxied)
+        written = try_update(canary.domain)
+        try:
+            if not written:
+                # the error of the write is recorded already
+                raise CanaryError("the record could not be updated")
+            canary.verify(current_ip, canary_proxied)
+        except CanaryError as e:
+            message = f"Canary {canary.domain} failed: {e}, not updating the others."
+            printer.error(message, record_type=record_type)
+            result.errors.append(message)
+            if written:
+                # the record has the new address, but it doesn't work yet
+                result.updated_domains.remove(canary.domain)
+                record_error(
+                    ip_cache, canary.domain, record_type, f"Canary check failed: {e}"
+                )
+            # they are updated in the next run, if the canary works by then, so it
+            # must be checked again too
+            failed_domains = [canary.domain, *domains]
+            domains = []
+            canary_failed = True
+
+    for index, domain in enumerate(domains):
+        if stop is not None and stop.is_set():
+            failed_domains.extend(domains[index:])
+            break
+        if try_update(domain):
+            continue
+        failed_domains.append(domain)
+        if fail_fast:
+            printer.warning("Stopping at the first failed domain (--fail-fast).")
+            break
+
+    # most errors are transient, e.g. a timeout or rate limiting, so the failed
+    # domains get one more chance after the others
+    stopped = result.auth_failed or result.rate_limited
+    stopped = stopped or (stop is not None and stop.is_set())
+    retry = not fail_fast and not stopped and not canary_failed
+    if failed_domains and retry:
+        printer.info(f"Retrying the failed domains in {RETRY_DELAY} seconds.")
+        printer.flush()
+        if stop is None:
+            time.sleep(RETRY_DELAY)
+        # a stop during the delay or the retries leaves the rest for the next run
+        elif stop.wait(RETRY_DELAY):
+            retry = False
+        if retry:
+            failed_domains = [
+                domain
+                for domain in failed_domains
+                if (stop is not None and stop.is_set()) or not try_update(domain)
+            ]
+
+    for domain in failed_domains:
+        result.failed_domains.append(domain)
+        metrics.incr("records.failed", record_type=record_type, domain=domain)
+        # the record might still point to the old IP address, so it must not be
+        # considered up-to-date in the next run
+        ip_cache.updated_domains.pop(domain, None)
+
+    return not result.failed_domains
+
+
+def report_paused(result: UpdateResult, addresses: Union[IPAddress, str]):
+    result.paused = True
+    printer.warning(
+        f"IP address is {addresses}, but updates are paused, not touching the records.",
+        record_type=result.record_type,
+    )
+
+
+def forget_records(ip_cache: IPCache, domains: Iterable[str]):
+    """The records might be changed by hand while the updates are paused, so
+    they are checked at the provider again after resuming.
+    """
+    for domain in domains:
+        ip_cache.updated_domains.pop(domain, None)
+
+
+def is_too_soon(current_ip: IPAddress, ip_cache: IPCache, min_interval: int) -> bool:
+    """Whether a changed IP address came too soon after the last update."""
+    if ip_cache.address is None or current_ip == ip_cache.address:
+        return False
+    elif ip_cache.last_update is None:
+        return False
+    return time.time() - ip_cache.last_update < min_interval
+
+
+def load_cache(cache_file: Path, force: bool):
+    cache_manager = CacheManager(cache_file)
+    cache_manager.ensure_path()
+
+    if not force:
+        try:
+            return cache_manager, cache_manager.load()
+        except InvalidCache:
+            cache_manager.delete()
+
+    return cache_manager, Cache()
+
+
+def _static(address: IPAddress) -> IPAddress:
+    return address
+
+
+def _detect_through(link: str, get_ip_func: Callable) -> Callable:
+    """Only the detection goes through the link, the API calls don't."""
+
+    def get_ip():
+        with binding.bound(link):
+            return get_ip_func()
+
+    return get_ip
+
+
+def handle_update(
+    get_ip_func: Callable,
+    delete_missing: bool,
+    record_type: RecordType,
+    provider: DNSProvider,
+    domains: List[str],
+    force: bool,
+    ip_cache: IPCache,
+    debug: bool,
+    proxied: bool,
+    result: UpdateResult,
+    fail_fast: bool = False,
+    min_update_interval: Optional[int] = None,
+    fallback_ip: Optional[IPAddress] = None,
+    domain_proxied: Optional[Dict[str, bool]] = None,
+    ttl_range: Optional[Tuple[int, int]] = None,
+    paused: bool = False,
+    canary: Optional[Canary] = None,
+    stop: Optional[threading.Event] = None,
+):
+
+    printer.info()
+    family = "ipv4" if record_type == "A" else "ipv6"
+    detection_start = time.monotonic()
+    try:
+        current_ip = get_ip_func()
+    except IPServiceError as e:
+        metrics.incr("detection.failures", family=family)
+        printer.error(str(e))
+        if fallback_ip is not None:
+            # e.g. a server showing a maintenance page while the link is down
+            printer.warning(
+                f"Using the fallback address {fallback_ip} instead.",
+                record_type=record_type,
+            )
+            current_ip = fallback_ip
+        elif delete_missing and not paused:
+            result.errors.append(str(e))
+            for domain in domains:
+                provider.delete_record(domain, record_type)
+            ip_cache.clear()
+            # when the --delete-missing flag is specified, this is the expected behavior
+            # so there should be no error reported
+            return 0
+        else:
+            result.errors.append(str(e))
+            return EXIT_IP_SERVICE_ERROR
+    finally:
+        detection_time = (time.monotonic() - detection_start) * 1000
+        metrics.timing("detection.duration", detection_time, family=family)
+
+    result.new_ip = current_ip
+    record_change(ip_cache, current_ip)
+    ttl = None
+    if ttl_range is not None:
+        # passed down instead of set on the provider, which the flows share
+        ttl = adaptive_ttl(ip_cache.changes, *ttl_range)
+    if paused:
+        report_paused(result, current_ip)
+        forget_records(ip_cache, domains)
+        return 0
+    if (
+        min_update_interval
+        and not force
+        and is_too_soon(current_ip, ip_cache, min_update_interval)
+    ):
+        result.postponed_until = ip_cache.last_update + min_update_interval
+        seconds_ago = time.time() - ip_cache.last_update
+        printer.warning(
+            f"IP address changed to {current_ip}, but the last update was only "
+            f"{seconds_ago:.0f} seconds ago, postponing it (--min-update-interval).",
+            record_type=record_type,
+        )
+        return 0
+
+    try:
+        domains_to_update = get_domains(
+            provider, domains, force, current_ip, ip_cache, proxied, domain_proxied, ttl
+        )
+        if not domains_to_update:
+            return 0
+        success = update_domains(
+            provider,
+            domains_to_update,
+            ip_cache,
+            current_ip,
+            proxied,
+            result,
+            fail_fast,
+            domain_proxied,
+            canary,
+            ttl,
+            stop,
+        )
+        if result.updated_domains:
+            ip_cache.last_update = time.time()
+
+    except DNSProviderError as e:
+        printer.error(str(e))
+        result.errors.append(str(e))
+        result.auth_failed = isinstance(e, AuthenticationError)
+        result.rate_limited = isinstance(e, RateLimitedError)
+        if debug:
+            raise
+        return EXIT_CLOUDFLARE_ERROR
+
+    except Exception as e:
+        printer.error(f"Unknown error: {e}")
+        result.errors.append(f"Unknown error: {e}")
+        if debug:
+            raise
+        return EXIT_UNKNOWN_ERROR
+
+    if not success:
+        up_to_date_in_cache = len(domains) - len(domains_to_update)
+        if result.updated_domains or up_to_date_in_cache:
+            return EXIT_PARTIAL_SUCCESS
+        return EXIT_CLOUDFLARE_ERROR
+
+    return 0
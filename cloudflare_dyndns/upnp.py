@@ -0,0 +1,98 @@
+import socket
+import urllib.parse
+import xml.etree.ElementTree as ET
+from typing import Optional, Tuple
+import requests
+
+
+SSDP_ADDRESS = ("239.255.255.250", 1900)
+
+WAN_SERVICE_TYPES = (
+    "urn:schemas-upnp-org:service:WANIPConnection:1",
+    "urn:schemas-upnp-org:service:WANIPConnection:2",
+    "urn:schemas-upnp-org:service:WANPPPConnection:1",
+)
+
+SOAP_BODY = """<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" \
+s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:GetExternalIPAddress xmlns:u="{service_type}"/></s:Body>
+</s:Envelope>"""
+
+
+class UPnPError(Exception):
+    pass
+
+
+def _local_name(element: ET.Element) -> str:
+    return element.tag.rpartition("}")[2]
+
+
+def _find_text(element: ET.Element, name: str) -> Optional[str]:
+    for child in element.iter():
+        if _local_name(child) == name:
+            return (child.text or "").strip()
+    return None
+
+
+def discover(timeout: float = 3) -> str:
+    """Finds the router's description URL with SSDP."""
+    search = (
+        "M-SEARCH * HTTP/1.1\r\n"
+        "HOST: 239.255.255.250:1900\r\n"
+        'MAN: "ssdp:discover"\r\n'
+        "MX: 2\r\n"
+        "ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+    )
+    with socket.socket(socket.AF_INET, socket.SOCK_DGRAM) as sock:
+        sock.settimeout(timeout)
+        try:
+            sock.sendto(search.encode(), SSDP_ADDRESS)
+            response, _ = sock.recvfrom(4096)
+        except OSError as e:
+            raise UPnPError(f"No UPnP Internet Gateway Device found: {e}")
+
+    for line in response.decode(errors="replace").splitlines():
+        name, _, value = line.partition(":")
+        if name.strip().lower() == "location":
+            return value.strip()
+    raise UPnPError("The UPnP response has no location")
+
+
+def find_wan_service(location: str, timeout: float = 5) -> Tuple[str, str]:
+    """Returns the service type and control URL of the WAN connection."""
+    response = requests.get(location, timeout=timeout)
+    response.raise_for_status()
+    root = ET.fromstring(response.content)
+    base_url = _find_text(root, "URLBase") or location
+    for service in root.iter():
+        if _local_name(service) != "service":
+            continue
+        service_type = _find_text(service, "serviceType")
+        if service_type in WAN_SERVICE_TYPES:
+            control_url = _find_text(service, "controlURL") or ""
+            return service_type, urllib.parse.urljoin(base_url, control_url)
+    raise UPnPError("The router has no WAN connection service")
+
+
+def get_external_ip(timeout: float = 5) -> str:
+    """Asks the router about its external IPv4 address through UPnP IGD."""
+    try:
+        location = discover()
+        service_type, control_url = find_wan_service(location, timeout)
+        response = requests.post(
+            control_url,
+            data=SOAP_BODY.format(service_type=service_type),
+            headers={
+                "Content-Type": 'text/xml; charset="utf-8"',
+                "SOAPAction": f'"{service_type}#GetExternalIPAddress"',
+            },
+            timeout=timeout,
+        )
+        response.raise_for_status()
+        address = _find_text(ET.fromstring(response.content), "NewExternalIPAddress")
+    except (requests.RequestException, ET.ParseError) as e:
+        raise UPnPError(f"UPnP request failed: {e}")
+    if not address:
+        raise UPnPError("The router doesn't know its external IP address")
+    return address
@@ -0,0 +1,42 @@
+import functools
+from typing import Optional
+import requests
+from . import build_info
+
+
+REPO_URL = "https://github.com/kissgyorgy/cloudflare-dyndns"
+
+_user_agent: Optional[str] = None
+
+
+def default_user_agent() -> str:
+    # some IP services block or rate limit the generic python-requests agent
+    info = build_info.get()
+    details = [f"+{REPO_URL}"]
+    if info.commit:
+        details.append(f"commit {info.commit}")
+    details.append(info.platform)
+    return f"cloudflare-dyndns/{info.version} ({'; '.join(details)})"
+
+
+def _send_with_user_agent(send):
+    @functools.wraps(send)
+    def send_with_user_agent(session, request: requests.PreparedRequest, **kwargs):
+        if _user_agent:
+            request.headers["User-Agent"] = _user_agent
+        return send(session, request, **kwargs)
+
+    send_with_user_agent.has_user_agent = True
+    return send_with_user_agent
+
+
+def enable(user_agent: Optional[str] = None):
+    """Send this User-Agent with every HTTP request made through the requests
+    library, including the Cloudflare client.
+    """
+    global _user_agent
+    _user_agent = user_agent or default_user_agent()
+    send = requests.Session.send
+    if getattr(send, "has_user_agent", False):
+        return
+    requests.Session.send = _send_with_user_agent(send)
@@ -0,0 +1,198 @@
+"""A fake of the Cloudflare API endpoints we use (token verify, zones and
+dns_records) running on a local HTTP server, with pagination, rate limiting
+and error injection, so the API layer can be tested without a real account.
+"""
+import json
+import threading
+import uuid
+from http import HTTPStatus
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from typing import Dict, List, Optional
+from urllib.parse import parse_qs, urlparse
+
+VALID_TOKEN = "valid-token"
+API_PREFIX = "/client/v4"
+
+
+class FakeCloudflareHandler(BaseHTTPRequestHandler):
+    @property
+    def fake(self) -> "FakeCloudflare":
+        return self.server.fake
+
+    def log_message(self, format, *args):
+        pass
+
+    def _send(self, status: int, body: dict):
+        payload = json.dumps(body).encode()
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def send_result(self, result, result_info: Optional[dict] = None):
+        body = {"success": True, "errors": [], "messages": [], "result": result}
+        if result_info is not None:
+            body["result_info"] = result_info
+        self._send(HTTPStatus.OK, body)
+
+    def send_error_response(self, status: int, code: int, message: str):
+        errors = [{"code": code, "message": message}]
+        self._send(status, {"success": False, "errors": errors, "messages": []})
+
+    def send_page(self, items: List[dict], params: Dict[str, str]):
+        page = int(params.get("page", 1))
+        per_page = min(int(params.get("per_page", self.fake.per_page)), 100)
+        start = (page - 1) * per_page
+        result_info = {
+            "page": page,
+            "per_page": per_page,
+            "count": len(items[start : start + per_page]),
+            "total_count": len(items),
+            "total_pages": max(1, -(-len(items) // per_page)),
+        }
+        self.send_result(items[start : start + per_page], result_info)
+
+    def _handle(self, method: str):
+        url = urlparse(self.path)
+        path = url.path[len(API_PREFIX) :].strip("/").split("/")
+        params = {key: values[0] for key, values in parse_qs(url.query).items()}
+        length = int(self.headers.get("Content-Length") or 0)
+        body = json.loads(self.rfile.read(length)) if length else None
+        with self.fake.lock:
+            self.fake.requests.append((method, url.path, params, body))
+            if self.fake.rate_limit is not None:
+                if self.fake.rate_limit <= 0:
+                    return self.send_error_response(
+                        HTTPStatus.TOO_MANY_REQUESTS, 971, "Please wait and retry"
+                    )
+                self.fake.rate_limit -= 1
+            for index, (error_method, fragment, status, code) in enumerate(
+                self.fake.injected_errors
+            ):
+                if error_method == method and fragment in url.path:
+                    del self.fake.injected_errors[index]
+                    return self.send_error_response(status, code, "Injected error")
+            if self.headers.get("Authorization") != f"Bearer {self.fake.token}":
+                return self.send_error_response(
+                    HTTPStatus.FORBIDDEN, 10000, "Authentication error"
+                )
+            self.route(method, path, params, body)
+
+    def route(self, method: str, path: List[str], params: dict, body: dict):
+        fake = self.fake
+        if path == ["user", "tokens", "verify"]:
+            token = {"id": "token-id", "status": "active"}
+            if fake.token_expires_on:
+                token["expires_on"] = fake.token_expires_on
+            return self.send_result(token)
+        if path == ["zones"] and method == "GET":
+            zones = [
+                {"id": zone_id, "name": name}
+                for zone_id, name in fake.zones.items()
+                if "name" not in params or params["name"] == name
+            ]
+            return self.send_page(zones, params)
+        if len(path) < 3 or path[0] != "zones" or path[2] != "dns_records":
+            return self.send_error_response(HTTPStatus.NOT_FOUND, 7000, "No route")
+        zone_id = path[1]
+        if zone_id not in fake.zones:
+            return self.send_error_response(HTTPStatus.NOT_FOUND, 7003, "No zone")
+        if len(path) == 3 and method == "GET":
+            filters = {key: params[key] for key in ("name", "type") if key in params}
+            records = [
+                record
+                for record in fake.records
+                if record["zone_id"] == zone_id
+                and all(record[key] == value for key, value in filters.items())
+            ]
+            return self.send_page(records, params)
+        if len(path) == 3 and method == "POST":
+            record = dict(body, id=uuid.uuid4().hex, zone_id=zone_id)
+            fake.records.append(record)
+            return self.send_result(record)
+        record = fake.get_record(path[3] if len(path) == 4 else "")
+        if record is None or record["zone_id"] != zone_id:
+            return self.send_error_response(HTTPStatus.NOT_FOUND, 81044, "No record")
+        if method == "GET":
+            return self.send_result(record)
+        if method == "PUT":
+            record.update(body)
+            return self.send_result(record)
+        if method == "DELETE":
+            fake.records.remove(record)
+            return self.send_result({"id": record["id"]})
+        return self.send_error_response(HTTPStatus.METHOD_NOT_ALLOWED, 10000, method)
+
+    def do_GET(self):
+        self._handle("GET")
+
+    def do_POST(self):
+        self._handle("POST")
+
+    def do_PUT(self):
+        self._handle("PUT")
+
+    def do_DELETE(self):
+        self._handle("DELETE")
+
+
+class FakeCloudflare:
+    """The state of the fake API, which tests can inspect and modify."""
+
+    def __init__(self, zones: Dict[str, str], token: str = VALID_TOKEN):
+        # zone id -> zone name
+        self.zones = zones
+        self.records: List[dict] = []
+        self.token = token
+        # like 2024-01-01T00:00:00Z, tokens don't expire by default
+        self.token_expires_on: Optional[str] = None
+        self.per_page = 20
+        # number of requests allowed before answering 429, None for no limit
+        self.rate_limit: Optional[int] = None
+        self.injected_errors = []
+        self.requests = []
+        self.lock = threading.Lock()
+        self._server = ThreadingHTTPServer(("127.0.0.1", 0), FakeCloudflareHandler)
+        self._server.fake = self
+        self.url = f"http://127.0.0.1:{self._server.server_port}{API_PREFIX}"
+        self._thread = threading.Thread(target=self._server.serve_forever)
+
+    def __enter__(self) -> "FakeCloudflare":
+        self._thread.start()
+        return self
+
+    def __exit__(self, *exc_info):
+        self._server.shutdown()
+        self._server.server_close()
+        self._thread.join()
+
+    def inject_error(self, method: str, path_fragment: str, status=500, code=10000):
+        """The next matching request fails with the given status and error code."""
+        self.injected_errors.append((method, path_fragment, status, code))
+
+    def add_record(self, zone_id: str, name: str, record_type: str, content: str):
+        record = {
+            "id": uuid.uuid4().hex,
+            "zone_id": zone_id,
+            "name": name,
+            "type": record_type,
+            "content": content,
+            "proxied": False,
+        }
+        self.records.append(record)
+        return record
+
+    def get_record(self, record_id: str) -> Optional[dict]:
+        for record in self.records:
+            if record["id"] == record_id:
+                return record
+        return None
+
+    def find_records(self, name: str, record_type: Optional[str] = None):
+        return [
+            record
+            for record in self.records
+            if record["name"] == name
+            and (record_type is None or record["type"] == record_type)
+        ]
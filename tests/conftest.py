@@ -1,4 +1,8 @@
+import json
+from http import HTTPStatus
+from typing import Any, Callable, Dict, List, NamedTuple, Optional
 import pytest
+from cloudflare_dyndns import breaker, progress, updater
 
 
 def pytest_addoption(parser):
@@ -14,3 +18,66 @@ def pytest_collection_modifyitems(config, items):
     for item in items:
         if "ipv6" in item.keywords:
             item.add_marker(skip_ipv6)
+
+
+@pytest.fixture(autouse=True)
+def no_retry_delay(monkeypatch):
+    monkeypatch.setattr(updater, "RETRY_DELAY", 0)
+
+
+@pytest.fixture(autouse=True)
+def reset_breaker():
+    breaker.use({})
+
+
+@pytest.fixture(autouse=True)
+def no_progress():
+    progress.enable(False)
+
+
+class FakeRequest(NamedTuple):
+    method: str
+    # without the API URL
+    path: str
+    json: Any = None
+    params: Optional[dict] = None
+    data: Optional[bytes] = None
+
+
+class FakeResponse:
+    """The parts of requests.Response the providers use."""
+
+    def __init__(self, status_code: int = 200, data: Any = None, text: str = ""):
+        self.status_code = status_code
+        self.ok = status_code < 400
+        self.reason = HTTPStatus(status_code).phrase
+        self.headers: Dict[str, str] = {}
+        self.text = text if data is None else json.dumps(data)
+        self.content = self.text.encode()
+
+    def json(self):
+        return json.loads(self.text)
+
+
+class FakeSession:
+    """Stands in for the requests.Session of a provider, the requests are
+    recorded and answered by the handler instead of the API.
+    """
+
+    def __init__(self, api_url: str, handler: Callable[[FakeRequest], FakeResponse]):
+        self.headers: Dict[str, str] = {}
+        self.requests: List[FakeRequest] = []
+        self._api_url = api_url
+        self._handler = handler
+
+    def request(self, method, url, json=None, params=None, data=None, **kwargs):
+        path = url[len(self._api_url) :] if url.startswith(self._api_url) else url
+        request = FakeRequest(method, path, json, params, data)
+        self.requests.append(request)
+        return self._handler(request)
+
+    def get(self, url, **kwargs):
+        return self.request("GET", url, **kwargs)
+
+    def post(self, url, **kwargs):
+        return self.request("POST", url, **kwargs)
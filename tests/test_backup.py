@@ -0,0 +1,43 @@
+from cloudflare_dyndns.backup import BackupStore, backup_file, select_backups
+
+
+def test_keeps_the_latest_backups(tmp_path):
+    path = backup_file(tmp_path / "ip.cache")
+    assert path.name == "ip.cache.backups"
+    store = BackupStore(path, keep=2)
+    assert store.load() == []
+
+    for number in range(3):
+        record = {"type": "A", "content": f"192.0.2.{number}"}
+        store.save("example.com", "A", "zone-1", f"record-{number}", record)
+
+    backups = store.load()
+    assert [b.record_id for b in backups] == ["record-1", "record-2"]
+    assert backups[-1].record["content"] == "192.0.2.2"
+
+
+def test_skips_invalid_lines(tmp_path):
+    path = tmp_path / "ip.cache.backups"
+    store = BackupStore(path)
+    store.save("example.com", "A", "zone-1", "record-1", None)
+    path.write_text("garbage\n" + path.read_text())
+
+    [backup] = store.load()
+    assert backup.record is None
+
+
+def test_select_backups(tmp_path):
+    store = BackupStore(tmp_path / "ip.cache.backups")
+    for content in ("192.0.2.1", "192.0.2.2", "192.0.2.3"):
+        record = {"type": "A", "content": content}
+        store.save("example.com", "A", "zone-1", "record-1", record)
+    store.save("other.example.com", "A", "zone-1", "record-2", None)
+    backups = store.load()
+
+    [first, _] = select_backups(backups, since=backups[1].time)
+    assert first.record["content"] == "192.0.2.2"
+    [last, created] = select_backups(backups)
+    assert last.record["content"] == "192.0.2.3"
+    assert created.record is None
+    [only] = select_backups(backups, domains=["other.example.com"])
+    assert only.domain == "other.example.com"
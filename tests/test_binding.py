@@ -0,0 +1,50 @@
+import socket
+import pytest
+from cloudflare_dyndns import binding
+
+
+def test_source_address_per_family(monkeypatch):
+    monkeypatch.setattr(binding, "_source_addresses", {})
+    monkeypatch.setattr(binding, "_interface", None)
+    monkeypatch.setattr(binding.urllib3.util.connection, "create_connection", None)
+
+    binding.configure(None, ["127.0.0.1", "::1"])
+
+    assert binding._source_addresses == {
+        socket.AF_INET: "127.0.0.1",
+        socket.AF_INET6: "::1",
+    }
+    with socket.socket(socket.AF_INET, socket.SOCK_DGRAM) as sock:
+        binding.bind(sock)
+        assert sock.getsockname()[0] == "127.0.0.1"
+
+
+def test_one_address_per_family(monkeypatch):
+    monkeypatch.setattr(binding, "_source_addresses", {})
+    with pytest.raises(ValueError):
+        binding.configure(None, ["127.0.0.1", "127.0.0.2"])
+
+
+def test_create_connection_binds_source_address(monkeypatch):
+    monkeypatch.setattr(binding, "_source_addresses", {socket.AF_INET: "127.0.0.1"})
+    monkeypatch.setattr(binding, "_interface", None)
+    with socket.socket() as server:
+        server.bind(("127.0.0.1", 0))
+        server.listen()
+        sock = binding.create_connection(server.getsockname(), timeout=5)
+        with sock:
+            connection, (client_address, _) = server.accept()
+            connection.close()
+    assert client_address == "127.0.0.1"
+
+
+def test_bound_overrides_the_settings(monkeypatch):
+    monkeypatch.setattr(binding, "_source_addresses", {socket.AF_INET: "127.0.0.1"})
+    monkeypatch.setattr(binding.urllib3.util.connection, "create_connection", None)
+
+    with binding.bound("127.0.0.2"):
+        assert binding.current_link() == "127.0.0.2"
+        with socket.socket(socket.AF_INET, socket.SOCK_DGRAM) as sock:
+            binding.bind(sock)
+            assert sock.getsockname()[0] == "127.0.0.2"
+    assert binding.current_link() is None
@@ -0,0 +1,58 @@
+import json
+import pytest
+from click.testing import CliRunner
+from cloudflare_dyndns import build_info, cli, user_agent
+
+
+@pytest.fixture
+def build_file(tmp_path, monkeypatch):
+    path = tmp_path / "_build.json"
+    monkeypatch.setattr(build_info, "BUILD_FILE", path)
+    build_info.get.cache_clear()
+    yield path
+    build_info.get.cache_clear()
+
+
+def test_without_build_file(build_file):
+    info = build_info.get()
+    assert info.commit is None
+    assert info.build_date is None
+    assert info.version
+    assert info.python
+    assert info.platform
+
+
+def test_build_file(build_file):
+    build_file.write_text(
+        json.dumps({"commit": "1a2b3c4", "build_date": "2024-05-01T12:00:00Z"})
+    )
+    info = build_info.get()
+    assert info.commit == "1a2b3c4"
+    assert info.build_date == "2024-05-01T12:00:00Z"
+
+
+def test_empty_build_arguments_are_unknown(build_file):
+    build_file.write_text('{"commit": "", "build_date": ""}')
+    assert build_info.get().commit is None
+    assert build_info.get().build_date is None
+
+
+def test_invalid_build_file_is_ignored(build_file):
+    build_file.write_text("not json")
+    assert build_info.get().commit is None
+
+
+def test_version_option(build_file):
+    build_file.write_text('{"commit": "1a2b3c4"}')
+    result = CliRunner().invoke(cli.main, ["--version"])
+    assert result.exit_code == 0
+    assert result.output.startswith("cloudflare-dyndns ")
+    assert "Commit:      1a2b3c4" in result.output
+    assert "Build date:  unknown" in result.output
+
+
+def test_user_agent_has_commit(build_file):
+    build_file.write_text('{"commit": "1a2b3c4"}')
+    agent = user_agent.default_user_agent()
+    assert "commit 1a2b3c4" in agent
+    assert build_info.get().platform in agent
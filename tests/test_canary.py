@@ -0,0 +1,43 @@
+import ipaddress
+import socket
+import pytest
+from cloudflare_dyndns import canary
+from cloudflare_dyndns.canary import Canary, CanaryError
+
+IP = ipaddress.IPv4Address("127.0.0.1")
+
+
+@pytest.fixture
+def resolved(monkeypatch):
+    answers = []
+    monkeypatch.setattr(canary, "RETRY_DELAY", 0)
+    monkeypatch.setattr(canary.dns_lookup, "query", lambda *args: answers)
+    return answers
+
+
+def test_resolves_to_the_new_address(resolved):
+    resolved.append("127.0.0.1")
+    Canary("canary.example.com").verify(IP, proxied=False)
+
+
+def test_resolves_to_another_address(resolved):
+    resolved.append("127.0.0.2")
+    with pytest.raises(CanaryError, match="resolves to 127.0.0.2 instead of 127.0.0.1"):
+        Canary("canary.example.com", timeout=0).verify(IP, proxied=False)
+
+
+def test_proxied_canary_is_not_resolved(resolved):
+    resolved.append("104.16.0.1")
+    Canary("canary.example.com", timeout=0).verify(IP, proxied=True)
+
+
+def test_port_reachable(resolved):
+    resolved.append("127.0.0.1")
+    with socket.socket() as server:
+        server.bind(("127.0.0.1", 0))
+        server.listen()
+        port = server.getsockname()[1]
+        Canary("canary.example.com", port=port).verify(IP, proxied=False)
+
+    with pytest.raises(CanaryError, match=f"port {port} is not reachable"):
+        Canary("canary.example.com", port=port).verify(IP, proxied=False)
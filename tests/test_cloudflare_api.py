@@ -0,0 +1,541 @@
+import datetime
+import functools
+import ipaddress
+import pytest
+from click.testing import CliRunner
+from cftest import VALID_TOKEN, FakeCloudflare
+from cloudflare_dyndns import cli, updater
+from cloudflare_dyndns.backup import BackupStore, backup_file
+from cloudflare_dyndns.cache import Cache, ZoneCache, ZoneRecord
+from cloudflare_dyndns.cloudflare import (
+    MANAGED_COMMENT,
+    CloudFlareAuthError,
+    CloudFlareError,
+    CloudFlareRateLimited,
+    CloudFlareRecordNotFound,
+    CloudFlareWrapper,
+    CloudFlareZoneNotFound,
+)
+from cloudflare_dyndns.ip_services import IPSource, IPSourceUnavailable
+from cloudflare_dyndns.providers import FallbackProvider
+from cloudflare_dyndns.runlock import RunLock, lock_file
+from cloudflare_dyndns.updater import Updater
+
+ZONES = {"zone-1": "example.com"}
+
+
+@pytest.fixture
+def fake_cloudflare():
+    with FakeCloudflare(dict(ZONES)) as fake:
+        yield fake
+
+
+def make_updater(fake, domains, cache_file, **options) -> Updater:
+    provider = CloudFlareWrapper(VALID_TOKEN, base_url=fake.url, **options)
+    return Updater(provider, domains, cache_file)
+
+
+def test_creates_then_updates_records(fake_cloudflare, tmp_path, monkeypatch):
+    cache_file = tmp_path / "ip.cache"
+    domains = ["example.com", "home.example.com"]
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+
+    report = make_updater(fake_cloudflare, domains, cache_file).run()
+
+    assert report.exit_code == 0
+    for domain in domains:
+        [record] = fake_cloudflare.find_records(domain, "A")
+        assert record["content"] == "127.0.0.2"
+
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.3"))
+    report = make_updater(fake_cloudflare, domains, cache_file).run()
+
+    assert report.exit_code == 0
+    assert [r["content"] for r in fake_cloudflare.records] == ["127.0.0.3"] * 2
+
+
+def test_finds_record_on_later_page(fake_cloudflare, tmp_path, monkeypatch):
+    for number in range(120):
+        fake_cloudflare.add_record("zone-1", "example.com", "TXT", f"txt-{number}")
+    existing = fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+
+    cache_file = tmp_path / "c"
+    dyndns = make_updater(fake_cloudflare, ["example.com"], cache_file, takeover=True)
+    report = dyndns.run()
+
+    assert report.exit_code == 0
+    assert fake_cloudflare.find_records("example.com", "A") == [existing]
+    assert existing["content"] == "127.0.0.2"
+
+
+def test_rate_limited(fake_cloudflare, tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    # the zones of both domains are looked up before the updates
+    fake_cloudflare.rate_limit = 4
+    domains = ["example.com", "home.example.com"]
+
+    report = make_updater(fake_cloudflare, domains, tmp_path / "ip.cache").run()
+
+    assert report.exit_code == updater.EXIT_PARTIAL_SUCCESS
+    assert report.get_result("A").failed_domains == ["home.example.com"]
+    assert report.rate_limited
+    records_path = "/client/v4/zones/zone-1/dns_records"
+    assert [request[:2] for request in fake_cloudflare.requests] == [
+        ("GET", "/client/v4/zones"),
+        ("GET", "/client/v4/zones"),
+        ("GET", records_path),
+        ("POST", records_path),
+        # the rate limited lookup is not retried right away, that would only
+        # prolong the rate limiting
+        ("GET", records_path),
+    ]
+    assert fake_cloudflare.requests[-1][2]["name"] == "home.example.com"
+
+
+def test_invalid_cache_falls_back_to_lookup(fake_cloudflare, tmp_path, monkeypatch):
+    cache_file = tmp_path / "ip.cache"
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    make_updater(fake_cloudflare, ["example.com"], cache_file).run()
+
+    fake_cloudflare.inject_error("PUT", "/dns_records/", status=404, code=81044)
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.3"))
+    report = make_updater(fake_cloudflare, ["example.com"], cache_file).run()
+
+    assert report.exit_code == 0
+    [record] = fake_cloudflare.find_records("example.com", "A")
+    assert record["content"] == "127.0.0.3"
+
+
+def test_forced_update_skips_records_with_the_address(
+    fake_cloudflare, tmp_path, monkeypatch
+):
+    fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+
+    report = make_updater(fake_cloudflare, ["example.com"], tmp_path / "c").run(
+        force=True
+    )
+
+    assert report.exit_code == 0
+    methods = {method for method, *_ in fake_cloudflare.requests}
+    assert methods == {"GET"}
+
+
+def test_invalid_token(fake_cloudflare):
+    provider = CloudFlareWrapper("invalid-token", base_url=fake_cloudflare.url)
+    with pytest.raises(CloudFlareError):
+        provider.verify_credentials()
+
+
+def test_token_expiry(fake_cloudflare):
+    provider = CloudFlareWrapper(VALID_TOKEN, base_url=fake_cloudflare.url)
+    assert provider.credentials_expire_on() is None
+
+    fake_cloudflare.token_expires_on = "2030-01-02T03:04:05Z"
+    assert provider.credentials_expire_on() == datetime.datetime(
+        2030, 1, 2, 3, 4, 5, tzinfo=datetime.timezone.utc
+    )
+
+
+def test_unknown_zone(fake_cloudflare, tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    report = make_updater(fake_cloudflare, ["example.org"], tmp_path / "c").run()
+    assert report.exit_code == updater.EXIT_INVALID_DOMAINS
+
+
+def test_updates_records_matching_pattern(fake_cloudflare, tmp_path, monkeypatch):
+    for name in ("home-1.example.com", "home-2.example.com", "www.example.com"):
+        fake_cloudflare.add_record("zone-1", name, "A", "127.0.0.1")
+    fake_cloudflare.add_record("zone-1", "home-txt.example.com", "TXT", "text")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, takeover=True
+    )
+    dyndns = Updater(
+        provider, [], tmp_path / "ip.cache", match_patterns=["home-*.example.com"]
+    )
+
+    report = dyndns.run()
+
+    assert report.exit_code == 0
+    assert report.get_result("A").updated_domains == [
+        "home-1.example.com",
+        "home-2.example.com",
+    ]
+    [www] = fake_cloudflare.find_records("www.example.com")
+    assert www["content"] == "127.0.0.1"
+
+
+class FakeLink(IPSource):
+    def __init__(self, address):
+        self.address = address
+
+    def get_ip(self, version):
+        if self.address is None:
+            raise IPSourceUnavailable("The link is down.")
+        return self.address
+
+
+def test_round_robin_records_of_wan_links(fake_cloudflare, tmp_path):
+    fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.2")
+    links = [[FakeLink("127.0.0.3")], [FakeLink("127.0.0.2")], [FakeLink(None)]]
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, takeover=True
+    )
+    dyndns = Updater(provider, ["example.com"], tmp_path / "c", wan_sources=links)
+
+    report = dyndns.run()
+
+    assert report.exit_code == 0
+    records = fake_cloudflare.find_records("example.com", "A")
+    assert sorted(r["content"] for r in records) == ["127.0.0.2", "127.0.0.3"]
+
+    # nothing changed, the cache is used
+    request_count = len(fake_cloudflare.requests)
+    assert dyndns.run().status == "unchanged"
+    assert len(fake_cloudflare.requests) == request_count
+
+
+def test_verification_finds_deleted_records(fake_cloudflare, tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    provider = CloudFlareWrapper(VALID_TOKEN, base_url=fake_cloudflare.url)
+    domains = ["example.com", "home.example.com"]
+    dyndns = Updater(provider, domains, tmp_path / "c", verify_every_runs=2)
+    assert dyndns.run().exit_code == 0
+
+    [deleted] = fake_cloudflare.find_records("home.example.com", "A")
+    fake_cloudflare.records.remove(deleted)
+    [edited] = fake_cloudflare.find_records("example.com", "A")
+    edited["content"] = "127.0.0.9"
+    report = dyndns.run()
+
+    assert sorted(report.get_result("A").updated_domains) == domains
+    for domain in domains:
+        [record] = fake_cloudflare.find_records(domain, "A")
+        assert record["content"] == "127.0.0.2"
+
+
+def test_zone_ids_are_cached_on_disk(fake_cloudflare, tmp_path):
+    zone_cache = ZoneCache(tmp_path / "ip.cache.zones")
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, zone_cache=zone_cache
+    )
+    assert provider.get_zone_id("home.example.com") == "zone-1"
+
+    zone_cache = ZoneCache(tmp_path / "ip.cache.zones")
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, zone_cache=zone_cache
+    )
+    request_count = len(fake_cloudflare.requests)
+    assert provider.get_zone_id("example.com") == "zone-1"
+    assert len(fake_cloudflare.requests) == request_count
+
+
+def test_stale_zone_id_is_looked_up_again(fake_cloudflare, tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    zone_cache = ZoneCache(tmp_path / "ip.cache.zones")
+    zone_cache.set("example.com", "deleted-zone")
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, zone_cache=zone_cache
+    )
+
+    report = Updater(provider, ["example.com"], tmp_path / "ip.cache").run()
+
+    # the failed domain is retried with the new zone ID
+    assert report.exit_code == 0
+    assert zone_cache.get("example.com") == "zone-1"
+
+
+def test_strict_ownership(fake_cloudflare, tmp_path, monkeypatch):
+    foreign = fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, strict_ownership=True
+    )
+    domains = ["example.com", "home.example.com"]
+    dyndns = Updater(provider, domains, tmp_path / "ip.cache")
+
+    report = dyndns.run()
+
+    assert report.get_result("A").failed_domains == ["example.com"]
+    assert foreign["content"] == "127.0.0.1"
+    [created] = fake_cloudflare.find_records("home.example.com", "A")
+    assert created["comment"] == MANAGED_COMMENT
+
+    # records created by the tool can be updated later
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.3"))
+    assert dyndns.run().get_result("A").updated_domains == ["home.example.com"]
+    assert created["content"] == "127.0.0.3"
+
+
+def test_takeover_of_foreign_records(fake_cloudflare, tmp_path, monkeypatch):
+    foreign = fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    cache_file = tmp_path / "ip.cache"
+
+    report = make_updater(fake_cloudflare, ["example.com"], cache_file).run()
+
+    assert report.get_result("A").failed_domains == ["example.com"]
+    assert foreign["content"] == "127.0.0.1"
+
+    dyndns = make_updater(fake_cloudflare, ["example.com"], cache_file, takeover=True)
+    assert dyndns.run().exit_code == 0
+    assert foreign["content"] == "127.0.0.2"
+    assert foreign["comment"] == MANAGED_COMMENT
+    cache = updater.CacheManager(cache_file).load()
+    assert cache.ipv4.updated_domains["example.com"].taken_over is not None
+
+    # adopted records are updated without --takeover
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.3"))
+    report = make_updater(fake_cloudflare, ["example.com"], tmp_path / "new").run()
+    assert report.exit_code == 0
+    assert foreign["content"] == "127.0.0.3"
+
+
+def test_cached_records_need_no_takeover(fake_cloudflare):
+    # written by an older version, which didn't add the comment yet
+    record = fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    cached = ZoneRecord(zone_id="zone-1", record_id=record["id"])
+    # a temporary error, so the record is looked up instead of updated right away
+    fake_cloudflare.inject_error("PUT", record["id"], status=500, code=1000)
+    provider = CloudFlareWrapper(VALID_TOKEN, base_url=fake_cloudflare.url)
+    ip = ipaddress.ip_address("127.0.0.2")
+
+    provider.ensure_record("example.com", ip, cached=cached)
+
+    assert record["content"] == "127.0.0.2"
+    assert record["comment"] == MANAGED_COMMENT
+
+
+def test_adaptive_ttl(fake_cloudflare, tmp_path, monkeypatch):
+    cache_file = tmp_path / "ip.cache"
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    provider = CloudFlareWrapper(VALID_TOKEN, base_url=fake_cloudflare.url)
+    dyndns = Updater(provider, ["example.com"], cache_file, adaptive_ttl=(60, 3600))
+
+    dyndns.run()
+    [record] = fake_cloudflare.find_records("example.com", "A")
+    assert record["ttl"] == 60
+
+    # the address has been the same for a day
+    cache = updater.CacheManager(cache_file).load()
+    cache.ipv4.changes[0].time -= 24 * 3600
+    updater.CacheManager(cache_file).save(cache)
+    assert dyndns.run().get_result("A").updated_domains == ["example.com"]
+    assert record["ttl"] == 3600
+    assert dyndns.run().status == "unchanged"
+
+
+def test_invalid_token_is_an_auth_error(fake_cloudflare, tmp_path):
+    provider = CloudFlareWrapper("invalid-token", base_url=fake_cloudflare.url)
+    with pytest.raises(CloudFlareAuthError):
+        provider.verify_credentials()
+
+    report = Updater(provider, ["example.com"], tmp_path / "ip.cache").run()
+    assert report.auth_failed
+    assert report.exit_code == updater.EXIT_CLOUDFLARE_ERROR
+
+
+def test_revoked_token_stops_the_update(fake_cloudflare, tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    domains = ["home-1.example.com", "home-2.example.com"]
+    dyndns = make_updater(fake_cloudflare, domains, tmp_path / "ip.cache")
+    assert dyndns.run().exit_code == 0
+
+    fake_cloudflare.token = "rotated-token"
+    fake_cloudflare.requests.clear()
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.3"))
+    report = dyndns.run()
+
+    assert report.auth_failed
+    assert report.exit_code == updater.EXIT_CLOUDFLARE_ERROR
+    assert report.get_result("A").failed_domains == domains
+    # the second domain was not tried with the same token, nor retried
+    assert len(fake_cloudflare.requests) == 1
+    assert report.summary().startswith("The API token is invalid or expired!")
+
+
+def test_falls_back_to_the_secondary_token(fake_cloudflare, tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    provider = FallbackProvider(
+        CloudFlareWrapper("revoked-token", base_url=fake_cloudflare.url),
+        CloudFlareWrapper(VALID_TOKEN, base_url=fake_cloudflare.url),
+    )
+    dyndns = Updater(provider, ["example.com"], tmp_path / "ip.cache")
+
+    report = dyndns.run()
+    assert report.exit_code == 0
+    assert report.token_rotated
+    assert report.get_result("A").updated_domains == ["example.com"]
+    # provider specific methods use the fallback token too
+    assert provider.get_txt_record("lease.example.com") is None
+
+    # only reported once
+    assert not dyndns.run(force=True).token_rotated
+
+
+def test_plan_changes_nothing(fake_cloudflare, tmp_path, monkeypatch):
+    fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    fake_cloudflare.add_record("zone-1", "home.example.com", "A", "127.0.0.2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    domains = ["example.com", "home.example.com", "new.example.com"]
+    dyndns = make_updater(fake_cloudflare, domains, tmp_path / "ip.cache")
+
+    changes = dyndns.plan()
+
+    assert [(c.action, c.domain, c.old, c.new) for c in changes] == [
+        ("update", "example.com", "127.0.0.1", "127.0.0.2"),
+        ("create", "new.example.com", None, "127.0.0.2"),
+    ]
+    assert [r["content"] for r in fake_cloudflare.records] == ["127.0.0.1", "127.0.0.2"]
+    assert not (tmp_path / "ip.cache").exists()
+
+
+def test_backs_up_records_before_changing_them(fake_cloudflare, tmp_path, monkeypatch):
+    existing = fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    existing["comment"] = MANAGED_COMMENT
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    store = BackupStore(tmp_path / "ip.cache.backups")
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, backup_store=store
+    )
+    domains = ["example.com", "new.example.com"]
+
+    report = Updater(provider, domains, tmp_path / "ip.cache").run()
+
+    assert report.exit_code == 0
+    updated, created = store.load()
+    assert updated.domain == "example.com"
+    assert updated.record_id == existing["id"]
+    assert updated.record["content"] == "127.0.0.1"
+    assert created.domain == "new.example.com"
+    assert created.record is None
+
+    # the cached records are looked up for the backup too
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.3"))
+    Updater(provider, domains, tmp_path / "ip.cache").run()
+    backups = store.load()
+    assert [b.record["content"] for b in backups[2:]] == ["127.0.0.2"] * 2
+
+
+def test_rollback(fake_cloudflare, tmp_path, monkeypatch):
+    existing = fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    existing.update(comment=MANAGED_COMMENT, ttl=300)
+    cache_file = tmp_path / "ip.cache"
+    store = BackupStore(backup_file(cache_file))
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, backup_store=store
+    )
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    domains = ["example.com", "new.example.com"]
+    assert Updater(provider, domains, cache_file).run().exit_code == 0
+
+    wrapper = functools.partial(CloudFlareWrapper, base_url=fake_cloudflare.url)
+    monkeypatch.setattr(cli, "CloudFlareWrapper", wrapper)
+    args = ["rollback", "--cache-file", str(cache_file), "--api-token", VALID_TOKEN]
+    result = CliRunner().invoke(cli.main, args)
+
+    assert result.exit_code == 0, result.output
+    assert 'Deleted the A record of "new.example.com".' in result.output
+    [record] = fake_cloudflare.records
+    assert record["content"] == "127.0.0.1"
+    assert record["ttl"] == 300
+
+    # the rollback itself can be undone
+    assert CliRunner().invoke(cli.main, [*args, "example.com"]).exit_code == 0
+    [record] = fake_cloudflare.records
+    assert record["content"] == "127.0.0.2"
+
+
+def test_update_after_rollback(fake_cloudflare, tmp_path, monkeypatch):
+    existing = fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    existing.update(comment=MANAGED_COMMENT)
+    cache_file = tmp_path / "ip.cache"
+    store = BackupStore(backup_file(cache_file))
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, backup_store=store
+    )
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    dyndns = Updater(provider, ["example.com"], cache_file)
+    assert dyndns.run().exit_code == 0
+    wrapper = functools.partial(CloudFlareWrapper, base_url=fake_cloudflare.url)
+    monkeypatch.setattr(cli, "CloudFlareWrapper", wrapper)
+    args = ["rollback", "--cache-file", str(cache_file), "--api-token", VALID_TOKEN]
+    assert CliRunner().invoke(cli.main, args).exit_code == 0
+
+    # the cache doesn't say it's up-to-date anymore
+    assert dyndns.run().get_result("A").updated_domains == ["example.com"]
+    [record] = fake_cloudflare.records
+    assert record["content"] == "127.0.0.2"
+
+
+def test_rollback_during_an_update(fake_cloudflare, tmp_path, monkeypatch):
+    existing = fake_cloudflare.add_record("zone-1", "example.com", "A", "127.0.0.1")
+    existing.update(comment=MANAGED_COMMENT)
+    cache_file = tmp_path / "ip.cache"
+    store = BackupStore(backup_file(cache_file))
+    provider = CloudFlareWrapper(
+        VALID_TOKEN, base_url=fake_cloudflare.url, backup_store=store
+    )
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address("127.0.0.2"))
+    assert Updater(provider, ["example.com"], cache_file).run().exit_code == 0
+    cache = cache_file.read_text()
+    wrapper = functools.partial(CloudFlareWrapper, base_url=fake_cloudflare.url)
+    monkeypatch.setattr(cli, "CloudFlareWrapper", wrapper)
+    args = ["rollback", "--cache-file", str(cache_file), "--api-token", VALID_TOKEN]
+
+    with RunLock(lock_file(cache_file)):
+        result = CliRunner().invoke(cli.main, args)
+
+    assert result.exit_code == updater.EXIT_LOCKED
+    assert "Another run is in progress" in result.output
+    [record] = fake_cloudflare.records
+    assert record["content"] == "127.0.0.2"
+    assert cache_file.read_text() == cache
+
+
+@pytest.mark.parametrize(
+    "code, error_type",
+    [
+        (9109, CloudFlareAuthError),
+        (971, CloudFlareRateLimited),
+        (81044, CloudFlareRecordNotFound),
+        (7003, CloudFlareZoneNotFound),
+        (1004, CloudFlareError),
+    ],
+)
+def test_api_errors_are_typed(fake_cloudflare, code, error_type):
+    provider = CloudFlareWrapper(VALID_TOKEN, base_url=fake_cloudflare.url)
+    fake_cloudflare.inject_error("GET", "/dns_records", status=400, code=code)
+
+    with pytest.raises(CloudFlareError) as excinfo:
+        provider.find_domains("*.example.com")
+    assert type(excinfo.value) is error_type
+
+
+def test_record_created_in_the_meantime(fake_cloudflare, tmp_path, monkeypatch):
+    address = "127.0.0.2"
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.ip_address(address))
+    provider = CloudFlareWrapper(VALID_TOKEN, base_url=fake_cloudflare.url)
+    find_record = provider._find_record
+
+    def created_by_another_instance(*args):
+        try:
+            return find_record(*args)
+        finally:
+            record = fake_cloudflare.add_record("zone-1", "example.com", "A", address)
+            record["comment"] = MANAGED_COMMENT
+
+    monkeypatch.setattr(provider, "_find_record", created_by_another_instance)
+    fake_cloudflare.inject_error("POST", "/dns_records", status=400, code=81058)
+    cache_file = tmp_path / "ip.cache"
+
+    report = Updater(provider, ["example.com"], cache_file).run()
+
+    assert report.exit_code == 0
+    assert report.get_result("A").updated_domains == ["example.com"]
+    [record] = fake_cloudflare.records
+    cache = Cache.parse_file(cache_file)
+    assert cache.ipv4.updated_domains["example.com"].record_id == record["id"]
@@ -0,0 +1,110 @@
+import json
+import pytest
+from click.testing import CliRunner
+from cloudflare_dyndns import control_socket
+from cloudflare_dyndns.daemon import Daemon
+from cloudflare_dyndns.report import Report
+
+
+@pytest.fixture
+def daemon():
+    return Daemon(lambda force, addresses: Report(), 300, False, ["example.com"])
+
+
+@pytest.fixture
+def socket_path(tmp_path):
+    return tmp_path / "control.sock"
+
+
+@pytest.fixture
+def server(daemon, socket_path):
+    server = control_socket.ControlSocketServer(socket_path, daemon)
+    server.start()
+    yield server
+    server.close()
+
+
+def test_handle_command(daemon):
+    response = control_socket.handle_command(daemon, {"command": "pause"})
+    assert response == {"ok": True, "message": "Updates paused."}
+    assert daemon.paused
+
+    response = control_socket.handle_command(daemon, {"command": "reload"})
+    assert response == {"ok": False, "error": "Nothing to reload"}
+
+    response = control_socket.handle_command(daemon, {"command": "delete"})
+    assert response["ok"] is False
+
+
+def test_only_the_owner_can_connect(server, socket_path):
+    assert socket_path.stat().st_mode & 0o777 == 0o600
+
+
+def test_ctl_update(server, daemon, socket_path):
+    result = CliRunner().invoke(
+        control_socket.ctl, ["update", "--force", "--socket", str(socket_path)]
+    )
+    assert result.exit_code == 0
+    # the daemon runs in the same process here, its messages are printed too
+    assert result.output.splitlines()[-1] == "Update requested."
+    assert daemon._update_requested
+    assert daemon._force
+
+
+def test_ctl_status(server, socket_path):
+    result = CliRunner().invoke(control_socket.ctl, ["status", "--socket", socket_path])
+    assert result.exit_code == 0
+    assert json.loads(result.output)["domains"] == ["example.com"]
+
+
+def test_ctl_without_daemon(socket_path):
+    result = CliRunner().invoke(control_socket.ctl, ["status", "--socket", socket_path])
+    assert result.exit_code == 1
+    assert "Can't connect to the daemon" in result.output
+
+
+def test_stale_socket_is_replaced(daemon, socket_path):
+    first = control_socket.ControlSocketServer(socket_path, daemon)
+    # a killed daemon leaves the socket file behind
+    first._server.server_close()
+    assert socket_path.exists()
+
+    second = control_socket.ControlSocketServer(socket_path, daemon)
+    second.start()
+    try:
+        assert control_socket.send_command(socket_path, "status")["ok"]
+    finally:
+        second.close()
+
+
+def test_running_daemon_is_not_replaced(server, daemon, socket_path):
+    with pytest.raises(ValueError, match="Another daemon"):
+        control_socket.ControlSocketServer(socket_path, daemon)
+
+
+def test_default_socket_in_private_dir(daemon, tmp_path, monkeypatch):
+    monkeypatch.delenv("XDG_RUNTIME_DIR", raising=False)
+    monkeypatch.setattr(control_socket.tempfile, "gettempdir", lambda: str(tmp_path))
+    path = control_socket.default_socket()
+    assert path.parent.parent == tmp_path
+
+    server = control_socket.ControlSocketServer(path, daemon)
+    server.start()
+    try:
+        assert path.parent.stat().st_mode & 0o777 == 0o700
+        assert control_socket.send_command(path, "status")["ok"]
+    finally:
+        server.close()
+
+
+def test_default_socket_dir_of_others_is_refused(daemon, tmp_path, monkeypatch):
+    monkeypatch.delenv("XDG_RUNTIME_DIR", raising=False)
+    monkeypatch.setattr(control_socket.tempfile, "gettempdir", lambda: str(tmp_path))
+    path = control_socket.default_socket()
+    path.parent.mkdir(mode=0o777)
+    path.parent.chmod(0o777)
+
+    with pytest.raises(ValueError, match="only the user can access"):
+        control_socket.ControlSocketServer(path, daemon)
+    with pytest.raises(control_socket.ControlError, match="only the user can access"):
+        control_socket.send_command(path, "status")
@@ -0,0 +1,112 @@
+import pytest
+from cloudflare_dyndns import breaker
+from cloudflare_dyndns.daemon import AUTH_BACKOFF_MAX, Daemon
+from cloudflare_dyndns.providers import AuthenticationError, DNSProviderError
+from cloudflare_dyndns.report import Report
+
+
+def test_paused_daemon_stays_healthy():
+    daemon = Daemon(lambda force: Report(), 300, False, ["example.com"])
+    daemon.last_report = Report()
+    # a long time ago
+    daemon.last_run = 0
+    assert not daemon.health()[0]
+    daemon.pause()
+    assert daemon.health()[0]
+    assert daemon.status()["paused"]
+
+
+def test_reload_not_available_without_callback():
+    daemon = Daemon(lambda force: Report(), 300, False, ["example.com"])
+    assert not daemon.request_reload()
+
+
+def test_failed_reload_keeps_running(capsys):
+    def reload():
+        raise OSError("No such file")
+
+    daemon = Daemon(lambda force: Report(), 300, False, ["example.com"], reload)
+    daemon.reload()
+    assert "keeping the old one: No such file" in capsys.readouterr().out
+
+
+def test_status_shows_failing_ip_sources():
+    breaker.record_failure("AWS check ip")
+    daemon = Daemon(lambda force: Report(), 300, False, ["example.com"])
+    assert daemon.status()["ip_sources"] == {
+        "AWS check ip": {"consecutive_failures": 1, "skipped_until": None}
+    }
+
+
+def test_pause_file(tmp_path):
+    pause_file = tmp_path / "pause"
+    daemon = Daemon(
+        lambda force: Report(), 300, False, ["example.com"], pause_file=pause_file
+    )
+    daemon.check_pause_file()
+    assert not daemon.paused
+
+    pause_file.touch()
+    daemon.check_pause_file()
+    assert daemon.paused
+    # the file wins over the control API
+    daemon.resume()
+    assert daemon.paused
+
+    pause_file.unlink()
+    daemon.check_pause_file()
+    assert not daemon.paused
+
+
+def test_backs_off_after_rejected_credentials(monkeypatch):
+    def verify_credentials():
+        if not valid:
+            raise AuthenticationError("Invalid API token")
+
+    valid = False
+    daemon = Daemon(
+        lambda force: Report(),
+        300,
+        False,
+        ["example.com"],
+        verify_credentials=verify_credentials,
+    )
+    delays = []
+    monkeypatch.setattr(daemon, "sleep", delays.append)
+    for _ in range(8):
+        daemon.back_off()
+    assert delays[:3] == [600, 1200, 2400]
+    assert delays[-1] == AUTH_BACKOFF_MAX
+
+    assert not daemon.credentials_valid()
+    valid = True
+    assert daemon.credentials_valid()
+
+
+def test_network_error_doesnt_extend_the_backoff(monkeypatch):
+    class Stop(Exception):
+        pass
+
+    def verify_credentials():
+        raise DNSProviderError("Connection timed out")
+
+    def sleep(delay):
+        delays.append(delay)
+        raise Stop
+
+    daemon = Daemon(
+        lambda force: Report(),
+        300,
+        False,
+        ["example.com"],
+        verify_credentials=verify_credentials,
+    )
+    delays = []
+    monkeypatch.setattr(daemon, "sleep", delays.append)
+    daemon.back_off()
+    monkeypatch.setattr(daemon, "sleep", sleep)
+
+    with pytest.raises(Stop):
+        daemon.run()
+    assert delays == [600, 300]
+    assert daemon.auth_backoff == 600
@@ -0,0 +1,58 @@
+import ipaddress
+from cloudflare_dyndns import dashboard
+from cloudflare_dyndns.cache import DomainError
+from cloudflare_dyndns.daemon import Daemon
+from cloudflare_dyndns.report import Report, UpdateResult
+
+
+def make_daemon(report: Report) -> Daemon:
+    daemon = Daemon(lambda force: report, 300, False, ["example.com", "<b>.com"])
+    daemon.last_report = report
+    daemon.last_run = 0
+    daemon.history.append((0, report))
+    return daemon
+
+
+def test_render_escapes_domains():
+    report = Report(
+        results=[
+            UpdateResult(
+                record_type="A",
+                new_ip=ipaddress.IPv4Address("127.0.0.2"),
+                updated_domains=["example.com"],
+                failed_domains=["<b>.com"],
+            )
+        ]
+    )
+    page = dashboard.render(make_daemon(report))
+    assert "127.0.0.2" in page
+    assert "<b>.com" not in page
+    assert "&lt;b&gt;.com" in page
+
+
+def test_render_before_first_update():
+    daemon = Daemon(lambda force: Report(), 300, False, ["example.com"])
+    assert "still running" in dashboard.render(daemon)
+
+
+def test_trigger_update_wakes_up_sleep():
+    daemon = make_daemon(Report())
+    daemon.trigger_update(force=True)
+    # would block for the whole interval without the trigger
+    daemon.sleep(300)
+    assert daemon._force
+
+
+def test_render_domain_errors():
+    error = DomainError(message="Invalid <record>", time=0)
+    report = Report(
+        results=[
+            UpdateResult(
+                record_type="A",
+                failed_domains=["example.com"],
+                domain_errors={"example.com": error},
+            )
+        ]
+    )
+    page = dashboard.render(make_daemon(report))
+    assert "failed: Invalid &lt;record&gt;" in page
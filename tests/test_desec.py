@@ -0,0 +1,39 @@
+import ipaddress
+from conftest import FakeResponse, FakeSession
+from cloudflare_dyndns.desec import API_URL, DeSECProvider
+
+
+def make_provider():
+    def handle(request):
+        if request.path == "/domains/":
+            domain = {"name": "example.dedyn.io", "minimum_ttl": 60}
+            return FakeResponse(200, [domain])
+        return FakeResponse(200, request.json)
+
+    provider = DeSECProvider("token")
+    provider._session = FakeSession(API_URL, handle)
+    return provider
+
+
+def test_patches_rrset():
+    provider = make_provider()
+    ip = ipaddress.IPv6Address("::1")
+
+    record = provider.ensure_record("home.example.dedyn.io", ip)
+
+    assert (record.zone_id, record.record_id) == ("example.dedyn.io", "home")
+    rrset = {"subname": "home", "type": "AAAA", "ttl": 60, "records": ["::1"]}
+    assert provider._session.requests[-1][:3] == (
+        "PATCH",
+        "/domains/example.dedyn.io/rrsets/",
+        [rrset],
+    )
+
+
+def test_deletes_with_empty_records():
+    provider = make_provider()
+
+    provider.delete_record("example.dedyn.io", "A")
+
+    rrsets = provider._session.requests[-1].json
+    assert (rrsets[0]["subname"], rrsets[0]["records"]) == ("", [])
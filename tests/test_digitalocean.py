@@ -0,0 +1,57 @@
+import ipaddress
+import pytest
+from conftest import FakeResponse, FakeSession
+from cloudflare_dyndns.digitalocean import API_URL, DigitalOceanProvider
+from cloudflare_dyndns.providers import AuthenticationError
+
+
+def make_provider(records=()):
+    def handle(request):
+        if request.path == "/domains/example.com":
+            return FakeResponse(200, {"domain": {"name": "example.com"}})
+        if request.path.endswith("/records") and request.method == "GET":
+            return FakeResponse(200, {"domain_records": list(records)})
+        if request.method == "POST":
+            return FakeResponse(201, {"domain_record": {"id": 42, **request.json}})
+        if request.method == "PUT":
+            return FakeResponse(200, {"domain_record": request.json})
+        return FakeResponse(404, {"id": "not_found", "message": "Not found"})
+
+    provider = DigitalOceanProvider("token")
+    provider._session = FakeSession(API_URL, handle)
+    return provider
+
+
+def test_creates_missing_record():
+    provider = make_provider()
+    ip = ipaddress.IPv4Address("127.0.0.2")
+
+    record = provider.ensure_record("home.example.com", ip)
+
+    assert (record.zone_id, record.record_id) == ("example.com", "42")
+    body = {"type": "A", "name": "home", "data": "127.0.0.2", "ttl": 60}
+    request = provider._session.requests[-1]
+    assert request[:3] == ("POST", "/domains/example.com/records", body)
+
+
+def test_updates_existing_record():
+    provider = make_provider([{"id": 7, "type": "A", "name": "@"}])
+    ip = ipaddress.IPv4Address("127.0.0.3")
+
+    record = provider.ensure_record("example.com", ip)
+
+    assert record.record_id == "7"
+    assert provider._session.requests[-1][:3] == (
+        "PUT",
+        "/domains/example.com/records/7",
+        {"type": "A", "data": "127.0.0.3"},
+    )
+
+
+def test_rejected_token_is_not_a_missing_domain():
+    provider = DigitalOceanProvider("token")
+    body = {"id": "unauthorized", "message": "Unable to authenticate you"}
+    provider._session = FakeSession(API_URL, lambda request: FakeResponse(401, body))
+
+    with pytest.raises(AuthenticationError, match="Unable to authenticate you"):
+        provider.ensure_record("home.example.com", ipaddress.IPv4Address("127.0.0.2"))
@@ -0,0 +1,63 @@
+import pytest
+from cloudflare_dyndns.domains import (
+    expand_placeholders,
+    expand_subdomains,
+    hostname_domain,
+    is_excluded,
+    syntax_error,
+    with_www,
+)
+
+
+def test_expand_subdomains():
+    domains = expand_subdomains("example.com.", ["home,nas", " vpn ,@", "home"])
+    assert domains == [
+        "home.example.com",
+        "nas.example.com",
+        "vpn.example.com",
+        "example.com",
+    ]
+
+
+def test_syntax_error():
+    assert syntax_error("home.example.com") is None
+    assert syntax_error("*.example.com") is None
+    assert syntax_error("árvíztűrő.example.com") is None
+    assert syntax_error("home.*.example.com") == 'invalid label "*"'
+    assert syntax_error("home_.example.com") is None
+    assert syntax_error("a" * 64 + ".example.com") is not None
+    assert syntax_error("example") == "not a fully qualified domain name"
+
+
+def test_expand_placeholders():
+    hostname = "Web-01.dc1.internal"
+    domain = expand_placeholders("{hostname_short}.example.com", hostname)
+    assert domain == "web-01.example.com"
+    domain = expand_placeholders("{hostname}.example.com", hostname)
+    assert domain == "web-01.dc1.internal.example.com"
+    assert expand_placeholders("home.example.com", hostname) == "home.example.com"
+
+
+def test_hostname_domain():
+    domain = hostname_domain("example.com.", "web-01.dc1.internal")
+    assert domain == "web-01.example.com"
+    with pytest.raises(ValueError):
+        hostname_domain("example.com", "localhost.localdomain")
+
+
+def test_with_www():
+    domains = with_www(["example.com", "www.example.org", "example.org"])
+    assert domains == [
+        "example.com",
+        "www.example.com",
+        "www.example.org",
+        "example.org",
+    ]
+
+
+def test_is_excluded():
+    patterns = ["mail.*", "*.internal.example.com"]
+    assert is_excluded("MAIL.example.com.", patterns)
+    assert is_excluded("nas.internal.example.com", patterns)
+    assert not is_excluded("home.example.com", patterns)
+    assert not is_excluded("home.example.com", [])
@@ -0,0 +1,34 @@
+import ipaddress
+import pytest
+from conftest import FakeResponse, FakeSession
+from cloudflare_dyndns.duckdns import UPDATE_URL, DuckDNSError, DuckDNSProvider
+
+
+def make_provider(answer: str = "OK"):
+    provider = DuckDNSProvider("token")
+    provider._session = FakeSession(
+        UPDATE_URL, lambda request: FakeResponse(200, text=answer)
+    )
+    return provider
+
+
+def test_updates_ipv6():
+    provider = make_provider()
+
+    record = provider.ensure_record("myhome.duckdns.org", ipaddress.ip_address("::1"))
+
+    assert record.record_id == "myhome"
+    sent_params = [request.params for request in provider._session.requests]
+    assert sent_params == [{"domains": "myhome", "token": "token", "ipv6": "::1"}]
+
+
+def test_refused_update():
+    provider = make_provider("KO")
+    with pytest.raises(DuckDNSError):
+        provider.ensure_record("myhome.duckdns.org", ipaddress.ip_address("127.0.0.2"))
+
+
+def test_only_duckdns_subdomains():
+    provider = DuckDNSProvider("token")
+    with pytest.raises(DuckDNSError):
+        provider.ensure_record("example.com", ipaddress.ip_address("127.0.0.2"))
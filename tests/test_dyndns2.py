@@ -0,0 +1,20 @@
+import pytest
+from cloudflare_dyndns.dyndns2 import DynDNS2Error, check_response
+
+
+@pytest.mark.parametrize("text", ["good 127.0.0.2", "nochg 127.0.0.2\n"])
+def test_successful_update(text):
+    check_response(text)
+
+
+@pytest.mark.parametrize(
+    "text, reason",
+    [
+        ("badauth", "invalid username or password"),
+        ("nohost", "doesn't exist"),
+        ("something unexpected", "something unexpected"),
+    ],
+)
+def test_failed_update(text, reason):
+    with pytest.raises(DynDNS2Error, match=reason):
+        check_response(text)
@@ -0,0 +1,44 @@
+import ipaddress
+from conftest import FakeResponse, FakeSession
+from cloudflare_dyndns.cache import ZoneRecord
+from cloudflare_dyndns.gandi import API_URL, GandiProvider
+
+
+def make_provider():
+    def handle(request):
+        path = request.path
+        if path == "/domains/example.com" or path.startswith("/domains/example.com/"):
+            return FakeResponse(201 if request.method == "PUT" else 200, {})
+        return FakeResponse(404, {})
+
+    provider = GandiProvider("token")
+    provider._session = FakeSession(API_URL, handle)
+    return provider
+
+
+def test_puts_rrset_of_subdomain():
+    provider = make_provider()
+    ip = ipaddress.IPv6Address("::1")
+
+    record = provider.ensure_record("home.example.com", ip)
+
+    assert record.zone_id == "example.com"
+    assert provider._session.requests[-1][:3] == (
+        "PUT",
+        "/domains/example.com/records/home/AAAA",
+        {"rrset_values": ["::1"], "rrset_ttl": 300},
+    )
+
+
+def test_invalid_cache_falls_back_to_lookup():
+    provider = make_provider()
+    ip = ipaddress.IPv4Address("127.0.0.2")
+    cached = ZoneRecord(zone_id="old.example", record_id="")
+
+    record = provider.ensure_record("example.com", ip, cached=cached)
+
+    assert record.zone_id == "example.com"
+    assert provider._session.requests[-1][:2] == (
+        "PUT",
+        "/domains/example.com/records/@/A",
+    )
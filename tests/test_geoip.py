@@ -0,0 +1,63 @@
+import ipaddress
+from cloudflare_dyndns.geoip import (
+    GeoInfo,
+    GeoIPError,
+    GeoIPLookup,
+    MMDBLookup,
+    annotate,
+    from_ipinfo,
+    from_mmdb_record,
+)
+from cloudflare_dyndns.report import Report, UpdateResult
+
+IP = ipaddress.IPv4Address("203.0.113.7")
+
+
+class FakeReader:
+    def __init__(self, record):
+        self.record = record
+
+    def get(self, ip):
+        return self.record
+
+
+def test_databases_are_combined():
+    lookup = MMDBLookup.__new__(MMDBLookup)
+    country = {"country": {"iso_code": "HU", "names": {"en": "Hungary"}}}
+    asn = {
+        "autonomous_system_number": 5483,
+        "autonomous_system_organization": "Magyar Telekom",
+    }
+    lookup._readers = [FakeReader(country), FakeReader(asn)]
+
+    info = lookup.lookup(IP)
+
+    assert str(info) == "Hungary, AS5483 Magyar Telekom"
+
+
+def test_unknown_address_in_database():
+    assert str(from_mmdb_record({})) == ""
+
+
+def test_ipinfo_response():
+    info = from_ipinfo({"ip": str(IP), "country": "DE", "org": "AS3320 Telekom"})
+    assert info == GeoInfo(country_code="DE", asn=3320, organization="Telekom")
+
+
+def test_failed_lookup_is_not_fatal():
+    class FailingLookup(GeoIPLookup):
+        def lookup(self, ip):
+            raise GeoIPError("timeout")
+
+    assert annotate(FailingLookup(), IP) is None
+
+
+def test_location_in_summary():
+    result = UpdateResult(
+        record_type="A",
+        new_ip=IP,
+        updated_domains=["example.com"],
+        geo=GeoInfo(country_code="HU"),
+    )
+    summary = Report(results=[result]).summary()
+    assert summary == "A records of example.com updated to 203.0.113.7 (HU)"
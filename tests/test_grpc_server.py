@@ -0,0 +1,126 @@
+import ipaddress
+import re
+import time
+import pytest
+from cloudflare_dyndns import grpc_server
+from cloudflare_dyndns.daemon import Daemon
+from cloudflare_dyndns.report import Report, UpdateResult
+
+
+def make_daemon():
+    return Daemon(lambda force, addresses: Report(), 300, False, ["example.com"])
+
+
+def changed_report():
+    return Report(
+        results=[
+            UpdateResult(
+                record_type="A",
+                old_ip=ipaddress.IPv4Address("127.0.0.1"),
+                new_ip=ipaddress.IPv4Address("127.0.0.2"),
+                updated_domains=["example.com"],
+            )
+        ]
+    )
+
+
+def test_messages_match_the_proto_file():
+    proto = grpc_server.PROTO_FILE.read_text()
+    for name, fields in grpc_server.MESSAGES.items():
+        body = re.search(rf"message {name} {{(.*?)}}", proto, re.S).group(1)
+        declared = re.findall(r"^\s*((?:repeated )?\w+) (\w+) = (\d+);", body, re.M)
+        expected = [
+            (field_type, field_name, str(number))
+            for number, (field_name, field_type) in enumerate(fields, start=1)
+        ]
+        assert declared == expected, name
+
+
+def test_authorization():
+    service = grpc_server.ControlService(make_daemon(), "secret-token")
+    assert service.is_authorized([("authorization", "Bearer secret-token")])
+    assert not service.is_authorized([("authorization", "Bearer wrong")])
+    assert not service.is_authorized([("authorization", "secret-token")])
+    assert not service.is_authorized([])
+
+
+def test_trigger_update_with_addresses():
+    daemon = make_daemon()
+    service = grpc_server.ControlService(daemon, "secret-token")
+    response = service.trigger_update({"force": True, "ipv4": "127.0.0.2"})
+    assert response == {"accepted": True}
+    assert daemon._force is True
+    assert daemon._addresses == {"A": ipaddress.IPv4Address("127.0.0.2")}
+
+    with pytest.raises(ValueError):
+        service.trigger_update({"ipv6": "127.0.0.2"})
+    with pytest.raises(ValueError):
+        service.trigger_update({"ipv4": "not an address"})
+
+
+def test_get_status():
+    daemon = make_daemon()
+    daemon.last_report = changed_report()
+    daemon.last_run = time.time()
+    status = grpc_server.ControlService(daemon, "secret-token").get_status()
+    assert status["healthy"] is True
+    assert status["domains"] == ["example.com"]
+    assert status["interval"] == 300
+    assert status["results"] == [
+        {
+            "record_type": "A",
+            "old_ip": "127.0.0.1",
+            "new_ip": "127.0.0.2",
+            "updated_domains": ["example.com"],
+            "failed_domains": [],
+            "errors": [],
+            "skipped": False,
+        }
+    ]
+
+
+def test_stream_events_only_changes(monkeypatch):
+    monkeypatch.setattr(grpc_server, "STREAM_POLL_INTERVAL", 0.01)
+    daemon = make_daemon()
+    service = grpc_server.ControlService(daemon, "secret-token")
+    checks = []
+
+    def is_active():
+        if not checks:
+            # runs finishing while the client is connected
+            daemon._publish(1.0, Report())
+            daemon._publish(2.0, changed_report())
+        checks.append(True)
+        return len(checks) < 5
+
+    events = list(service.stream_events(only_changes=True, is_active=is_active))
+    assert len(events) == 1
+    assert events[0]["time"] == "1970-01-01T00:00:02+00:00"
+    assert events[0]["status"] == "changed"
+    assert events[0]["results"][0]["new_ip"] == "127.0.0.2"
+    assert daemon._subscribers == []
+
+
+def test_end_to_end():
+    grpc = pytest.importorskip("grpc")
+    daemon = make_daemon()
+    service = grpc_server.ControlService(daemon, "secret-token")
+    server = grpc_server.GRPCServer("127.0.0.1:0", service)
+    server.start()
+    try:
+        messages = server._messages
+        channel = grpc.insecure_channel(f"127.0.0.1:{server.port}")
+        get_status = channel.unary_unary(
+            f"/{grpc_server.SERVICE_NAME}/GetStatus",
+            request_serializer=messages["GetStatusRequest"].SerializeToString,
+            response_deserializer=messages["Status"].FromString,
+        )
+        metadata = [("authorization", "Bearer secret-token")]
+        status = get_status(messages["GetStatusRequest"](), metadata=metadata)
+        assert list(status.domains) == ["example.com"]
+
+        with pytest.raises(grpc.RpcError) as error:
+            get_status(messages["GetStatusRequest"]())
+        assert error.value.code() == grpc.StatusCode.UNAUTHENTICATED
+    finally:
+        server.stop()
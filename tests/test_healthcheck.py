@@ -0,0 +1,40 @@
+import os
+import time
+from cloudflare_dyndns.healthcheck import check_report_file
+from cloudflare_dyndns.report import Report
+
+
+def test_missing_report_file(tmp_path):
+    healthy, _ = check_report_file(tmp_path / "report.json", 3600)
+    assert not healthy
+
+
+def test_recent_successful_report(tmp_path):
+    report_file = tmp_path / "report.json"
+    report_file.write_text(Report().json())
+    healthy, _ = check_report_file(report_file, 3600)
+    assert healthy
+
+
+def test_failed_report(tmp_path):
+    report_file = tmp_path / "report.json"
+    report_file.write_text(Report(exit_code=2).json())
+    healthy, _ = check_report_file(report_file, 3600)
+    assert not healthy
+
+
+def test_old_report(tmp_path):
+    report_file = tmp_path / "report.json"
+    report_file.write_text(Report().json())
+    two_hours_ago = time.time() - 7200
+    os.utime(report_file, (two_hours_ago, two_hours_ago))
+    healthy, message = check_report_file(report_file, 3600)
+    assert not healthy
+    assert "7200 seconds ago" in message
+
+
+def test_report_file_with_status(tmp_path):
+    report_file = tmp_path / "report.json"
+    report_file.write_text(Report().to_json())
+    healthy, _ = check_report_file(report_file, 3600)
+    assert healthy
@@ -0,0 +1,56 @@
+import ipaddress
+import pytest
+from conftest import FakeResponse, FakeSession
+from cloudflare_dyndns.hetzner import API_URL, HetznerProvider
+from cloudflare_dyndns.cache import ZoneRecord
+from cloudflare_dyndns.providers import RateLimitedError
+
+
+def make_provider(records=()):
+    def handle(request):
+        if request.path == "/zones":
+            zones = [{"id": "zone-1", "name": "example.com"}]
+            return FakeResponse(200, {"zones": zones})
+        if request.path == "/records" and request.method == "GET":
+            return FakeResponse(200, {"records": list(records)})
+        if request.path == "/records" and request.method == "POST":
+            return FakeResponse(200, {"record": {"id": "new-record", **request.json}})
+        if request.path == "/records/record-1" and request.method == "PUT":
+            return FakeResponse(200, {"record": request.json})
+        return FakeResponse(404, {})
+
+    provider = HetznerProvider("token")
+    provider._session = FakeSession(API_URL, handle)
+    return provider
+
+
+def test_creates_missing_record():
+    provider = make_provider()
+    ip = ipaddress.IPv4Address("127.0.0.2")
+
+    record = provider.ensure_record("home.example.com", ip)
+
+    assert (record.zone_id, record.record_id) == ("zone-1", "new-record")
+    request = provider._session.requests[-1]
+    assert (request.method, request.path) == ("POST", "/records")
+    assert request.json["name"] == "home"
+    assert request.json["value"] == "127.0.0.2"
+
+
+def test_invalid_cache_falls_back_to_lookup():
+    provider = make_provider([{"id": "record-1", "type": "A", "name": "@"}])
+    ip = ipaddress.IPv4Address("127.0.0.3")
+    cached = ZoneRecord(zone_id="zone-1", record_id="deleted-record")
+
+    record = provider.ensure_record("example.com", ip, cached=cached)
+
+    assert record.record_id == "record-1"
+    assert provider._session.requests[-1][:2] == ("PUT", "/records/record-1")
+
+
+def test_rate_limiting_is_not_a_missing_zone():
+    provider = HetznerProvider("token")
+    provider._session = FakeSession(API_URL, lambda request: FakeResponse(429, {}))
+
+    with pytest.raises(RateLimitedError):
+        provider.ensure_record("home.example.com", ipaddress.IPv4Address("127.0.0.2"))
@@ -0,0 +1,64 @@
+import ipaddress
+from cloudflare_dyndns.cache import IPCache, IPChange
+from cloudflare_dyndns.history import (
+    adaptive_ttl,
+    change_stats,
+    format_duration,
+    format_stats,
+    record_change,
+)
+
+HOUR = 3600
+DAY = 24 * HOUR
+
+
+def test_only_changes_are_recorded():
+    ip_cache = IPCache()
+    first = ipaddress.IPv4Address("127.0.0.2")
+    second = ipaddress.IPv4Address("127.0.0.3")
+
+    assert record_change(ip_cache, first, now=100)
+    assert not record_change(ip_cache, first, now=200)
+    assert record_change(ip_cache, second, now=300)
+
+    assert [(c.time, c.address) for c in ip_cache.changes] == [
+        (100, first),
+        (300, second),
+    ]
+
+
+def test_ttl_grows_while_the_address_is_stable():
+    changes = [IPChange(time=0, address=ipaddress.IPv4Address("127.0.0.2"))]
+    assert adaptive_ttl(changes, 60, 3600, now=10) == 60
+    assert adaptive_ttl(changes, 60, 3600, now=2 * HOUR) == 300
+    assert adaptive_ttl(changes, 60, 3600, now=DAY) == 3600
+    assert adaptive_ttl(changes, 60, 3600, now=30 * DAY) == 3600
+    assert adaptive_ttl(changes, 120, 86400, now=10) == 120
+    assert adaptive_ttl([], 300, 3600) == 300
+
+
+def test_change_stats():
+    changes = [
+        IPChange(time=0, address=ipaddress.IPv4Address("127.0.0.2")),
+        IPChange(time=2 * DAY, address=ipaddress.IPv4Address("127.0.0.3")),
+        IPChange(time=3 * DAY, address=ipaddress.IPv4Address("127.0.0.4")),
+    ]
+
+    stats = change_stats(changes, now=14 * DAY)
+
+    assert stats.changes == 2
+    assert stats.changes_per_week == 1.0
+    assert stats.longest_stable == 11 * DAY
+    assert stats.shortest_stable == DAY
+    assert sum(stats.changes_by_hour) == 2
+
+
+def test_no_history():
+    assert change_stats([]).changes_per_week is None
+    assert format_stats(change_stats([])) == ["No addresses seen yet."]
+
+
+def test_format_duration():
+    assert format_duration(30) == "30s"
+    assert format_duration(90 * 60) == "1h 30m"
+    assert format_duration(3 * DAY + 2 * HOUR + 5 * 60) == "3d 2h"
@@ -0,0 +1,50 @@
+import pytest
+import requests
+from cloudflare_dyndns import http_proxy
+
+
+@pytest.mark.parametrize("url", ["proxy:3128", "ftp://proxy", "http://"])
+def test_invalid_proxy_url(url):
+    with pytest.raises(ValueError):
+        http_proxy.parse_proxy_url(url)
+
+
+def test_requests_go_through_proxy(monkeypatch):
+    sent = []
+
+    def send(session, request, **kwargs):
+        sent.append((request.url, kwargs.get("proxies")))
+
+    monkeypatch.setattr(requests.Session, "send", http_proxy._proxied_send(send))
+    monkeypatch.setattr(http_proxy, "_proxy_url", "http://proxy:3128")
+    monkeypatch.setenv("NO_PROXY", "internal.example.com")
+    session = requests.Session()
+
+    session.send(requests.Request("GET", "https://1.1.1.1/").prepare())
+    session.send(requests.Request("GET", "http://internal.example.com/").prepare())
+
+    proxies = {"http": "http://proxy:3128", "https": "http://proxy:3128"}
+    assert sent == [
+        ("https://1.1.1.1/", proxies),
+        ("http://internal.example.com/", None),
+    ]
+
+
+def test_only_detection_through_proxy(monkeypatch):
+    sent = []
+
+    def send(session, request, **kwargs):
+        sent.append(kwargs.get("proxies"))
+
+    monkeypatch.setattr(requests.Session, "send", http_proxy._proxied_send(send))
+    monkeypatch.setattr(http_proxy, "_proxy_url", "socks5h://localhost:1080")
+    monkeypatch.setattr(http_proxy, "_proxied_traffic", "detection")
+    session = requests.Session()
+    request = requests.Request("GET", "https://1.1.1.1/").prepare()
+
+    session.send(request)
+    with http_proxy.detection():
+        session.send(request)
+
+    proxies = {"http": "socks5h://localhost:1080", "https": "socks5h://localhost:1080"}
+    assert sent == [None, proxies]
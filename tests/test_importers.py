@@ -0,0 +1,137 @@
+import pytest
+from cloudflare_dyndns.importers import (
+    ConfigImportError,
+    parse_ddclient,
+    parse_ddns_go,
+    parse_inadyn,
+)
+
+
+DDCLIENT_CONF = """\
+# ddclient configuration
+daemon=5m
+ssl=yes
+use=if, if=eth0
+usev6=webv6, webv6=https://api6.ipify.org
+
+protocol=cloudflare, \\
+zone=example.com, \\
+ttl=1, \\
+login=token, \\
+password='secret-token' \\
+home.example.com,vpn.example.com
+
+protocol=dyndns2, login=me, password=other other.dyndns.org
+protocol=cloudflare, zone=example.org, login=token, password=secret-token nas.example.org
+"""
+
+
+def test_parse_ddclient():
+    config = parse_ddclient(DDCLIENT_CONF)
+
+    assert config.api_token == "secret-token"
+    assert config.domains == ["home.example.com", "vpn.example.com", "nas.example.org"]
+    assert config.ipv4 and config.ipv6
+    assert config.ipv4_sources == ["interface:eth0"]
+    assert config.ipv6_sources == ["http:https://api6.ipify.org"]
+    assert config.interval == 300
+    assert config.to_env_file() == (
+        "# not imported: ttl=1 (records keep their TTL)\n"
+        "CLOUDFLARE_API_TOKEN=secret-token\n"
+        "CLOUDFLARE_DOMAINS='home.example.com vpn.example.com nas.example.org'\n"
+        "CLOUDFLARE_DYNDNS_IPV6=true\n"
+        "CLOUDFLARE_DYNDNS_IPV4_SOURCES=interface:eth0\n"
+        "CLOUDFLARE_DYNDNS_IPV6_SOURCES=http:https://api6.ipify.org\n"
+        "CLOUDFLARE_DYNDNS_INTERVAL=300\n"
+    )
+
+
+def test_ddclient_global_api_key_cant_be_imported():
+    conf = "protocol=cloudflare, login=me@example.com, password=key home.example.com"
+    with pytest.raises(ConfigImportError):
+        parse_ddclient(conf)
+
+
+def test_parse_ddns_go():
+    data = {
+        "dnsconf": [
+            {
+                "name": "home",
+                "ipv4": {
+                    "enable": True,
+                    "gettype": "netInterface",
+                    "netinterface": "eth0",
+                    "domains": ["home:example.com?Proxied=true", "vpn.example.com"],
+                },
+                "ipv6": {
+                    "enable": True,
+                    "gettype": "url",
+                    "url": "https://api6.ipify.org, https://6.ident.me",
+                    "domains": ["home:example.com"],
+                },
+                "dns": {"name": "cloudflare", "id": "", "secret": "secret-token"},
+            },
+            {"dns": {"name": "alidns", "secret": "other"}},
+        ]
+    }
+
+    config = parse_ddns_go(data)
+
+    assert config.api_token == "secret-token"
+    assert config.domains == ["home.example.com", "vpn.example.com"]
+    assert config.domain_families == {"vpn.example.com": "4"}
+    assert config.proxied
+    assert config.ipv4_sources == ["interface:eth0"]
+    assert config.ipv6_sources == [
+        "http:https://api6.ipify.org",
+        "http:https://6.ident.me",
+    ]
+
+
+INADYN_CONF = """\
+period = 300
+iface = eth0
+
+provider default@cloudflare.com {
+    username = example.com
+    password = "secret-token"
+    hostname = { "home.example.com", "vpn.example.com" }
+    ttl = 1
+    proxied = true
+}
+
+provider ipv6@cloudflare.com:2 {
+    username = example.com
+    password = secret-token
+    hostname = home.example.com
+    checkip-command = /usr/local/bin/ipv6-address
+}
+
+provider default@dyndns.org {
+    username = me
+    password = other
+    hostname = other.dyndns.org
+}
+"""
+
+
+def test_parse_inadyn():
+    config = parse_inadyn(INADYN_CONF)
+
+    assert config.api_token == "secret-token"
+    assert config.domains == ["home.example.com", "vpn.example.com"]
+    assert config.ipv4 and config.ipv6
+    assert config.domain_families == {"vpn.example.com": "4"}
+    assert config.proxied
+    assert config.ipv4_sources == ["interface:eth0"]
+    assert config.ipv6_sources == ["exec:/usr/local/bin/ipv6-address"]
+    assert config.interval == 300
+    assert "CLOUDFLARE_DYNDNS_DOMAIN_FAMILIES=vpn.example.com=4\n" in (
+        config.to_env_file()
+    )
+
+
+def test_different_api_tokens():
+    conf = INADYN_CONF.replace("password = secret-token", "password = other-token")
+    with pytest.raises(ConfigImportError):
+        parse_inadyn(conf)
@@ -0,0 +1,59 @@
+import plistlib
+from pathlib import Path
+from cloudflare_dyndns import install
+
+
+def test_systemd_service_unit():
+    unit = install.systemd_service_unit(
+        "/usr/bin/cloudflare-dyndns",
+        ["-6", "*.example.com"],
+        Path("/etc/cloudflare-dyndns/api-token"),
+    )
+    assert unit.startswith("[Unit]\n")
+    assert (
+        "ExecStart=/usr/bin/cloudflare-dyndns "
+        "--api-token-file ${CREDENTIALS_DIRECTORY}/api-token "
+        "--cache-file %C/cloudflare-dyndns/ip.cache -6 '*.example.com'\n"
+    ) in unit
+    assert "LoadCredential=api-token:/etc/cloudflare-dyndns/api-token\n" in unit
+    assert "DynamicUser=yes\n" in unit
+    assert "ProtectSystem=strict\n" in unit
+
+
+def test_systemd_daemon_service_unit():
+    unit = install.systemd_service_unit(
+        "/usr/bin/cloudflare-dyndns", ["example.com"], Path("/token"), interval=300
+    )
+    assert "Type=notify\n" in unit
+    assert "WatchdogSec=" in unit
+    assert "ip.cache --interval 300 example.com\n" in unit
+
+
+def test_systemd_timer_unit():
+    timer = install.systemd_timer_unit("5min")
+    assert "OnUnitActiveSec=5min\n" in timer
+    assert "WantedBy=timers.target\n" in timer
+
+
+def test_launchd_plist():
+    plist = plistlib.loads(
+        install.launchd_plist(
+            "/usr/local/bin/cloudflare-dyndns",
+            ["example.com"],
+            Path("/token"),
+            Path("/ip.cache"),
+            Path("/dyndns.log"),
+            interval=300,
+            network_change=True,
+        )
+    )
+    assert plist["ProgramArguments"] == [
+        "/usr/local/bin/cloudflare-dyndns",
+        "--api-token-file",
+        "/token",
+        "--cache-file",
+        "/ip.cache",
+        "example.com",
+    ]
+    assert plist["StartInterval"] == 300
+    assert plist["WatchPaths"] == [install.MACOS_NETWORK_CHANGE_PATH]
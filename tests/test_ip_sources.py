@@ -0,0 +1,104 @@
+import http.server
+import struct
+import threading
+import time
+import pytest
+from cloudflare_dyndns import breaker, dns_lookup, ip_services, stun
+
+
+def test_parse_sources_in_order():
+    sources = ip_services.parse_sources(["interface:ppp0", "stun", "http"], 4)
+    assert isinstance(sources[0], ip_services.InterfaceSource)
+    assert isinstance(sources[1], ip_services.STUNSource)
+    assert sources[2:] == ip_services.IPV4_SERVICES
+
+
+def test_parse_unknown_source():
+    with pytest.raises(ValueError):
+        ip_services.parse_sources(["carrier-pigeon"], 4)
+
+
+def test_exec_source():
+    sources = ip_services.parse_sources(["exec:echo 127.0.0.2"], 4)
+    assert str(ip_services.get_ipv4(sources)) == "127.0.0.2"
+
+
+def test_dns_txt_response():
+    query_id = 0x1234
+    question = dns_lookup._encode_name("o-o.myaddr.l.google.com") + b"\0\x10\0\x01"
+    txt = b"\x0b203.0.113.7"
+    answer = b"\xc0\x0c" + struct.pack("!HHIH", 16, 1, 60, len(txt)) + txt
+    header = struct.pack("!HHHHHH", query_id, 0x8180, 1, 1, 0, 0)
+    response = header + question + answer
+    assert dns_lookup.parse_response(response, query_id, 16) == ["203.0.113.7"]
+
+
+def test_stun_xor_mapped_address():
+    transaction_id = bytes(range(12))
+    # 192.0.2.1:32853 XOR-ed with the magic cookie, from RFC5769 section 2.2
+    value = b"\x00\x01\xa1\x47\xe1\x12\xa6\x43"
+    attribute = struct.pack("!HH", stun.XOR_MAPPED_ADDRESS, len(value)) + value
+    header = struct.pack(
+        "!HHI", stun.BINDING_SUCCESS, len(attribute), stun.MAGIC_COOKIE
+    )
+    response = header + transaction_id + attribute
+    assert stun.parse_response(response, transaction_id) == "192.0.2.1"
+
+
+class EchoHandler(http.server.BaseHTTPRequestHandler):
+    protocol_version = "HTTP/1.1"
+    connections = 0
+
+    def setup(self):
+        super().setup()
+        EchoHandler.connections += 1
+
+    def do_GET(self):
+        body = self.client_address[0].encode()
+        self.send_response(200)
+        self.send_header("Content-Length", str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, *args):
+        pass
+
+
+def test_http_service_reuses_connections():
+    server = http.server.ThreadingHTTPServer(("127.0.0.1", 0), EchoHandler)
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+    service = ip_services.IPService("echo", f"http://127.0.0.1:{server.server_port}/")
+    try:
+        assert service.get_ip(4) == "127.0.0.1"
+        assert service.get_ip(4) == "127.0.0.1"
+    finally:
+        server.shutdown()
+        server.server_close()
+    assert EchoHandler.connections == 1
+
+
+def test_failing_source_is_skipped_for_a_while(monkeypatch):
+    dead, working = ip_services.parse_sources(["exec:false", "exec:echo 127.0.0.2"], 4)
+    for _ in range(breaker.FAILURE_THRESHOLD):
+        assert str(ip_services.get_ipv4([dead, working])) == "127.0.0.2"
+    assert breaker.open_until(dead.description) is not None
+
+    def fail(version):
+        raise AssertionError("the open circuit should be skipped")
+
+    monkeypatch.setattr(dead, "get_ip", fail)
+    assert str(ip_services.get_ipv4([dead, working])) == "127.0.0.2"
+
+    # after the cooldown it gets one more chance, and it's fine again
+    breaker.get()[dead.description].open_until = 0
+    monkeypatch.setattr(dead, "get_ip", lambda version: "127.0.0.3")
+    assert str(ip_services.get_ipv4([dead, working])) == "127.0.0.3"
+    assert dead.description not in breaker.get()
+
+
+def test_every_source_is_tried_when_all_of_them_failed():
+    source = ip_services.parse_sources(["exec:echo 127.0.0.2"], 4)[0]
+    breaker.get()[source.description] = breaker.SourceState(
+        consecutive_failures=5, open_until=time.time() + 3600
+    )
+    assert str(ip_services.get_ipv4([source])) == "127.0.0.2"
@@ -0,0 +1,52 @@
+import time
+import pytest
+from cloudflare_dyndns import leader
+from cloudflare_dyndns.leader import LeaseLock, format_lease, parse_lease
+
+
+class FakeCloudFlare:
+    def __init__(self):
+        self.content = None
+
+    def get_txt_record(self, domain):
+        return None if self.content is None else ("record-id", self.content)
+
+    def set_txt_record(self, domain, content, record_id=None):
+        self.content = content
+        return "record-id"
+
+
+@pytest.fixture(autouse=True)
+def no_settle_time(monkeypatch):
+    monkeypatch.setattr(leader, "SETTLE_TIME", 0)
+
+
+def test_parse_lease():
+    assert parse_lease(format_lease("node1", 1234)) == ("node1", 1234)
+    assert parse_lease('"holder=node1 expires=1234"') == ("node1", 1234)
+    assert parse_lease("garbage") == (None, 0)
+
+
+def test_only_one_leader():
+    cf = FakeCloudFlare()
+    first = LeaseLock("_lease.example.com", "node1", 300)
+    second = LeaseLock("_lease.example.com", "node2", 300)
+    assert first.acquire(cf)
+    assert not second.acquire(cf)
+    # renewal
+    assert first.acquire(cf)
+
+
+def test_take_over_expired_lease():
+    cf = FakeCloudFlare()
+    cf.content = format_lease("node1", time.time() - 1)
+    assert LeaseLock("_lease.example.com", "node2", 300).acquire(cf)
+
+
+def test_release():
+    cf = FakeCloudFlare()
+    first = LeaseLock("_lease.example.com", "node1", 300)
+    first.acquire(cf)
+    first.release(cf)
+    assert not first.is_leader
+    assert LeaseLock("_lease.example.com", "node2", 300).acquire(cf)
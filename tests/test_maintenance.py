@@ -0,0 +1,62 @@
+import ipaddress
+import pytest
+from click.testing import CliRunner
+from cloudflare_dyndns.cli import main
+from cloudflare_dyndns.maintenance import (
+    InvalidMaintenanceFile,
+    maintenance_file,
+    parse_maintenance_ips,
+    read_maintenance,
+    write_maintenance,
+)
+
+
+def test_write_and_read(tmp_path):
+    path = maintenance_file(tmp_path / "ip.cache")
+    assert path.name == "ip.cache.maintenance"
+    assert read_maintenance(path) == {}
+
+    addresses = parse_maintenance_ips(["203.0.113.10", "2001:db8::10"])
+    write_maintenance(path, addresses)
+    assert read_maintenance(path) == {
+        "A": ipaddress.ip_address("203.0.113.10"),
+        "AAAA": ipaddress.ip_address("2001:db8::10"),
+    }
+
+    write_maintenance(path, {})
+    assert not path.exists()
+
+
+@pytest.mark.parametrize("content", ["[]", '{"A": "2001:db8::10"}', '{"A": "x"}'])
+def test_invalid_file(tmp_path, content):
+    path = tmp_path / "ip.cache.maintenance"
+    path.write_text(content)
+    with pytest.raises(InvalidMaintenanceFile):
+        read_maintenance(path)
+
+
+def test_one_address_per_version():
+    with pytest.raises(ValueError):
+        parse_maintenance_ips(["203.0.113.10", "203.0.113.11"])
+
+
+def test_maintenance_command(tmp_path):
+    cache_file = str(tmp_path / "ip.cache")
+    socket_path = str(tmp_path / "no-daemon.sock")
+    options = ["--cache-file", cache_file, "--socket", socket_path]
+
+    result = CliRunner().invoke(main, ["maintenance", "on", *options])
+    assert result.exit_code == 2
+
+    result = CliRunner().invoke(
+        main, ["maintenance", "on", "--ip", "203.0.113.10", *options]
+    )
+    assert result.exit_code == 0
+    assert "applied at the next update" in result.output
+    assert read_maintenance(maintenance_file(cache_file)) == {
+        "A": ipaddress.ip_address("203.0.113.10")
+    }
+
+    result = CliRunner().invoke(main, ["maintenance", "off", *options])
+    assert result.exit_code == 0
+    assert read_maintenance(maintenance_file(cache_file)) == {}
@@ -0,0 +1,11 @@
+from cloudflare_dyndns.metrics import StatsdClient
+
+
+def test_statsd_address_bare_ipv6():
+    client = StatsdClient("::1")
+    assert client._address[:2] == ("::1", 8125)
+
+
+def test_statsd_address_with_port():
+    client = StatsdClient("[::1]:9125")
+    assert client._address[:2] == ("::1", 9125)
@@ -0,0 +1,32 @@
+import pytest
+from cloudflare_dyndns.namecheap import NamecheapError, check_response, split_host
+
+ERROR_RESPONSE = b"""<?xml version="1.0"?>
+<interface-response>
+  <Command>SETDNSHOST</Command>
+  <Language>eng</Language>
+  <ErrCount>1</ErrCount>
+  <errors><Err1>Passwords do not match</Err1></errors>
+  <Done>true</Done>
+</interface-response>"""
+
+SUCCESS_RESPONSE = b"""<?xml version="1.0"?>
+<interface-response>
+  <Command>SETDNSHOST</Command>
+  <Language>eng</Language>
+  <IP>127.0.0.2</IP>
+  <ErrCount>0</ErrCount>
+  <Done>true</Done>
+</interface-response>"""
+
+
+def test_split_host():
+    assert split_host("home.example.com") == ("home", "example.com")
+    assert split_host("a.b.example.com") == ("a.b", "example.com")
+    assert split_host("example.com") == ("@", "example.com")
+
+
+def test_check_response():
+    check_response(SUCCESS_RESPONSE)
+    with pytest.raises(NamecheapError, match="Passwords do not match"):
+        check_response(ERROR_RESPONSE)
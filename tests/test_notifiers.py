@@ -0,0 +1,162 @@
+import hashlib
+import hmac
+import ipaddress
+import json
+import subprocess
+from conftest import FakeResponse
+from cloudflare_dyndns import notifiers
+from cloudflare_dyndns.notifiers import (
+    CommandHook,
+    DesktopNotifier,
+    MatrixNotifier,
+    PushMonitorNotifier,
+    WebhookNotifier,
+)
+from cloudflare_dyndns.report import Report, UpdateResult
+
+
+def make_report():
+    return Report(
+        results=[
+            UpdateResult(
+                record_type="A",
+                old_ip=ipaddress.IPv4Address("127.0.0.1"),
+                new_ip=ipaddress.IPv4Address("127.0.0.2"),
+                updated_domains=["example.com"],
+                failed_domains=['bad"domain.com'],
+            )
+        ]
+    )
+
+
+def test_webhook_default_payload():
+    notifier = WebhookNotifier(["http://localhost"])
+    payload = json.loads(notifier.render(make_report()))
+    assert payload["status"] == "failed"
+    assert payload["results"][0]["new_ip"] == "127.0.0.2"
+    assert payload["results"][0]["updated_domains"] == ["example.com"]
+
+
+def test_webhook_template(tmp_path):
+    template = tmp_path / "template.json"
+    template.write_text('{"text": "$new_ipv4 $failed_domains", "old": "$old_ipv6"}')
+    notifier = WebhookNotifier(["http://localhost"], template_path=template)
+    payload = json.loads(notifier.render(make_report()))
+    assert payload == {"text": '127.0.0.2 bad"domain.com', "old": ""}
+
+
+def test_webhook_signature():
+    notifier = WebhookNotifier(["http://localhost"], secret="secret")
+    expected = hmac.new(b"secret", b"body", hashlib.sha256).hexdigest()
+    assert notifier.sign(b"body") == f"sha256={expected}"
+
+
+def test_command_hook_environment(monkeypatch):
+    runs = []
+
+    def run(command, **kwargs):
+        runs.append((command, kwargs))
+        return subprocess.CompletedProcess(command, 0)
+
+    monkeypatch.setattr(notifiers.subprocess, "run", run)
+    monkeypatch.setenv("PATH", "/usr/bin")
+    hook = CommandHook("/usr/local/bin/on-change", on_change=True)
+
+    assert hook.should_notify(make_report())
+    hook.notify(make_report())
+
+    [(command, kwargs)] = runs
+    assert command == "/usr/local/bin/on-change"
+    assert kwargs["shell"] is True
+    env = kwargs["env"]
+    # the environment of the process is kept
+    assert env["PATH"] == "/usr/bin"
+    assert env["DYN_STATUS"] == "failed"
+    assert (env["DYN_OLD_IPV4"], env["DYN_IPV4"]) == ("127.0.0.1", "127.0.0.2")
+    assert (env["DYN_OLD_IPV6"], env["DYN_IPV6"]) == ("", "")
+    assert env["DYN_DOMAINS"] == "example.com"
+    assert env["DYN_FAILED_DOMAINS"] == 'bad"domain.com'
+
+
+def test_command_hook_only_on_error():
+    hook = CommandHook("true", on_error=True)
+    unchanged = Report(results=[UpdateResult(record_type="A")])
+    assert hook.should_notify(make_report())
+    assert not hook.should_notify(unchanged)
+
+
+def test_matrix_message(monkeypatch):
+    requests = []
+
+    def put(url, **kwargs):
+        requests.append((url, kwargs))
+        return FakeResponse()
+
+    monkeypatch.setattr(notifiers.requests, "put", put)
+    notifier = MatrixNotifier("https://matrix.example.com/", "token", "!r:example.com")
+
+    notifier.notify(make_report())
+
+    [(url, kwargs)] = requests
+    room_url = "https://matrix.example.com/_matrix/client/v3/rooms/%21r%3Aexample.com"
+    assert url.startswith(f"{room_url}/send/m.room.message/")
+    assert kwargs["headers"] == {"Authorization": "Bearer token"}
+    assert kwargs["json"] == {
+        "msgtype": "m.text",
+        "body": f"cloudflare-dyndns failed: {make_report().summary()}",
+    }
+
+
+def test_desktop_notification_on_linux(monkeypatch):
+    runs = []
+    monkeypatch.setattr(notifiers.sys, "platform", "linux")
+    monkeypatch.setattr(notifiers.shutil, "which", lambda name: f"/usr/bin/{name}")
+    monkeypatch.setattr(
+        notifiers.subprocess, "run", lambda command, **kwargs: runs.append(command)
+    )
+    changed = make_report()
+    changed.results[0].failed_domains = []
+
+    DesktopNotifier().notify(changed)
+    DesktopNotifier().notify(make_report())
+
+    message = "Public IP changed to 127.0.0.2, 1 record updated"
+    assert runs == [
+        ["notify-send", "--urgency", "normal", "Cloudflare DynDNS", message],
+        [
+            "notify-send",
+            "--urgency",
+            "critical",
+            "Cloudflare DynDNS",
+            make_report().summary(),
+        ],
+    ]
+
+
+def test_push_monitor_heartbeat(monkeypatch):
+    requests = []
+
+    def get(url, **kwargs):
+        requests.append((url, kwargs["params"]))
+        return FakeResponse()
+
+    monkeypatch.setattr(notifiers.requests, "get", get)
+    unchanged = Report(results=[UpdateResult(record_type="A")])
+    kuma = PushMonitorNotifier("https://kuma.example.com/api/push/key")
+    cronitor = PushMonitorNotifier("https://cronitor.link/p/key", style="cronitor")
+
+    # even when there is nothing to report
+    assert kuma.should_notify(unchanged)
+    kuma.notify(unchanged)
+    kuma.notify(make_report())
+    cronitor.notify(make_report())
+
+    summary = make_report().summary()
+    assert requests == [
+        (
+            "https://kuma.example.com/api/push/key",
+            {"status": "up", "msg": "Every domain is up-to-date."},
+        ),
+        ("https://kuma.example.com/api/push/key", {"status": "down", "msg": summary}),
+        ("https://cronitor.link/p/key", {"state": "fail", "message": summary}),
+    ]
@@ -0,0 +1,78 @@
+import pytest
+from cloudflare_dyndns.plan import Change, format_plan, plan_record
+from cloudflare_dyndns.providers import CurrentRecord, DNSProvider, NotSupportedError
+
+
+class FakeProvider(DNSProvider):
+    def __init__(self, records=None):
+        self.records = records
+
+    def verify_credentials(self):
+        pass
+
+    def ensure_record(self, domain, ip, proxied=False, cached=None, ttl=None):
+        raise AssertionError("planning must not change anything")
+
+    def delete_record(self, domain, record_type):
+        raise AssertionError("planning must not change anything")
+
+    def current_records(self, domain, record_type):
+        if self.records is None:
+            raise NotSupportedError("no lookup")
+        return self.records
+
+
+@pytest.mark.parametrize(
+    "records, addresses, proxied, expected",
+    [
+        ([], ["192.0.2.1"], False, Change("create", "A", "a.test", new="192.0.2.1")),
+        ([CurrentRecord("192.0.2.1", False)], ["192.0.2.1"], False, None),
+        ([], [], False, None),
+        (
+            [CurrentRecord("192.0.2.1", False)],
+            [],
+            False,
+            Change("delete", "A", "a.test", old="192.0.2.1"),
+        ),
+        (
+            [CurrentRecord("192.0.2.1", False)],
+            ["192.0.2.2"],
+            False,
+            Change("update", "A", "a.test", "192.0.2.1", "192.0.2.2", False, False),
+        ),
+        (
+            [CurrentRecord("192.0.2.1", False)],
+            ["192.0.2.1"],
+            True,
+            Change("update", "A", "a.test", "192.0.2.1", "192.0.2.1", False, True),
+        ),
+    ],
+)
+def test_plan_record(records, addresses, proxied, expected):
+    provider = FakeProvider(records)
+    assert plan_record(provider, "a.test", "A", addresses, proxied) == expected
+
+
+def test_same_ipv6_address_written_differently():
+    provider = FakeProvider([CurrentRecord("2001:DB8:0:0::1", None)])
+    assert plan_record(provider, "a.test", "AAAA", ["2001:db8::1"], False) is None
+
+
+def test_unknown_current_records():
+    change = plan_record(FakeProvider(), "a.test", "A", ["192.0.2.1"], False)
+    assert change == Change("update", "A", "a.test", "(unknown)", "192.0.2.1")
+
+
+def test_format_plan():
+    changes = [
+        Change("update", "A", "b.test", "192.0.2.1", "192.0.2.2", False, True),
+        Change("create", "AAAA", "a.test", new="2001:db8::1"),
+        Change("create", "A", "a.test", new="192.0.2.1"),
+    ]
+    assert format_plan(changes) == [
+        "+ create  A     a.test  192.0.2.1",
+        "+ create  AAAA  a.test  2001:db8::1",
+        "~ update  A     b.test  192.0.2.1 -> 192.0.2.2  proxied: false -> true",
+        "Plan: 2 to create, 1 to update, 0 to delete.",
+    ]
+    assert format_plan([]) == ["No changes."]
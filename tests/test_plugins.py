@@ -0,0 +1,73 @@
+import ipaddress
+import sys
+import pytest
+from cloudflare_dyndns import ip_services, plugins, registry
+from cloudflare_dyndns.exec_provider import ExecProvider, ExecProviderError
+from cloudflare_dyndns.providers import DNSProvider
+
+PROVIDER_SCRIPT = """
+import json, sys
+request = json.load(sys.stdin)
+if request["domain"] == "bad.example.com":
+    sys.exit("no such zone")
+print(json.dumps({"zone_id": "zone", "record_id": request["ip"]}))
+"""
+
+
+def make_provider(tmp_path):
+    script = tmp_path / "provider.py"
+    script.write_text(PROVIDER_SCRIPT)
+    return ExecProvider(f"{sys.executable} {script}")
+
+
+def test_exec_provider(tmp_path):
+    provider = make_provider(tmp_path)
+    record = provider.ensure_record("example.com", ipaddress.ip_address("::1"))
+    assert (record.zone_id, record.record_id) == ("zone", "::1")
+
+
+def test_exec_provider_error(tmp_path):
+    provider = make_provider(tmp_path)
+    with pytest.raises(ExecProviderError, match="no such zone"):
+        provider.ensure_record("bad.example.com", ipaddress.ip_address("::1"))
+
+
+class FakeEntryPoint:
+    def __init__(self, name, obj):
+        self.name = name
+        self._obj = obj
+
+    def load(self):
+        if isinstance(self._obj, Exception):
+            raise self._obj
+        return self._obj
+
+
+class PluginProvider(DNSProvider):
+    def ensure_record(self, domain, ip, proxied=False, cached=None, ttl=None):
+        pass
+
+    def delete_record(self, domain, record_type):
+        pass
+
+    def verify_credentials(self):
+        pass
+
+
+def test_load_plugins(monkeypatch):
+    entry_points = {
+        plugins.PROVIDERS_GROUP: [
+            FakeEntryPoint("plugin", PluginProvider),
+            FakeEntryPoint("broken", ImportError("missing dependency")),
+        ],
+        plugins.IP_SOURCES_GROUP: [FakeEntryPoint("plugin", lambda arg, v: [])],
+    }
+    monkeypatch.setattr(plugins, "_entry_points", lambda group: entry_points[group])
+    monkeypatch.setattr(registry, "PROVIDER_TYPES", dict(registry.PROVIDER_TYPES))
+    monkeypatch.setattr(ip_services, "SOURCE_TYPES", dict(ip_services.SOURCE_TYPES))
+
+    plugins.load_plugins()
+
+    assert isinstance(registry.create_provider("plugin"), PluginProvider)
+    assert "broken" not in registry.PROVIDER_TYPES
+    assert ip_services.parse_sources(["plugin:argument"], 4) == []
@@ -0,0 +1,42 @@
+import ipaddress
+from conftest import FakeResponse, FakeSession
+from cloudflare_dyndns.porkbun import API_URL, PorkbunProvider
+
+
+def make_provider(records):
+    def handle(request):
+        if request.path == "/dns/retrieve/example.com":
+            return FakeResponse(200, {"status": "SUCCESS", "records": records})
+        if request.path.startswith("/dns/retrieveByNameType/example.com/"):
+            return FakeResponse(200, {"status": "SUCCESS", "records": records})
+        if request.path.startswith(("/dns/editByNameType/", "/dns/create/")):
+            return FakeResponse(200, {"status": "SUCCESS", "id": 123})
+        return FakeResponse(200, {"status": "ERROR", "message": "Invalid domain."})
+
+    provider = PorkbunProvider("pk1_key", "sk1_secret")
+    provider._session = FakeSession(API_URL, handle)
+    return provider
+
+
+def test_creates_missing_record():
+    provider = make_provider([])
+
+    record = provider.ensure_record("home.example.com", ipaddress.ip_address("::1"))
+
+    assert (record.zone_id, record.record_id) == ("example.com", "123")
+    request = provider._session.requests[-1]
+    assert request.path == "/dns/create/example.com"
+    body = request.json
+    assert body["apikey"] == "pk1_key"
+    assert (body["name"], body["type"], body["content"]) == ("home", "AAAA", "::1")
+
+
+def test_edits_existing_record():
+    provider = make_provider([{"id": "456", "type": "A", "name": "example.com"}])
+
+    record = provider.ensure_record("example.com", ipaddress.ip_address("127.0.0.2"))
+
+    assert record.record_id == "456"
+    request = provider._session.requests[-1]
+    assert request.path == "/dns/editByNameType/example.com/A/"
+    assert request.json["content"] == "127.0.0.2"
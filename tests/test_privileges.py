@@ -0,0 +1,75 @@
+import os
+import pwd
+import pytest
+from cloudflare_dyndns.privileges import PrivilegeError, drop_privileges
+
+
+@pytest.fixture
+def calls(monkeypatch):
+    """The uid and gid changes, without changing the user of the tests."""
+    calls = []
+    ids = {"uid": 0}
+    monkeypatch.setattr(os, "getuid", lambda: ids["uid"])
+    monkeypatch.setattr(os, "geteuid", lambda: ids["uid"])
+    monkeypatch.setattr(os, "setgid", lambda gid: calls.append(("setgid", gid)))
+
+    def initgroups(user, gid):
+        calls.append(("initgroups", user, gid))
+
+    def setuid(uid):
+        calls.append(("setuid", uid))
+        ids["uid"] = uid
+
+    monkeypatch.setattr(os, "initgroups", initgroups)
+    monkeypatch.setattr(os, "setuid", setuid)
+    monkeypatch.setenv("HOME", "/root")
+    return calls
+
+
+@pytest.fixture
+def passwd(monkeypatch):
+    entry = pwd.struct_passwd(
+        ("dyndns", "x", 990, 980, "", "/var/lib/dyndns", "/usr/sbin/nologin")
+    )
+    monkeypatch.setattr(pwd, "getpwnam", {"dyndns": entry}.__getitem__)
+    monkeypatch.setattr(pwd, "getpwuid", {990: entry}.__getitem__)
+    return entry
+
+
+def test_only_root_can_switch_user(monkeypatch):
+    monkeypatch.setattr(os, "getuid", lambda: 1000)
+    with pytest.raises(PrivilegeError):
+        drop_privileges("nobody")
+
+
+def test_switches_groups_before_user(calls, passwd):
+    drop_privileges("dyndns")
+
+    assert calls == [
+        ("initgroups", "dyndns", 980),
+        ("setgid", 980),
+        ("setuid", 990),
+    ]
+    assert os.environ["HOME"] == "/var/lib/dyndns"
+
+
+def test_user_and_group_by_id(calls, passwd):
+    drop_privileges("990", "970")
+
+    assert calls == [
+        ("initgroups", "dyndns", 970),
+        ("setgid", 970),
+        ("setuid", 990),
+    ]
+
+
+def test_unknown_user(calls, passwd):
+    with pytest.raises(PrivilegeError, match="Unknown user: nobody-here"):
+        drop_privileges("nobody-here")
+    assert calls == []
+
+
+def test_still_root_after_switching(calls, passwd, monkeypatch):
+    monkeypatch.setattr(os, "setuid", lambda uid: None)
+    with pytest.raises(PrivilegeError, match="Failed to drop root privileges"):
+        drop_privileges("dyndns")
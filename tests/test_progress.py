@@ -0,0 +1,49 @@
+import ipaddress
+from cloudflare_dyndns import progress
+from cloudflare_dyndns.report import Report, UpdateResult
+
+
+def test_retried_domain_is_counted_once():
+    domains_progress = progress.Progress(2, "A")
+    domains_progress.advance("a.example.com", False)
+    domains_progress.advance("b.example.com", True)
+    assert (domains_progress.done, domains_progress.failed) == (2, 1)
+
+    domains_progress.advance("a.example.com", True)
+    assert (domains_progress.done, domains_progress.failed) == (2, 0)
+
+
+def test_summary_table():
+    report = Report(
+        results=[
+            UpdateResult(
+                record_type="A",
+                new_ip=ipaddress.IPv4Address("127.0.0.2"),
+                updated_domains=["example.com"],
+            ),
+            UpdateResult(record_type="AAAA", skipped=True),
+            UpdateResult(
+                record_type="A",
+                link="eth1",
+                failed_domains=["backup.example.com"],
+                new_ip=ipaddress.IPv4Address("127.0.0.3"),
+            ),
+        ]
+    )
+    domains_by_type = {
+        "A": ["example.com", "www.example.com", "backup.example.com"],
+        "AAAA": ["example.com"],
+    }
+    table = progress.summary_table(
+        report, domains_by_type, {"backup.example.com": "eth1"}
+    )
+    assert table == [
+        "Domain              A           AAAA",
+        "backup.example.com  failed      -",
+        "example.com         updated     skipped",
+        "www.example.com     up-to-date  -",
+    ]
+
+
+def test_summary_table_without_domains():
+    assert progress.summary_table(Report(), {"A": [], "AAAA": []}) == []
@@ -0,0 +1,48 @@
+import datetime
+import pytest
+from cloudflare_dyndns.proxied_schedule import ProxiedSchedule, apply_schedules
+
+
+def at(hour, minute=0, day=1):
+    return datetime.datetime(2024, 6, day, hour, minute)
+
+
+def test_day_window():
+    schedule = ProxiedSchedule.parse("08:00-22:00")
+    assert not schedule.proxied_at(at(7, 59))
+    assert schedule.proxied_at(at(8))
+    assert schedule.proxied_at(at(21, 59))
+    assert not schedule.proxied_at(at(22))
+    assert schedule.next_change(at(7)) == at(8)
+    assert schedule.next_change(at(8)) == at(22)
+    assert schedule.next_change(at(23)) == at(8, day=2)
+
+
+def test_window_past_midnight():
+    schedule = ProxiedSchedule.parse("22:00-6:30")
+    assert schedule.proxied_at(at(23))
+    assert schedule.proxied_at(at(3))
+    assert not schedule.proxied_at(at(6, 30))
+    assert schedule.next_change(at(23)) == at(6, 30, day=2)
+    assert str(schedule) == "22:00-06:30"
+
+
+@pytest.mark.parametrize("value", ["8-22", "08:00-08:00", "25:00-06:00", "08:00"])
+def test_invalid_window(value):
+    with pytest.raises(ValueError):
+        ProxiedSchedule.parse(value)
+
+
+def test_apply_schedules(capsys):
+    schedules = {
+        "game.example.com": ProxiedSchedule.parse("08:00-22:00"),
+        "www.example.com": ProxiedSchedule.parse("23:00-01:00"),
+    }
+    domain_proxied = {}
+    change_at = apply_schedules(schedules, domain_proxied, at(12))
+    assert domain_proxied == {"game.example.com": True, "www.example.com": False}
+    assert change_at == at(22).timestamp()
+
+    apply_schedules(schedules, domain_proxied, at(22))
+    assert not domain_proxied["game.example.com"]
+    assert "Turning the proxy of game.example.com off" in capsys.readouterr().out
@@ -0,0 +1,28 @@
+import ipaddress
+import pytest
+from cloudflare_dyndns.receiver import InvalidWebhook, check_secret, parse_addresses
+
+
+def test_parse_addresses_by_record_type():
+    addresses = parse_addresses({"myip": "127.0.0.2,::1", "secret": "x"})
+    assert addresses == {
+        "A": ipaddress.IPv4Address("127.0.0.2"),
+        "AAAA": ipaddress.IPv6Address("::1"),
+    }
+
+
+def test_parse_addresses_without_address():
+    assert parse_addresses({}) == {}
+
+
+def test_parse_invalid_address():
+    with pytest.raises(InvalidWebhook):
+        parse_addresses({"ip": "not-an-ip"})
+
+
+def test_check_secret():
+    assert check_secret("secret", "secret")
+    assert not check_secret("secret", "wrong")
+    assert not check_secret("secret", None)
+    assert not check_secret("secret", 42)
+    assert not check_secret("secret", ["secret"])
@@ -0,0 +1,72 @@
+import socket
+import pytest
+from cloudflare_dyndns import resolver
+from cloudflare_dyndns.dns_lookup import TYPE_A, TYPE_AAAA, DNSLookupError
+
+
+class FakeNameserver(resolver.Nameserver):
+    def __init__(self, records=None):
+        super().__init__("fake")
+        self.records = records
+
+    def lookup(self, name, record_type):
+        if self.records is None:
+            raise DNSLookupError("No response from fake")
+        return self.records.get((name, record_type), [])
+
+
+@pytest.fixture
+def nameservers(monkeypatch):
+    nameservers = []
+    monkeypatch.setattr(resolver, "_nameservers", nameservers)
+    monkeypatch.setattr(resolver, "_bootstrap_hosts", set())
+    return nameservers
+
+
+def test_parse_nameserver(nameservers):
+    assert isinstance(resolver.parse_nameserver("1.1.1.1"), resolver.UDPNameserver)
+    assert isinstance(resolver.parse_nameserver("::1"), resolver.UDPNameserver)
+
+    dot = resolver.parse_nameserver("tls://1.1.1.1#cloudflare-dns.com")
+    assert str(dot) == "tls://1.1.1.1:853"
+    assert dot._hostname == "cloudflare-dns.com"
+
+    doh = resolver.parse_nameserver("https://dns.google/dns-query")
+    assert isinstance(doh, resolver.HTTPSNameserver)
+    # the DoH server itself has to be resolved by the system resolver
+    assert resolver._bootstrap_hosts == {"dns.google"}
+
+    with pytest.raises(ValueError):
+        resolver.parse_nameserver("dns.google")
+
+
+def test_getaddrinfo_with_nameservers(nameservers):
+    records = {
+        ("api.cloudflare.com", TYPE_A): ["104.19.192.29"],
+        ("api.cloudflare.com", TYPE_AAAA): ["2606:4700::6813:c01d"],
+    }
+    nameservers.extend([FakeNameserver(), FakeNameserver(records)])
+
+    address_info = resolver.getaddrinfo("api.cloudflare.com", 443)
+    assert [(family, sockaddr) for family, _, _, _, sockaddr in address_info] == [
+        (socket.AF_INET, ("104.19.192.29", 443)),
+        (socket.AF_INET6, ("2606:4700::6813:c01d", 443, 0, 0)),
+    ]
+
+    address_info = resolver.getaddrinfo("api.cloudflare.com", 443, socket.AF_INET)
+    assert len(address_info) == 1
+
+    with pytest.raises(socket.gaierror):
+        resolver.getaddrinfo("unknown.example.com", 443)
+
+
+def test_every_nameserver_failing(nameservers):
+    nameservers.extend([FakeNameserver(), FakeNameserver()])
+    with pytest.raises(socket.gaierror, match="No response from fake"):
+        resolver.getaddrinfo("api.cloudflare.com", 443)
+
+
+def test_ip_addresses_are_not_resolved(nameservers):
+    nameservers.append(FakeNameserver())
+    address_info = resolver.getaddrinfo("127.0.0.1", 53, 0, socket.SOCK_DGRAM)
+    assert address_info[0][4] == ("127.0.0.1", 53)
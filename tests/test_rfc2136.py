@@ -0,0 +1,97 @@
+import base64
+import hashlib
+import hmac
+import ipaddress
+import socket
+import struct
+import threading
+import pytest
+from cloudflare_dyndns.rfc2136 import (
+    RFC2136Error,
+    RFC2136Provider,
+    check_response,
+    parse_server_address,
+)
+
+SECRET = b"0123456789abcdef"
+
+
+@pytest.fixture
+def dns_server():
+    """Answers one UPDATE message with the given rcode and keeps the request."""
+    sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+    sock.bind(("127.0.0.1", 0))
+    sock.settimeout(5)
+    received = []
+
+    def serve(rcode=0):
+        message, client = sock.recvfrom(4096)
+        received.append(message)
+        flags = 0x8000 | (5 << 11) | rcode
+        sock.sendto(message[:2] + struct.pack("!H", flags) + bytes(8), client)
+
+    def start(rcode=0):
+        thread = threading.Thread(target=serve, args=(rcode,))
+        thread.start()
+        return f"127.0.0.1:{sock.getsockname()[1]}"
+
+    yield start, received
+    sock.close()
+
+
+def test_signed_update(dns_server):
+    start, received = dns_server
+    server = start()
+    provider = RFC2136Provider(
+        server, "example.com", "dyndns-key", base64.b64encode(SECRET).decode()
+    )
+
+    provider.ensure_record("home.example.com", ipaddress.ip_address("127.0.0.2"))
+
+    message = received[0]
+    _, flags, zones, prerequisites, updates, additional = struct.unpack(
+        "!HHHHHH", message[:12]
+    )
+    assert flags >> 11 == 5
+    assert (zones, prerequisites, updates, additional) == (1, 0, 2, 1)
+    assert b"\x7f\x00\x00\x02" in message
+    # the MAC is the last field before the original id, error and other length
+    algorithm = b"\x0bhmac-sha256\x00"
+    tsig_start = message.index(b"\x0adyndns-key\x00")
+    rdata = message[message.index(algorithm, tsig_start) + len(algorithm) :]
+    timers, mac = rdata[:8], rdata[10:42]
+    unsigned = message[:10] + b"\x00\x00" + message[12:tsig_start]
+    variables = b"\x0adyndns-key\x00" + b"\x00\xff" + bytes(4) + algorithm
+    variables += timers + bytes(4)
+    assert mac == hmac.new(SECRET, unsigned + variables, hashlib.sha256).digest()
+
+
+def test_refused_update(dns_server):
+    start, _ = dns_server
+    provider = RFC2136Provider(start(rcode=5), "example.com")
+    with pytest.raises(RFC2136Error, match="REFUSED"):
+        provider.delete_record("home.example.com", "A")
+
+
+def test_domain_outside_of_zone():
+    provider = RFC2136Provider("127.0.0.1", "example.com")
+    with pytest.raises(RFC2136Error):
+        provider.ensure_record("example.org", ipaddress.ip_address("127.0.0.2"))
+
+
+def test_check_response_with_different_id():
+    with pytest.raises(RFC2136Error):
+        check_response(struct.pack("!HH", 1, 0x8000) + bytes(8), 2)
+
+
+@pytest.mark.parametrize(
+    "address, expected",
+    [
+        ("ns1.example.com", ("ns1.example.com", 53)),
+        ("127.0.0.1:5353", ("127.0.0.1", 5353)),
+        ("2001:db8::1", ("2001:db8::1", 53)),
+        ("[2001:db8::1]:5353", ("2001:db8::1", 5353)),
+    ],
+)
+def test_parse_server_address(address, expected):
+    assert parse_server_address(address) == expected
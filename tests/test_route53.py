@@ -0,0 +1,58 @@
+import datetime
+import ipaddress
+from conftest import FakeResponse, FakeSession
+from cloudflare_dyndns.aws_sigv4 import sign_request
+from cloudflare_dyndns.route53 import API_URL, Route53Provider
+
+ZONES_RESPONSE = """<?xml version="1.0"?>
+<ListHostedZonesByNameResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+  <HostedZones>
+    <HostedZone><Id>/hostedzone/Z123</Id><Name>{name}</Name></HostedZone>
+  </HostedZones>
+</ListHostedZonesByNameResponse>"""
+
+CHANGE_RESPONSE = """<?xml version="1.0"?>
+<ChangeResourceRecordSetsResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+  <ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status></ChangeInfo>
+</ChangeResourceRecordSetsResponse>"""
+
+
+def test_sigv4_get_vanilla():
+    # from the AWS Signature Version 4 test suite
+    headers = sign_request(
+        "GET",
+        "https://example.amazonaws.com/",
+        b"",
+        "AKIDEXAMPLE",
+        "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+        "us-east-1",
+        "service",
+        now=datetime.datetime(2015, 8, 30, 12, 36, 0),
+    )
+    assert headers["Authorization"].endswith(
+        "Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+    )
+
+
+def test_ensure_record():
+    def handle(request):
+        path = request.path
+        if "hostedzonesbyname" in path:
+            # only the parent domain has a hosted zone
+            name = "example.com." if "dnsname=example.com." in path else "other."
+            return FakeResponse(200, text=ZONES_RESPONSE.format(name=name))
+        return FakeResponse(200, text=CHANGE_RESPONSE)
+
+    provider = Route53Provider("access-key", "secret-key")
+    provider._session = FakeSession(API_URL, handle)
+    ip = ipaddress.IPv4Address("127.0.0.2")
+
+    record = provider.ensure_record("home.example.com", ip)
+
+    assert record.zone_id == "Z123"
+    request = provider._session.requests[-1]
+    assert (request.method, request.path) == ("POST", "/hostedzone/Z123/rrset")
+    body = request.data
+    assert b"<Action>UPSERT</Action>" in body
+    assert b"<Name>home.example.com.</Name>" in body
+    assert b"<Value>127.0.0.2</Value>" in body
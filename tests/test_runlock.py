@@ -0,0 +1,95 @@
+import json
+import os
+import socket
+import threading
+import time
+import pytest
+from cloudflare_dyndns import runlock
+from cloudflare_dyndns.runlock import LockedError, RunLock
+
+
+def write_owner(path, pid, started=None, hostname=None):
+    owner = {
+        "pid": pid,
+        "hostname": hostname or socket.gethostname(),
+        "started": started or time.time(),
+    }
+    path.write_text(json.dumps(owner))
+
+
+def test_lock_is_exclusive(tmp_path):
+    path = tmp_path / "ip.cache.lock"
+    with RunLock(path):
+        assert json.loads(path.read_text())["pid"] == os.getpid()
+        with pytest.raises(LockedError):
+            RunLock(path).acquire()
+    assert not path.exists()
+
+
+def test_lock_of_dead_process_is_recovered(tmp_path):
+    path = tmp_path / "ip.cache.lock"
+    # PIDs are never this large
+    write_owner(path, 2 ** 30)
+
+    with RunLock(path):
+        assert json.loads(path.read_text())["pid"] == os.getpid()
+
+
+def test_lock_of_reused_pid_is_recovered(tmp_path):
+    path = tmp_path / "ip.cache.lock"
+    # e.g. PID 1 in a restarted container, the crashed run had the same PID
+    write_owner(path, os.getpid())
+
+    with RunLock(path):
+        pass
+
+
+def test_unreadable_lock_is_recovered(tmp_path):
+    path = tmp_path / "ip.cache.lock"
+    path.write_text("{")
+
+    with RunLock(path):
+        assert json.loads(path.read_text())["pid"] == os.getpid()
+
+
+def test_only_one_run_takes_a_left_behind_lock(tmp_path):
+    path = tmp_path / "ip.cache.lock"
+    write_owner(path, 2 ** 30, started=time.time() - 7200)
+    locks = [RunLock(path) for _ in range(8)]
+    barrier = threading.Barrier(len(locks))
+    acquired = []
+
+    def take(lock):
+        barrier.wait()
+        try:
+            lock.acquire()
+        except LockedError:
+            return
+        acquired.append(lock)
+
+    threads = [threading.Thread(target=take, args=(lock,)) for lock in locks]
+    for thread in threads:
+        thread.start()
+    for thread in threads:
+        thread.join()
+
+    assert len(acquired) == 1
+    acquired[0].release()
+
+
+def test_lock_removed_while_taking_it(tmp_path, monkeypatch):
+    path = tmp_path / "ip.cache.lock"
+    holder = RunLock(path)
+    holder.acquire()
+    try_lock = runlock.try_lock
+
+    def holder_finishes_first(fd):
+        # between opening and locking the file, which is removed by then
+        holder.release()
+        return try_lock(fd)
+
+    monkeypatch.setattr(runlock, "try_lock", holder_finishes_first)
+    with RunLock(path):
+        assert path.exists()
+        with pytest.raises(LockedError):
+            RunLock(path).acquire()
@@ -0,0 +1,18 @@
+import time
+import pytest
+from cloudflare_dyndns.signals import DeadlineExceeded, deadline
+
+
+def test_deadline_interrupts_hanging_code():
+    start = time.monotonic()
+    with pytest.raises(DeadlineExceeded):
+        with deadline(0.1):
+            time.sleep(5)
+    assert time.monotonic() - start < 1
+
+
+def test_deadline_is_cancelled_after_the_block():
+    with deadline(0.1):
+        pass
+    # would be interrupted if the timer were still running
+    time.sleep(0.2)
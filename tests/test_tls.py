@@ -0,0 +1,31 @@
+import ssl
+import pytest
+import requests
+from cloudflare_dyndns import tls
+
+
+def test_ca_cert_has_to_exist(tmp_path):
+    with pytest.raises(ValueError):
+        tls.use_ca_cert(str(tmp_path / "missing.pem"))
+
+
+def test_verify_option_is_forced(monkeypatch):
+    sent = []
+
+    def send(session, request, **kwargs):
+        sent.append(kwargs["verify"])
+
+    monkeypatch.setattr(requests.Session, "send", tls._verified_send(send))
+    monkeypatch.setattr(tls, "_verify", "/etc/ssl/private-ca.pem")
+    request = requests.Request("GET", "https://ip.example.com/").prepare()
+
+    requests.Session().send(request, verify=True)
+
+    assert sent == ["/etc/ssl/private-ca.pem"]
+
+
+def test_insecure_ssl_context(monkeypatch):
+    monkeypatch.setattr(tls, "_verify", False)
+    context = tls.ssl_context()
+    assert context.verify_mode == ssl.CERT_NONE
+    assert not context.check_hostname
@@ -0,0 +1,47 @@
+import datetime
+import time
+from cloudflare_dyndns.cache import Cache
+from cloudflare_dyndns.providers import DNSProviderError
+from cloudflare_dyndns.token_expiry import check_token_expiry
+
+
+class FakeProvider:
+    def __init__(self, expires_in_days=None):
+        self.checks = 0
+        self.expires_on = None
+        if expires_in_days is not None:
+            self.expires_on = datetime.datetime.now(
+                datetime.timezone.utc
+            ) + datetime.timedelta(days=expires_in_days)
+
+    def credentials_expire_on(self):
+        self.checks += 1
+        return self.expires_on
+
+
+def test_warns_when_the_token_expires_soon(capsys):
+    provider = FakeProvider(expires_in_days=5)
+    cache = Cache()
+    assert check_token_expiry(provider, cache, 14) == provider.expires_on
+    assert cache.last_token_check is not None
+    assert "expires on" in capsys.readouterr().out
+
+
+def test_no_warning_outside_the_window():
+    assert check_token_expiry(FakeProvider(expires_in_days=30), Cache(), 14) is None
+    assert check_token_expiry(FakeProvider(), Cache(), 14) is None
+
+
+def test_checks_only_once_a_day():
+    provider = FakeProvider(expires_in_days=5)
+    cache = Cache(last_token_check=time.time() - 60)
+    assert check_token_expiry(provider, cache, 14) is None
+    assert provider.checks == 0
+
+
+def test_failed_check_is_not_an_error():
+    class FailingProvider:
+        def credentials_expire_on(self):
+            raise DNSProviderError("Connection refused")
+
+    assert check_token_expiry(FailingProvider(), Cache(), 14) is None
@@ -0,0 +1,72 @@
+import datetime
+import ipaddress
+import time
+import click
+import pytest
+from cloudflare_dyndns import printer, tui
+from cloudflare_dyndns.daemon import Daemon
+from cloudflare_dyndns.report import Report, UpdateResult
+
+
+class FakeUpdater:
+    domain_links = {}
+
+    def domains_for(self, record_type):
+        return ["example.com", "nas.example.com"] if record_type == "A" else []
+
+
+@pytest.mark.parametrize(
+    "seconds, expected",
+    [(0, "0m 00s"), (75, "1m 15s"), (3725, "1h 02m 05s"), (-3, "0m 00s")],
+)
+def test_format_countdown(seconds, expected):
+    assert tui.format_countdown(seconds) == expected
+
+
+def test_render(monkeypatch):
+    report = Report(
+        results=[
+            UpdateResult(
+                record_type="A",
+                new_ip=ipaddress.IPv4Address("127.0.0.2"),
+                updated_domains=["example.com"],
+                failed_domains=["nas.example.com"],
+            )
+        ]
+    )
+    daemon = Daemon(lambda force, addresses: report, 300, False, [])
+    daemon.last_report = report
+    daemon.last_run = time.time()
+    daemon.next_run = time.time() + 125.5
+    now = datetime.datetime.now()
+    monkeypatch.setattr(
+        printer,
+        "history",
+        [(now, "info", "first"), (now, "info", ""), (now, "error", "Failed nas")],
+    )
+
+    lines = tui.render(daemon, FakeUpdater(), printer.Theme(), 30, 80)
+    text = [click.unstyle(line) for line in lines]
+    assert text[0] == "cloudflare-dyndns - checking every 300 seconds"
+    assert "IPv4:  127.0.0.2" in text
+    assert "IPv6:  -" not in text
+    assert "Next check:  in 2m 05s" in text
+    assert "example.com      updated" in text
+    assert "nas.example.com  failed" in text
+    assert text[-3:] == [
+        "Events",
+        f"{now:%H:%M:%S} first",
+        f"{now:%H:%M:%S} Failed nas",
+    ]
+
+
+def test_render_only_the_newest_events_fit(monkeypatch):
+    daemon = Daemon(lambda force, addresses: Report(), 300, False, [])
+    now = datetime.datetime.now()
+    events = [(now, "info", f"message {i}") for i in range(50)]
+    monkeypatch.setattr(printer, "history", events)
+
+    lines = tui.render(daemon, FakeUpdater(), printer.Theme(), 10, 80)
+    assert len(lines) == 10
+    assert click.unstyle(lines[-1]).endswith("message 49")
+    assert "Next check:  now" in lines
@@ -0,0 +1,28 @@
+import time
+from cloudflare_dyndns import update_check
+from cloudflare_dyndns.cache import Cache
+from cloudflare_dyndns.update_check import check_for_update, parse_version
+
+
+def test_parse_version_order():
+    assert parse_version("4.0-beta3") < parse_version("v4.0")
+    assert parse_version("4.0-beta2") < parse_version("4.0-beta3")
+    assert parse_version("4.0") == parse_version("4.0.0")
+    assert parse_version("4.0") < parse_version("4.0.1") < parse_version("4.1")
+
+
+def test_newer_version_available(monkeypatch):
+    monkeypatch.setattr(update_check, "installed_version", lambda: "4.0-beta3")
+    monkeypatch.setattr(update_check, "get_latest_version", lambda: "v4.0")
+    cache = Cache()
+    assert check_for_update(cache) == "4.0"
+    assert cache.last_update_check is not None
+
+
+def test_checks_only_once_a_day(monkeypatch):
+    def get_latest_version():
+        raise AssertionError("should not be called")
+
+    monkeypatch.setattr(update_check, "installed_version", lambda: "4.0")
+    monkeypatch.setattr(update_check, "get_latest_version", get_latest_version)
+    assert check_for_update(Cache(last_update_check=time.time() - 60)) is None
@@ -0,0 +1,567 @@
+import datetime
+import json
+import ipaddress
+import threading
+import time
+import pytest
+from cloudflare_dyndns import updater
+from cloudflare_dyndns.cache import Cache, CacheManager, IPChange, ZoneRecord
+from cloudflare_dyndns.canary import Canary, CanaryError
+from cloudflare_dyndns.maintenance import maintenance_file, write_maintenance
+from cloudflare_dyndns.proxied_schedule import ProxiedSchedule
+from cloudflare_dyndns.providers import DNSProvider, DNSProviderError, ProviderRouter
+from cloudflare_dyndns.runlock import RunLock
+from cloudflare_dyndns.signals import DeadlineExceeded, deadline
+from cloudflare_dyndns.updater import Updater
+
+
+class FakeProvider(DNSProvider):
+    def __init__(self, failing_domains=()):
+        self.records = {}
+        self.failing_domains = failing_domains
+
+    def ensure_record(self, domain, ip, proxied=False, cached=None, ttl=None):
+        if domain in self.failing_domains:
+            raise DNSProviderError(f"Failed to update {domain}")
+        self.records[domain] = ip
+        return ZoneRecord(zone_id="zone-id", record_id=domain, proxied=proxied)
+
+    def delete_record(self, domain, record_type):
+        self.records.pop(domain, None)
+
+    def verify_credentials(self):
+        pass
+
+
+def test_updates_then_uses_cache(tmp_path, monkeypatch):
+    ip = ipaddress.IPv4Address("127.0.0.2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ip)
+    provider = FakeProvider()
+    dyndns = Updater(provider, ["example.com"], tmp_path / "ip.cache")
+
+    report = dyndns.run()
+    assert report.exit_code == 0
+    assert report.get_result("A").updated_domains == ["example.com"]
+    assert provider.records == {"example.com": ip}
+
+    report = dyndns.run()
+    assert report.status == "unchanged"
+
+
+def test_received_address(tmp_path):
+    ip = ipaddress.IPv4Address("127.0.0.3")
+    provider = FakeProvider()
+    dyndns = Updater(provider, ["example.com"], tmp_path / "ip.cache")
+    report = dyndns.run(addresses={"A": ip})
+    assert report.get_result("A").new_ip == ip
+    assert provider.records == {"example.com": ip}
+
+
+def test_partial_success(tmp_path, monkeypatch):
+    ip = ipaddress.IPv4Address("127.0.0.2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ip)
+    provider = FakeProvider(failing_domains=["bad.example.com"])
+    domains = ["example.com", "bad.example.com"]
+    report = Updater(provider, domains, tmp_path / "ip.cache").run()
+    assert report.exit_code == updater.EXIT_PARTIAL_SUCCESS
+    assert report.get_result("A").failed_domains == ["bad.example.com"]
+
+
+def test_domains_on_different_providers(tmp_path, monkeypatch):
+    ip = ipaddress.IPv4Address("127.0.0.2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ip)
+    default, other = FakeProvider(), FakeProvider()
+    router = ProviderRouter(default, {"other.example.org": other})
+    domains = ["example.com", "other.example.org"]
+    report = Updater(router, domains, tmp_path / "ip.cache").run()
+    assert report.exit_code == 0
+    assert default.records == {"example.com": ip}
+    assert other.records == {"other.example.org": ip}
+
+
+def test_auto_family_skips_missing_ipv4(tmp_path, monkeypatch):
+    ip = ipaddress.IPv6Address("::2")
+    monkeypatch.setattr(updater, "get_ipv6", lambda: ip)
+    monkeypatch.setattr(updater, "has_connectivity", lambda version: version == 6)
+    provider = FakeProvider()
+    dyndns = Updater(
+        provider, ["example.com"], tmp_path / "ip.cache", ipv6=True, auto_family=True
+    )
+
+    report = dyndns.run()
+
+    assert report.exit_code == 0
+    assert report.get_result("A").skipped
+    assert provider.records == {"example.com": ip}
+
+
+def test_preflight_lists_every_invalid_domain(tmp_path, monkeypatch):
+    def fail(*args):
+        raise AssertionError("nothing should be detected or updated")
+
+    monkeypatch.setattr(updater, "get_ipv4", fail)
+    provider = FakeProvider()
+    domains = ["example.com", "bad..example.com", "-bad.example.com", "localhost"]
+
+    report = Updater(provider, domains, tmp_path / "ip.cache").run()
+
+    assert report.exit_code == updater.EXIT_INVALID_DOMAINS
+    assert Updater(provider, domains, tmp_path / "ip.cache").preflight() == [
+        '"bad..example.com": empty label',
+        '"-bad.example.com": invalid label "-bad"',
+        '"localhost": not a fully qualified domain name',
+    ]
+
+
+def test_preflight_checks_zones(tmp_path):
+    class ZonedProvider(FakeProvider):
+        def check_zone(self, domain):
+            if not domain.endswith(".example.com"):
+                raise DNSProviderError(f"No zone for {domain}")
+
+    domains = ["home.example.com", "home.example.org", "*.example.com"]
+    dyndns = Updater(ZonedProvider(), domains, tmp_path / "ip.cache")
+    assert dyndns.preflight() == ['"home.example.org": No zone for home.example.org']
+
+
+def test_record_types_per_domain(tmp_path, monkeypatch):
+    ipv4, ipv6 = ipaddress.IPv4Address("127.0.0.2"), ipaddress.IPv6Address("::2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipv4)
+    monkeypatch.setattr(updater, "get_ipv6", lambda: ipv6)
+    provider = FakeProvider()
+    domains = ["example.com", "v6.example.com", "dual.example.com"]
+    record_types = {"v6.example.com": ["AAAA"], "dual.example.com": ["A", "AAAA"]}
+    dyndns = Updater(
+        provider, domains, tmp_path / "ip.cache", domain_record_types=record_types
+    )
+
+    report = dyndns.run()
+
+    assert report.get_result("A").updated_domains == ["example.com", "dual.example.com"]
+    assert report.get_result("AAAA").updated_domains == [
+        "v6.example.com",
+        "dual.example.com",
+    ]
+
+
+def test_families_update_in_parallel(tmp_path, monkeypatch):
+    # each detection only returns when the other one has started too
+    barrier = threading.Barrier(2, timeout=5)
+    ipv4 = ipaddress.IPv4Address("127.0.0.2")
+
+    def detect_ipv4():
+        barrier.wait()
+        return ipv4
+
+    def detect_ipv6():
+        barrier.wait()
+        raise updater.IPServiceError("IPv6 service is down")
+
+    monkeypatch.setattr(updater, "get_ipv4", detect_ipv4)
+    monkeypatch.setattr(updater, "get_ipv6", detect_ipv6)
+    provider = FakeProvider()
+    dyndns = Updater(provider, ["example.com"], tmp_path / "ip.cache", ipv6=True)
+
+    report = dyndns.run()
+
+    # the failing family doesn't stop the other one
+    assert report.get_result("A").updated_domains == ["example.com"]
+    assert report.get_result("AAAA").new_ip is None
+    assert report.exit_code != 0
+    assert [result.record_type for result in report.results] == ["A", "AAAA"]
+
+
+def test_deadline_doesnt_wait_for_a_hanging_family(tmp_path, monkeypatch):
+    ipv4 = ipaddress.IPv4Address("127.0.0.2")
+    hanging = threading.Event()
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipv4)
+    monkeypatch.setattr(updater, "get_ipv6", hanging.wait)
+    monkeypatch.setattr(updater, "STOP_TIMEOUT", 0.1)
+    provider = FakeProvider()
+    cache_file = tmp_path / "ip.cache"
+    dyndns = Updater(provider, ["example.com"], cache_file, ipv6=True)
+
+    try:
+        with pytest.raises(DeadlineExceeded):
+            with deadline(1):
+                dyndns.run()
+    finally:
+        hanging.set()
+
+    assert provider.records == {"example.com": ipv4}
+    # the IPv6 flow could still change it
+    assert not cache_file.exists()
+
+
+def test_stopped_flow_leaves_the_other_domains(tmp_path):
+    stop = threading.Event()
+
+    class StoppingProvider(FakeProvider):
+        def ensure_record(self, domain, ip, proxied=False, cached=None, ttl=None):
+            stop.set()
+            return super().ensure_record(domain, ip, proxied, cached, ttl)
+
+    provider = StoppingProvider()
+    ip_cache = Cache().ipv4
+    ip_cache.updated_domains["second.example.com"] = ZoneRecord(
+        zone_id="zone-id", record_id="second.example.com"
+    )
+    result = updater.UpdateResult(record_type="A")
+    domains = ["first.example.com", "second.example.com"]
+    ipv4 = ipaddress.IPv4Address("127.0.0.2")
+
+    updater.update_domains(provider, domains, ip_cache, ipv4, False, result, stop=stop)
+
+    assert list(provider.records) == ["first.example.com"]
+    assert result.failed_domains == ["second.example.com"]
+    assert list(ip_cache.updated_domains) == ["first.example.com"]
+
+
+def test_stop_during_the_retry_delay(monkeypatch):
+    monkeypatch.setattr(updater, "RETRY_DELAY", 60)
+    stop, failed = threading.Event(), threading.Event()
+    attempts = []
+
+    class FailingProvider(FakeProvider):
+        def ensure_record(self, domain, ip, proxied=False, cached=None, ttl=None):
+            attempts.append(domain)
+            failed.set()
+            return super().ensure_record(domain, ip, proxied, cached, ttl)
+
+    provider = FailingProvider(failing_domains=["example.com"])
+    result = updater.UpdateResult(record_type="A")
+    ipv4 = ipaddress.IPv4Address("127.0.0.2")
+    flow = threading.Thread(
+        target=updater.update_domains,
+        args=(provider, ["example.com"], Cache().ipv4, ipv4, False, result),
+        kwargs={"stop": stop},
+    )
+    flow.start()
+    failed.wait()
+    stop.set()
+    flow.join(updater.STOP_TIMEOUT)
+
+    assert not flow.is_alive()
+    assert attempts == ["example.com"]
+    assert result.failed_domains == ["example.com"]
+
+
+def test_each_family_gets_its_own_ttl(tmp_path, monkeypatch):
+    ipv4, ipv6 = ipaddress.IPv4Address("127.0.0.2"), ipaddress.IPv6Address("::2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipv4)
+    monkeypatch.setattr(updater, "get_ipv6", lambda: ipv6)
+    # the IPv6 address has been stable for a day, the IPv4 one is new
+    cache = Cache()
+    cache.ipv6.changes = [IPChange(time=time.time() - 86400, address=ipv6)]
+    cache_file = tmp_path / "ip.cache"
+    cache_file.write_text(cache.json())
+    ttls = {}
+
+    class TTLProvider(FakeProvider):
+        def ensure_record(self, domain, ip, proxied=False, cached=None, ttl=None):
+            ttls[ip.version] = ttl
+            return super().ensure_record(domain, ip, proxied, cached, ttl)
+
+    dyndns = Updater(
+        TTLProvider(), ["example.com"], cache_file, ipv6=True, adaptive_ttl=(60, 3600)
+    )
+
+    assert dyndns.run().exit_code == 0
+    assert ttls == {4: 60, 6: 3600}
+
+
+def test_adaptive_ttl_without_ttl_support_uses_cache(tmp_path, monkeypatch):
+    ip = ipaddress.IPv4Address("127.0.0.2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ip)
+    provider = FakeProvider()
+    cache_file = tmp_path / "ip.cache"
+    dyndns = Updater(provider, ["example.com"], cache_file, adaptive_ttl=(60, 3600))
+    assert dyndns.run().exit_code == 0
+
+    # the records of the provider have no TTL, they must not look outdated
+    provider.failing_domains = ["example.com"]
+    report = dyndns.run()
+    assert report.status == "unchanged"
+
+
+def test_fail_fast_updates_families_one_by_one(tmp_path, monkeypatch):
+    def detect_ipv4():
+        raise updater.IPServiceError("IPv4 service is down")
+
+    def detect_ipv6():
+        raise AssertionError("IPv6 must not be detected after the IPv4 error")
+
+    monkeypatch.setattr(updater, "get_ipv4", detect_ipv4)
+    monkeypatch.setattr(updater, "get_ipv6", detect_ipv6)
+    provider = FakeProvider()
+    cache_file = tmp_path / "ip.cache"
+    dyndns = Updater(provider, ["example.com"], cache_file, ipv6=True, fail_fast=True)
+
+    assert dyndns.run().exit_code != 0
+
+
+def test_fallback_ip_when_detection_fails(tmp_path, monkeypatch):
+    def detection_fails():
+        raise updater.IPServiceError("No internet connection")
+
+    fallback_ip = ipaddress.IPv4Address("127.0.0.9")
+    monkeypatch.setattr(updater, "get_ipv4", detection_fails)
+    provider = FakeProvider()
+    dyndns = Updater(
+        provider,
+        ["example.com"],
+        tmp_path / "ip.cache",
+        fallback_ips={"A": fallback_ip},
+    )
+
+    report = dyndns.run()
+
+    assert report.exit_code == 0
+    assert provider.records == {"example.com": fallback_ip}
+
+
+def test_domains_detected_through_their_link(tmp_path, monkeypatch):
+    def detect():
+        if updater.binding.current_link() == "wan2":
+            return ipaddress.IPv4Address("127.0.0.3")
+        return ipaddress.IPv4Address("127.0.0.2")
+
+    monkeypatch.setattr(updater, "get_ipv4", detect)
+    connection = updater.binding.urllib3.util.connection
+    monkeypatch.setattr(connection, "create_connection", None)
+    provider = FakeProvider()
+    dyndns = Updater(
+        provider,
+        ["example.com", "cam.example.com"],
+        tmp_path / "ip.cache",
+        domain_links={"cam.example.com": "wan2"},
+    )
+
+    report = dyndns.run()
+
+    assert report.exit_code == 0
+    assert provider.records == {
+        "example.com": ipaddress.IPv4Address("127.0.0.2"),
+        "cam.example.com": ipaddress.IPv4Address("127.0.0.3"),
+    }
+    assert [result.link for result in report.results] == [None, "wan2"]
+    assert dyndns.run().status == "unchanged"
+
+
+def test_excluded_domains_are_never_updated(tmp_path, monkeypatch):
+    class MatchingProvider(FakeProvider):
+        def find_domains(self, pattern):
+            return ["home.example.com", "mail.example.com"]
+
+    ip = ipaddress.IPv4Address("127.0.0.2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ip)
+    provider = MatchingProvider()
+    dyndns = Updater(
+        provider,
+        ["example.com", "mail.example.org"],
+        tmp_path / "ip.cache",
+        match_patterns=["*.example.com"],
+        exclude_patterns=["mail.*"],
+    )
+
+    report = dyndns.run()
+
+    assert report.exit_code == 0
+    assert provider.records == {"example.com": ip, "home.example.com": ip}
+
+
+def test_overlapping_runs_are_refused(tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.IPv4Address("127.0.0.2"))
+    provider = FakeProvider()
+    dyndns = Updater(provider, ["example.com"], tmp_path / "ip.cache")
+
+    with RunLock(tmp_path / "ip.cache.lock"):
+        report = dyndns.run()
+
+    assert report.exit_code == updater.EXIT_LOCKED
+    assert provider.records == {}
+    assert dyndns.run().exit_code == 0
+
+
+def test_failed_domains_are_retried(tmp_path, monkeypatch):
+    class FlakyProvider(FakeProvider):
+        def ensure_record(self, domain, ip, proxied=False, cached=None, ttl=None):
+            if domain not in self.attempted:
+                self.attempted.add(domain)
+                raise DNSProviderError("Timeout")
+            return super().ensure_record(domain, ip, proxied, cached, ttl)
+
+    ip = ipaddress.IPv4Address("127.0.0.2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ip)
+    provider = FlakyProvider(failing_domains=["broken.example.com"])
+    provider.attempted = set()
+    domains = ["example.com", "broken.example.com"]
+    dyndns = Updater(provider, domains, tmp_path / "ip.cache")
+
+    report = dyndns.run()
+
+    assert report.exit_code == updater.EXIT_PARTIAL_SUCCESS
+    assert report.get_result("A").updated_domains == ["example.com"]
+    assert report.get_result("A").failed_domains == ["broken.example.com"]
+
+
+def test_proxied_per_domain(tmp_path, monkeypatch):
+    ip = ipaddress.IPv4Address("127.0.0.2")
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ip)
+    provider = FakeProvider()
+    domains = ["home.example.com", "vpn.example.com"]
+    cache_file = tmp_path / "ip.cache"
+    domain_proxied = {"home.example.com": True}
+    Updater(provider, domains, cache_file, domain_proxied=domain_proxied).run()
+
+    cache = updater.CacheManager(cache_file).load()
+    assert cache.ipv4.updated_domains["home.example.com"].proxied is True
+    assert cache.ipv4.updated_domains["vpn.example.com"].proxied is False
+
+    # only the domain whose setting changed is updated again
+    domain_proxied = {"home.example.com": True, "vpn.example.com": True}
+    dyndns = Updater(provider, domains, cache_file, domain_proxied=domain_proxied)
+    assert dyndns.run().get_result("A").updated_domains == ["vpn.example.com"]
+
+
+def test_proxied_schedule(tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.IPv4Address("127.0.0.2"))
+    provider = FakeProvider()
+    now = datetime.datetime.now()
+    hours = [(now + datetime.timedelta(hours=h)).time() for h in (-1, 1, 2)]
+    schedules = {"game.example.com": ProxiedSchedule(hours[0], hours[1])}
+    cache_file = tmp_path / "ip.cache"
+    dyndns = Updater(
+        provider, ["game.example.com"], cache_file, proxied_schedules=schedules
+    )
+    report = dyndns.run()
+    assert dyndns.domain_proxied == {"game.example.com": True}
+    assert report.proxied_change_at == pytest.approx(now.timestamp() + 3600, abs=60)
+
+    # the window is over, the proxy is turned off
+    dyndns.proxied_schedules["game.example.com"] = ProxiedSchedule(hours[1], hours[2])
+    assert dyndns.run().get_result("A").updated_domains == ["game.example.com"]
+    assert dyndns.domain_proxied == {"game.example.com": False}
+
+
+def test_maintenance_mode(tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.IPv4Address("127.0.0.2"))
+    provider = FakeProvider()
+    cache_file = tmp_path / "ip.cache"
+    static_ip = ipaddress.IPv4Address("203.0.113.10")
+    write_maintenance(maintenance_file(cache_file), {"A": static_ip})
+    dyndns = Updater(provider, ["example.com"], cache_file)
+
+    report = dyndns.run()
+    assert report.maintenance == {"A": static_ip}
+    assert provider.records == {"example.com": static_ip}
+    # neither forced nor received addresses overwrite it
+    dyndns.run(force=True, addresses={"A": ipaddress.IPv4Address("127.0.0.3")})
+    assert provider.records == {"example.com": static_ip}
+
+    write_maintenance(maintenance_file(cache_file), {})
+    assert dyndns.run().get_result("A").updated_domains == ["example.com"]
+    assert provider.records == {"example.com": ipaddress.IPv4Address("127.0.0.2")}
+
+
+def test_failed_canary_stops_the_update(tmp_path, monkeypatch):
+    class FailingCanary(Canary):
+        def verify(self, ip, proxied):
+            raise CanaryError("it resolves to nothing")
+
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.IPv4Address("127.0.0.2"))
+    provider = FakeProvider()
+    domains = ["example.com", "canary.example.com"]
+    dyndns = Updater(
+        provider, domains, tmp_path / "ip.cache", canary=FailingCanary(domains[1])
+    )
+
+    result = dyndns.run().get_result("A")
+    assert list(provider.records) == ["canary.example.com"]
+    assert result.updated_domains == []
+    assert result.failed_domains == ["canary.example.com", "example.com"]
+    assert "Canary canary.example.com failed" in result.errors[0]
+    ip_cache = CacheManager(tmp_path / "ip.cache").load().ipv4
+    last_error = ip_cache.last_errors["canary.example.com"].message
+    assert last_error == "Canary check failed: it resolves to nothing"
+
+    # the others follow, once the canary works
+    monkeypatch.setattr(Canary, "verify", lambda self, ip, proxied: None)
+    dyndns.canary = Canary(domains[1])
+    result = dyndns.run().get_result("A")
+    assert result.updated_domains == ["canary.example.com", "example.com"]
+
+
+def test_report_file_status(tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.IPv4Address("127.0.0.2"))
+    report_file = tmp_path / "report.json"
+    dyndns = Updater(
+        FakeProvider(), ["example.com"], tmp_path / "ip.cache", report_file=report_file
+    )
+
+    dyndns.run()
+    assert json.loads(report_file.read_text())["status"] == "changed"
+
+    dyndns.run()
+    assert json.loads(report_file.read_text())["status"] == "unchanged"
+
+
+def test_progress_and_summary_table(tmp_path, monkeypatch, capsys):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.IPv4Address("127.0.0.2"))
+    provider = FakeProvider(failing_domains=["b.example.com"])
+    domains = ["a.example.com", "b.example.com", "c.example.com"]
+    dyndns = Updater(provider, domains, tmp_path / "ip.cache", show_progress=True)
+
+    dyndns.run()
+    output = capsys.readouterr().out
+    assert "[1/3] A a.example.com updated\n" in output
+    assert "[2/3] A b.example.com failed (1 failed so far)\n" in output
+    assert "[3/3] A c.example.com updated (1 failed so far)\n" in output
+    assert "Domain         A\n" in output
+    assert "a.example.com  updated\n" in output
+    assert "b.example.com  failed\n" in output
+
+
+def test_paused_updater_only_detects(tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.IPv4Address("127.0.0.2"))
+    provider = FakeProvider()
+    dyndns = Updater(provider, ["example.com"], tmp_path / "ip.cache")
+    dyndns.paused = True
+
+    result = dyndns.run().get_result("A")
+    assert result.paused
+    assert result.new_ip == ipaddress.IPv4Address("127.0.0.2")
+    assert provider.records == {}
+
+    dyndns.paused = False
+    assert dyndns.run().get_result("A").updated_domains == ["example.com"]
+
+    # the records are checked again after resuming, even with the same address
+    dyndns.paused = True
+    dyndns.run()
+    dyndns.paused = False
+    assert dyndns.run().get_result("A").updated_domains == ["example.com"]
+
+
+def test_last_error_of_failing_domains(tmp_path, monkeypatch):
+    monkeypatch.setattr(updater, "get_ipv4", lambda: ipaddress.IPv4Address("127.0.0.2"))
+    provider = FakeProvider(failing_domains=["nas.example.com"])
+    cache_file = tmp_path / "ip.cache"
+    report_file = tmp_path / "report.json"
+    domains = ["example.com", "nas.example.com"]
+    dyndns = Updater(provider, domains, cache_file, report_file=report_file)
+
+    result = dyndns.run().get_result("A")
+    assert list(result.domain_errors) == ["nas.example.com"]
+    assert result.domain_errors["nas.example.com"].message == (
+        "Failed to update nas.example.com"
+    )
+    data = json.loads(report_file.read_text())
+    assert data["results"][0]["domain_errors"]["nas.example.com"]["time"] > 0
+    cache = updater.CacheManager(cache_file).load()
+    assert list(cache.ipv4.last_errors) == ["nas.example.com"]
+
+    # forgotten after it succeeds
+    provider.failing_domains = []
+    assert dyndns.run().get_result("A").domain_errors == {}
+    assert updater.CacheManager(cache_file).load().ipv4.last_errors == {}
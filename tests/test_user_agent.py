@@ -0,0 +1,22 @@
+import requests
+from cloudflare_dyndns import user_agent
+
+
+def test_default_user_agent():
+    assert user_agent.default_user_agent().startswith("cloudflare-dyndns/")
+    assert user_agent.REPO_URL in user_agent.default_user_agent()
+
+
+def test_user_agent_is_replaced(monkeypatch):
+    sent = []
+
+    def send(session, request, **kwargs):
+        sent.append(request.headers["User-Agent"])
+
+    send_with_user_agent = user_agent._send_with_user_agent(send)
+    monkeypatch.setattr(requests.Session, "send", send_with_user_agent)
+    monkeypatch.setattr(user_agent, "_user_agent", "my-agent/1.0")
+
+    requests.Session().send(requests.Request("GET", "https://1.1.1.1/").prepare())
+
+    assert sent == ["my-agent/1.0"]